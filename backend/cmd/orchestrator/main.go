@@ -61,7 +61,7 @@ func main() {
 
 	auth := authenticator.NewAuthenticator(accessPk, refreshPk, accessTTL, refreshTTL)
 
-	app := service.NewService(cfg, repo, repo, repo, auth, bl)
+	app := service.NewService(cfg, repo, repo, repo, repo, auth, bl)
 
 	httpServer := http.NewServer(&http.Config{
 		Host: cfg.Host,
@@ -71,9 +71,10 @@ func main() {
 	server := g.NewServer()
 
 	grpcServer := grpc.NewServer(&grpc.Config{
-		Host:            cfg.Host,
-		GRPCPort:        cfg.GrpcPort,
-		SendTaskBackoff: cfg.PollDelay,
+		Host:               cfg.Host,
+		GRPCPort:           cfg.GrpcPort,
+		SendTaskBackoff:    cfg.PollDelay,
+		LeaseCheckInterval: cfg.LeaseCheckInterval,
 	}, server, logger, app)
 
 	httpServer.Run()
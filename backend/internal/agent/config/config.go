@@ -11,15 +11,19 @@ var (
 	errInvalidWorkersLimit = fmt.Errorf("computing_power must be positive integer")
 	errInvalidMaxRetries   = fmt.Errorf("max_retries must be positive integer")
 	errInvalidBufferSize   = fmt.Errorf("buffer_size must be positive integer")
+	errInvalidRetryBackoff = fmt.Errorf("retry_backoff must be positive duration")
 )
 
 type Config struct {
 	PollTimeout      time.Duration `env:"POLL_TIMEOUT" env-default:"100ms"`
 	WorkersLimit     int           `env:"WORKERS_LIMIT" env-default:"10"`
 	MaxRetries       int           `env:"MAX_RETRIES" env-default:"3"`
+	RetryBackoff     time.Duration `env:"RETRY_BACKOFF" env-default:"1s"`
 	OrchestratorHost string        `env:"ORCHESTRATOR_HOST" env-default:"localhost"`
 	OrchestratorPort int           `env:"ORCHESTRATOR_PORT" env-default:"50051"`
 	BufferSize       int           `env:"BUFFER_SIZE" env-default:"10"`
+	// AgentID names the agent to the orchestrator, the host name by default.
+	AgentID string `env:"AGENT_ID"`
 }
 
 func NewConfig() (*Config, error) {
@@ -45,5 +49,9 @@ func NewConfig() (*Config, error) {
 		return nil, errInvalidBufferSize
 	}
 
+	if cfg.RetryBackoff <= 0 {
+		return nil, errInvalidRetryBackoff
+	}
+
 	return &cfg, nil
 }
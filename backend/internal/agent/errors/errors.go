@@ -3,5 +3,10 @@ package errors
 import "errors"
 
 var (
-	ErrNoTasks = errors.New("no tasks")
+	ErrNoTasks          = errors.New("no tasks")
+	ErrDivisionByZero   = errors.New("division by zero")
+	ErrUnknownOperation = errors.New("unknown operation")
+	ErrUndefined        = errors.New("result is undefined")
+	ErrArgumentsCount   = errors.New("wrong number of arguments")
+	ErrInvalidNumber    = errors.New("invalid number")
 )
@@ -1,17 +1,47 @@
 package models
 
+// Number is a value of an exact evaluation mode. Exactly one field is set.
+type Number struct {
+	Decimal  string    `json:"decimal,omitempty"`
+	Rational *Rational `json:"rational,omitempty"`
+	Complex  *Complex  `json:"complex,omitempty"`
+	Integer  string    `json:"integer,omitempty"`
+}
+
+// Rational is a fraction of arbitrary-precision integers in base 10.
+type Rational struct {
+	Num string `json:"num"`
+	Den string `json:"den"`
+}
+
+type Complex struct {
+	Re float64 `json:"re"`
+	Im float64 `json:"im"`
+}
+
+// Precision is the scale and the rounding mode results of the decimal mode
+// are rounded to.
+type Precision struct {
+	Scale    int32  `json:"scale"`
+	Rounding string `json:"rounding"`
+}
+
 type TaskResult struct {
 	Id     string  `json:"id"`
 	Result float64 `json:"result"`
+	Number *Number `json:"number,omitempty"`
 	Status string  `json:"status"`
 	Final  bool    `json:"final"`
+	// Error is the reason of a failure.
+	Error string `json:"error,omitempty"`
 }
 
 type AgentTask struct {
-	Id            string  `json:"id"`
-	LeftArg       float64 `json:"left_arg"`
-	RightArg      float64 `json:"right_arg"`
-	Op            string  `json:"op"`
-	OperationTime int64   `json:"op_time"`
-	Final         bool    `json:"final"`
+	Id            string     `json:"id"`
+	Args          []float64  `json:"args"`
+	Numbers       []Number   `json:"numbers,omitempty"`
+	Precision     *Precision `json:"precision,omitempty"`
+	Op            string     `json:"op"`
+	OperationTime int64      `json:"op_time"`
+	Final         bool       `json:"final"`
 }
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"fmt"
+	e "github.com/distributed-calc/v1/internal/agent/errors"
+	"github.com/distributed-calc/v1/internal/agent/models"
+	"math"
+	"math/cmplx"
+)
+
+// calculateComplex evaluates a task of the complex mode. The float result is
+// the real part of the complex one.
+func calculateComplex(op string, nums []models.Number) (*models.Number, float64, error) {
+	args := make([]complex128, 0, len(nums))
+	for _, n := range nums {
+		if n.Complex == nil {
+			return nil, 0, fmt.Errorf("%w: operand is not a complex number", e.ErrInvalidNumber)
+		}
+		args = append(args, complex(n.Complex.Re, n.Complex.Im))
+	}
+
+	var res complex128
+	var err error
+	switch op {
+	case "", "neg", "sqrt", "abs", "ln", "log10", "exp", "sin", "cos", "tan", "re", "im", "conj", "arg":
+		if len(args) != 1 {
+			return nil, 0, fmt.Errorf("%w: %q takes 1 argument, got %d", e.ErrArgumentsCount, op, len(args))
+		}
+		res, err = complexUnary(op, args[0])
+	case "+", "-", "*", "/", "^":
+		if len(args) != 2 {
+			return nil, 0, fmt.Errorf("%w: %q takes 2 arguments, got %d", e.ErrArgumentsCount, op, len(args))
+		}
+		res, err = complexBinary(op, args[0], args[1])
+	default:
+		err = fmt.Errorf("%w %s in complex mode", e.ErrUnknownOperation, op)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if cmplx.IsNaN(res) || cmplx.IsInf(res) {
+		return nil, 0, fmt.Errorf("%w: %s is not finite", e.ErrUndefined, op)
+	}
+
+	return &models.Number{Complex: &models.Complex{Re: real(res), Im: imag(res)}}, real(res), nil
+}
+
+func complexUnary(op string, z complex128) (complex128, error) {
+	switch op {
+	case "":
+		return z, nil
+	case "neg":
+		// Unlike -z, keeps zero parts positive, the sign of a zero imaginary
+		// part selects the branch of sqrt and ln.
+		return 0 - z, nil
+	case "sqrt":
+		return cmplx.Sqrt(z), nil
+	case "abs":
+		return complex(cmplx.Abs(z), 0), nil
+	case "ln", "log10":
+		if z == 0 {
+			return 0, fmt.Errorf("%w: logarithm of zero", e.ErrUndefined)
+		}
+		if op == "ln" {
+			return cmplx.Log(z), nil
+		}
+		return cmplx.Log10(z), nil
+	case "exp":
+		return cmplx.Exp(z), nil
+	case "sin":
+		return cmplx.Sin(z), nil
+	case "cos":
+		return cmplx.Cos(z), nil
+	case "tan":
+		return cmplx.Tan(z), nil
+	case "re":
+		return complex(real(z), 0), nil
+	case "im":
+		return complex(imag(z), 0), nil
+	case "conj":
+		return cmplx.Conj(z), nil
+	case "arg":
+		return complex(cmplx.Phase(z), 0), nil
+	}
+
+	return 0, fmt.Errorf("%w %s", e.ErrUnknownOperation, op)
+}
+
+func complexBinary(op string, left, right complex128) (complex128, error) {
+	switch op {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/":
+		if right == 0 {
+			return 0, e.ErrDivisionByZero
+		}
+		return left / right, nil
+	case "^":
+		if left == 0 && real(right) < 0 {
+			return 0, fmt.Errorf("%w: zero to a negative power", e.ErrDivisionByZero)
+		}
+		// Keep integer powers of real numbers real, cmplx.Pow leaves
+		// rounding noise in the imaginary part.
+		if imag(left) == 0 && imag(right) == 0 && real(right) == math.Trunc(real(right)) {
+			return complex(math.Pow(real(left), real(right)), 0), nil
+		}
+		return cmplx.Pow(left, right), nil
+	}
+
+	return 0, fmt.Errorf("%w %s", e.ErrUnknownOperation, op)
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"github.com/distributed-calc/v1/internal/agent/models"
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+func complexes(values ...complex128) []models.Number {
+	nums := make([]models.Number, 0, len(values))
+	for _, v := range values {
+		nums = append(nums, models.Number{Complex: &models.Complex{Re: real(v), Im: imag(v)}})
+	}
+
+	return nums
+}
+
+func TestService_Evaluate_Complex(t *testing.T) {
+	cases := []struct {
+		name     string
+		op       string
+		args     []complex128
+		expected complex128
+		wantErr  bool
+	}{
+		{
+			name:     "multiplication",
+			op:       "*",
+			args:     []complex128{1 + 2i, 3 - 1i},
+			expected: 5 + 5i,
+		},
+		{
+			name:     "division",
+			op:       "/",
+			args:     []complex128{5 + 5i, 3 - 1i},
+			expected: 1 + 2i,
+		},
+		{
+			name:     "integer power of real number",
+			op:       "^",
+			args:     []complex128{-2, 3},
+			expected: -8,
+		},
+		{
+			name:     "i squared",
+			op:       "^",
+			args:     []complex128{1i, 2},
+			expected: -1,
+		},
+		{
+			name:     "square root of negative number",
+			op:       "sqrt",
+			args:     []complex128{-9},
+			expected: 3i,
+		},
+		{
+			name:     "absolute value",
+			op:       "abs",
+			args:     []complex128{3 + 4i},
+			expected: 5,
+		},
+		{
+			name:     "argument",
+			op:       "arg",
+			args:     []complex128{1i},
+			expected: math.Pi / 2,
+		},
+		{
+			name:     "conjugate",
+			op:       "conj",
+			args:     []complex128{1 + 1i},
+			expected: 1 - 1i,
+		},
+		{
+			name:    "division by zero",
+			op:      "/",
+			args:    []complex128{1i, 0},
+			wantErr: true,
+		},
+		{
+			name:    "floor",
+			op:      "floor",
+			args:    []complex128{1.5i},
+			wantErr: true,
+		},
+	}
+
+	service := NewService()
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := service.Evaluate(context.Background(), &models.AgentTask{Id: tc.name, Op: tc.op, Numbers: complexes(tc.args...)})
+			if tc.wantErr && err == nil {
+				t.Error("expected error, got none")
+			}
+
+			if tc.wantErr == false && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			got := complex(r.Number.Complex.Re, r.Number.Complex.Im)
+			if cmplx.Abs(got-tc.expected) > 1e-12 || r.Result != real(got) {
+				t.Errorf("expected %v, got %v (result %v)", tc.expected, got, r.Result)
+			}
+		})
+	}
+}
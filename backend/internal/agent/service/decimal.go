@@ -0,0 +1,105 @@
+package service
+
+import (
+	"fmt"
+	e "github.com/distributed-calc/v1/internal/agent/errors"
+	"github.com/distributed-calc/v1/internal/agent/models"
+	"math/big"
+	"strings"
+)
+
+const (
+	roundingHalfEven = "half_even"
+	roundingHalfUp   = "half_up"
+	roundingHalfDown = "half_down"
+	roundingUp       = "up"
+	roundingDown     = "down"
+	roundingCeiling  = "ceiling"
+	roundingFloor    = "floor"
+)
+
+// calculateDecimal evaluates a task of the decimal mode. Operands are exact
+// decimal strings, the result is rounded to the scale of the task.
+func calculateDecimal(op string, nums []models.Number, p *models.Precision) (*models.Number, float64, error) {
+	if p == nil {
+		return nil, 0, fmt.Errorf("%w: decimal task without precision", e.ErrInvalidNumber)
+	}
+
+	args := make([]*big.Rat, 0, len(nums))
+	for _, n := range nums {
+		x, ok := new(big.Rat).SetString(n.Decimal)
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: %q is not a decimal", e.ErrInvalidNumber, n.Decimal)
+		}
+		args = append(args, x)
+	}
+
+	res, err := exact(op, args, p)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	res = roundDecimal(res, p.Scale, p.Rounding)
+	f, _ := res.Float64()
+
+	return &models.Number{Decimal: formatDecimal(res, p.Scale)}, f, nil
+}
+
+// roundDecimal rounds x to scale digits after the decimal point, a negative
+// scale rounds to tens, hundreds and so on.
+func roundDecimal(x *big.Rat, scale int32, rounding string) *big.Rat {
+	pow := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs32(scale))), nil))
+	if scale < 0 {
+		pow.Inv(pow)
+	}
+
+	scaled := new(big.Rat).Mul(x, pow)
+	q, r := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+
+	if r.Sign() != 0 {
+		// half compares the discarded fraction to one half
+		half := new(big.Int).Lsh(new(big.Int).Abs(r), 1).Cmp(scaled.Denom())
+
+		var away bool
+		switch rounding {
+		case roundingUp:
+			away = true
+		case roundingDown:
+			away = false
+		case roundingCeiling:
+			away = x.Sign() > 0
+		case roundingFloor:
+			away = x.Sign() < 0
+		case roundingHalfUp:
+			away = half >= 0
+		case roundingHalfDown:
+			away = half > 0
+		default:
+			away = half > 0 || (half == 0 && q.Bit(0) == 1)
+		}
+
+		if away {
+			q.Add(q, big.NewInt(int64(x.Sign())))
+		}
+	}
+
+	return new(big.Rat).Quo(new(big.Rat).SetInt(q), pow)
+}
+
+// formatDecimal formats x rounded to scale without trailing zeros.
+func formatDecimal(x *big.Rat, scale int32) string {
+	s := x.FloatString(int(max(scale, 0)))
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+
+	return s
+}
+
+func abs32(x int32) int32 {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"github.com/distributed-calc/v1/internal/agent/models"
+	"math/big"
+	"testing"
+)
+
+func decimals(values ...string) []models.Number {
+	nums := make([]models.Number, 0, len(values))
+	for _, v := range values {
+		nums = append(nums, models.Number{Decimal: v})
+	}
+
+	return nums
+}
+
+func TestService_Evaluate_Decimal(t *testing.T) {
+	cases := []struct {
+		name      string
+		op        string
+		args      []string
+		precision *models.Precision
+		expected  string
+		wantErr   bool
+	}{
+		{
+			name:      "addition is exact",
+			op:        "+",
+			args:      []string{"0.1", "0.2"},
+			precision: &models.Precision{Scale: 20, Rounding: roundingHalfEven},
+			expected:  "0.3",
+		},
+		{
+			name:      "division is rounded to scale",
+			op:        "/",
+			args:      []string{"2", "3"},
+			precision: &models.Precision{Scale: 5, Rounding: roundingHalfUp},
+			expected:  "0.66667",
+		},
+		{
+			name:      "division is truncated",
+			op:        "/",
+			args:      []string{"2", "3"},
+			precision: &models.Precision{Scale: 5, Rounding: roundingDown},
+			expected:  "0.66666",
+		},
+		{
+			name:      "integer power",
+			op:        "^",
+			args:      []string{"1.1", "-2"},
+			precision: &models.Precision{Scale: 10, Rounding: roundingHalfEven},
+			expected:  "0.826446281",
+		},
+		{
+			name:      "square root",
+			op:        "sqrt",
+			args:      []string{"2"},
+			precision: &models.Precision{Scale: 30, Rounding: roundingDown},
+			expected:  "1.414213562373095048801688724209",
+		},
+		{
+			name:      "modulo of negative number",
+			op:        "%",
+			args:      []string{"-7.5", "2"},
+			precision: &models.Precision{Scale: 2, Rounding: roundingHalfEven},
+			expected:  "0.5",
+		},
+		{
+			name:      "round to digits",
+			op:        "round",
+			args:      []string{"2.345", "2"},
+			precision: &models.Precision{Scale: 10, Rounding: roundingHalfEven},
+			expected:  "2.34",
+		},
+		{
+			name:      "fractional power",
+			op:        "^",
+			args:      []string{"2", "0.5"},
+			precision: &models.Precision{Scale: 10},
+			wantErr:   true,
+		},
+		{
+			name:      "division by zero",
+			op:        "/",
+			args:      []string{"1", "0"},
+			precision: &models.Precision{Scale: 10},
+			wantErr:   true,
+		},
+		{
+			name:      "unsupported function",
+			op:        "sin",
+			args:      []string{"1"},
+			precision: &models.Precision{Scale: 10},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid decimal",
+			op:        "+",
+			args:      []string{"1", "x"},
+			precision: &models.Precision{Scale: 10},
+			wantErr:   true,
+		},
+	}
+
+	service := NewService()
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := service.Evaluate(context.Background(), &models.AgentTask{
+				Id:        tc.name,
+				Op:        tc.op,
+				Numbers:   decimals(tc.args...),
+				Precision: tc.precision,
+			})
+			if tc.wantErr && err == nil {
+				t.Error("expected error, got none")
+			}
+
+			if tc.wantErr == false && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if r.Number == nil || r.Number.Decimal != tc.expected {
+				t.Errorf("expected %s, got %v", tc.expected, r.Number)
+			}
+		})
+	}
+}
+
+func TestRoundDecimal(t *testing.T) {
+	cases := []struct {
+		x        string
+		rounding string
+		expected string
+	}{
+		{"2.5", roundingHalfEven, "2"},
+		{"3.5", roundingHalfEven, "4"},
+		{"-2.5", roundingHalfEven, "-2"},
+		{"2.5", roundingHalfUp, "3"},
+		{"-2.5", roundingHalfUp, "-3"},
+		{"2.5", roundingHalfDown, "2"},
+		{"2.6", roundingHalfDown, "3"},
+		{"2.1", roundingUp, "3"},
+		{"-2.1", roundingUp, "-3"},
+		{"2.9", roundingDown, "2"},
+		{"-2.9", roundingDown, "-2"},
+		{"-2.1", roundingCeiling, "-2"},
+		{"2.1", roundingCeiling, "3"},
+		{"-2.1", roundingFloor, "-3"},
+		{"2.9", roundingFloor, "2"},
+	}
+
+	for _, tc := range cases {
+		x, _ := new(big.Rat).SetString(tc.x)
+		if got := formatDecimal(roundDecimal(x, 0, tc.rounding), 0); got != tc.expected {
+			t.Errorf("%s rounded %s: expected %s, got %s", tc.x, tc.rounding, tc.expected, got)
+		}
+	}
+
+	x, _ := new(big.Rat).SetString("1250")
+	if got := formatDecimal(roundDecimal(x, -2, roundingHalfEven), 0); got != "1200" {
+		t.Errorf("1250 rounded to hundreds: expected 1200, got %s", got)
+	}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"fmt"
+	e "github.com/distributed-calc/v1/internal/agent/errors"
+	"github.com/distributed-calc/v1/internal/agent/models"
+	"math/big"
+)
+
+// maxExponent limits '^' in exact modes, the power is computed exactly
+// before rounding.
+const maxExponent = 10000
+
+// maxPowerBits limits the size of powers in exact modes, bases of large
+// powers may be results of powers themselves.
+const maxPowerBits = 1 << 20
+
+// powerTooLarge reports whether x^exp would have more than maxPowerBits
+// bits, exp must not exceed maxExponent.
+func powerTooLarge(x, exp *big.Int) bool {
+	return int64(x.BitLen())*exp.Int64() > maxPowerBits
+}
+
+// exact evaluates op on exact operands of the decimal and the rational modes.
+// p is the precision of the decimal mode, it is nil in the rational mode.
+func exact(op string, args []*big.Rat, p *models.Precision) (*big.Rat, error) {
+	switch op {
+	case "", "neg", "sqrt", "abs", "floor", "ceil", "not":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%w: %q takes 1 argument, got %d", e.ErrArgumentsCount, op, len(args))
+		}
+		return exactUnary(op, args[0], p)
+	case "+", "-", "*", "/", "//", "%", "^", "<", "<=", ">", ">=", "==", "!=", "and", "or":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%w: %q takes 2 arguments, got %d", e.ErrArgumentsCount, op, len(args))
+		}
+		return exactBinary(op, args[0], args[1])
+	case "min", "max":
+		if len(args) < 1 {
+			return nil, fmt.Errorf("%w: %q takes at least 1 argument", e.ErrArgumentsCount, op)
+		}
+		res := args[0]
+		for _, arg := range args[1:] {
+			if (op == "min" && arg.Cmp(res) < 0) || (op == "max" && arg.Cmp(res) > 0) {
+				res = arg
+			}
+		}
+		return res, nil
+	case "round":
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("%w: %q takes 1 or 2 arguments, got %d", e.ErrArgumentsCount, op, len(args))
+		}
+		rounding := roundingHalfEven
+		if p != nil {
+			rounding = p.Rounding
+		}
+		if len(args) == 1 {
+			return roundDecimal(args[0], 0, rounding), nil
+		}
+		digits := args[1]
+		if !digits.IsInt() || digits.Num().CmpAbs(big.NewInt(maxExponent)) > 0 {
+			return nil, fmt.Errorf("%w: round to %s digits", e.ErrUndefined, digits.RatString())
+		}
+		return roundDecimal(args[0], int32(digits.Num().Int64()), rounding), nil
+	}
+
+	return nil, fmt.Errorf("%w %s in exact mode", e.ErrUnknownOperation, op)
+}
+
+func exactUnary(op string, x *big.Rat, p *models.Precision) (*big.Rat, error) {
+	switch op {
+	case "":
+		return x, nil
+	case "neg":
+		return new(big.Rat).Neg(x), nil
+	case "abs":
+		return new(big.Rat).Abs(x), nil
+	case "floor":
+		return floorRat(x), nil
+	case "ceil":
+		return new(big.Rat).Neg(floorRat(new(big.Rat).Neg(x))), nil
+	case "sqrt":
+		if p == nil {
+			return nil, fmt.Errorf("%w: square root is not exact in rational mode", e.ErrUnknownOperation)
+		}
+		if x.Sign() < 0 {
+			return nil, fmt.Errorf("%w: square root of negative number %s", e.ErrUndefined, x.RatString())
+		}
+		// Enough bits for the integer part and the requested scale, so the
+		// result is correct after rounding.
+		prec := uint(x.Num().BitLen()) + 4*uint(p.Scale) + 64
+		f := new(big.Float).SetPrec(prec).SetRat(x)
+		res, _ := f.Sqrt(f).Rat(nil)
+		return res, nil
+	case "not":
+		return big.NewRat(int64(boolean(x.Sign() == 0)), 1), nil
+	}
+
+	return nil, fmt.Errorf("%w %s in exact mode", e.ErrUnknownOperation, op)
+}
+
+func exactBinary(op string, left, right *big.Rat) (*big.Rat, error) {
+	switch op {
+	case "+":
+		return new(big.Rat).Add(left, right), nil
+	case "-":
+		return new(big.Rat).Sub(left, right), nil
+	case "*":
+		return new(big.Rat).Mul(left, right), nil
+	case "/", "//", "%":
+		if right.Sign() == 0 {
+			return nil, e.ErrDivisionByZero
+		}
+		quo := new(big.Rat).Quo(left, right)
+		switch op {
+		case "/":
+			return quo, nil
+		case "//":
+			return floorRat(quo), nil
+		}
+		// Floored modulo, so that a == (a // b) * b + a % b holds
+		return new(big.Rat).Sub(left, new(big.Rat).Mul(right, floorRat(quo))), nil
+	case "^":
+		if !right.IsInt() {
+			return nil, fmt.Errorf("%w: fractional power %s in exact mode", e.ErrUndefined, right.RatString())
+		}
+		if left.Sign() == 0 && right.Sign() < 0 {
+			return nil, fmt.Errorf("%w: zero to a negative power", e.ErrDivisionByZero)
+		}
+		exp := new(big.Int).Abs(right.Num())
+		if exp.Cmp(big.NewInt(maxExponent)) > 0 || powerTooLarge(left.Num(), exp) || powerTooLarge(left.Denom(), exp) {
+			return nil, fmt.Errorf("%w: exponent %s is too large", e.ErrUndefined, right.RatString())
+		}
+		res := new(big.Rat).SetFrac(
+			new(big.Int).Exp(left.Num(), exp, nil),
+			new(big.Int).Exp(left.Denom(), exp, nil),
+		)
+		if right.Sign() < 0 {
+			res.Inv(res)
+		}
+		return res, nil
+	case "<", "<=", ">", ">=", "==", "!=":
+		return big.NewRat(int64(boolean(compare(op, left.Cmp(right)))), 1), nil
+	case "and":
+		return big.NewRat(int64(boolean(left.Sign() != 0 && right.Sign() != 0)), 1), nil
+	case "or":
+		return big.NewRat(int64(boolean(left.Sign() != 0 || right.Sign() != 0)), 1), nil
+	}
+
+	return nil, fmt.Errorf("%w %s in exact mode", e.ErrUnknownOperation, op)
+}
+
+// floorRat returns the greatest integer not greater than x.
+func floorRat(x *big.Rat) *big.Rat {
+	// Denominator of a big.Rat is positive, so the Euclidean division is
+	// the floor division.
+	return new(big.Rat).SetInt(new(big.Int).Div(x.Num(), x.Denom()))
+}
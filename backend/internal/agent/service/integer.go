@@ -0,0 +1,137 @@
+package service
+
+import (
+	"fmt"
+	e "github.com/distributed-calc/v1/internal/agent/errors"
+	"github.com/distributed-calc/v1/internal/agent/models"
+	"math/big"
+)
+
+// maxShift limits '<<' and '>>' in the integer mode.
+const maxShift = 1 << 16
+
+// calculateInteger evaluates a task of the integer mode. Operands and the
+// result are arbitrary-precision integers, negative numbers behave as in
+// two's complement for bitwise operators.
+func calculateInteger(op string, nums []models.Number) (*models.Number, float64, error) {
+	args := make([]*big.Int, 0, len(nums))
+	for _, n := range nums {
+		x, ok := new(big.Int).SetString(n.Integer, 10)
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: %q is not an integer", e.ErrInvalidNumber, n.Integer)
+		}
+		args = append(args, x)
+	}
+
+	res, err := integer(op, args)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f, _ := new(big.Float).SetInt(res).Float64()
+
+	return &models.Number{Integer: res.String()}, f, nil
+}
+
+func integer(op string, args []*big.Int) (*big.Int, error) {
+	switch op {
+	case "", "neg", "abs", "~", "not":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%w: %q takes 1 argument, got %d", e.ErrArgumentsCount, op, len(args))
+		}
+		return integerUnary(op, args[0])
+	case "+", "-", "*", "//", "%", "^", "&", "|", "xor", "<<", ">>", "<", "<=", ">", ">=", "==", "!=", "and", "or":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%w: %q takes 2 arguments, got %d", e.ErrArgumentsCount, op, len(args))
+		}
+		return integerBinary(op, args[0], args[1])
+	case "min", "max":
+		if len(args) < 1 {
+			return nil, fmt.Errorf("%w: %q takes at least 1 argument", e.ErrArgumentsCount, op)
+		}
+		res := args[0]
+		for _, arg := range args[1:] {
+			if (op == "min" && arg.Cmp(res) < 0) || (op == "max" && arg.Cmp(res) > 0) {
+				res = arg
+			}
+		}
+		return res, nil
+	}
+
+	return nil, fmt.Errorf("%w %s in integer mode", e.ErrUnknownOperation, op)
+}
+
+func integerUnary(op string, x *big.Int) (*big.Int, error) {
+	switch op {
+	case "":
+		return x, nil
+	case "neg":
+		return new(big.Int).Neg(x), nil
+	case "abs":
+		return new(big.Int).Abs(x), nil
+	case "~":
+		return new(big.Int).Not(x), nil
+	case "not":
+		return big.NewInt(int64(boolean(x.Sign() == 0))), nil
+	}
+
+	return nil, fmt.Errorf("%w %s in integer mode", e.ErrUnknownOperation, op)
+}
+
+func integerBinary(op string, left, right *big.Int) (*big.Int, error) {
+	switch op {
+	case "+":
+		return new(big.Int).Add(left, right), nil
+	case "-":
+		return new(big.Int).Sub(left, right), nil
+	case "*":
+		return new(big.Int).Mul(left, right), nil
+	case "//", "%":
+		if right.Sign() == 0 {
+			return nil, e.ErrDivisionByZero
+		}
+		// Floored like in the other modes, QuoRem truncates towards zero.
+		q, r := new(big.Int).QuoRem(left, right, new(big.Int))
+		if r.Sign() != 0 && r.Sign() != right.Sign() {
+			q.Sub(q, big.NewInt(1))
+			r.Add(r, right)
+		}
+		if op == "//" {
+			return q, nil
+		}
+		return r, nil
+	case "^":
+		if right.Sign() < 0 {
+			return nil, fmt.Errorf("%w: negative power %s in integer mode", e.ErrUndefined, right)
+		}
+		if right.Cmp(big.NewInt(maxExponent)) > 0 || powerTooLarge(left, right) {
+			return nil, fmt.Errorf("%w: power %s is too large", e.ErrUndefined, right)
+		}
+		return new(big.Int).Exp(left, right, nil), nil
+	case "&":
+		return new(big.Int).And(left, right), nil
+	case "|":
+		return new(big.Int).Or(left, right), nil
+	case "xor":
+		return new(big.Int).Xor(left, right), nil
+	case "<<", ">>":
+		if right.Sign() < 0 {
+			return nil, fmt.Errorf("%w: negative shift count %s", e.ErrUndefined, right)
+		}
+		if right.Cmp(big.NewInt(maxShift)) > 0 {
+			return nil, fmt.Errorf("%w: shift count %s is too large", e.ErrUndefined, right)
+		}
+		if op == "<<" {
+			return new(big.Int).Lsh(left, uint(right.Uint64())), nil
+		}
+		return new(big.Int).Rsh(left, uint(right.Uint64())), nil
+	case "<", "<=", ">", ">=", "==", "!=":
+		return big.NewInt(int64(boolean(compare(op, left.Cmp(right))))), nil
+	case "and":
+		return big.NewInt(int64(boolean(left.Sign() != 0 && right.Sign() != 0))), nil
+	case "or":
+		return big.NewInt(int64(boolean(left.Sign() != 0 || right.Sign() != 0))), nil
+	}
+
+	return nil, fmt.Errorf("%w %s in integer mode", e.ErrUnknownOperation, op)
+}
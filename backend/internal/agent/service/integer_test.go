@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"github.com/distributed-calc/v1/internal/agent/models"
+	"testing"
+)
+
+func bigint(s string) models.Number {
+	return models.Number{Integer: s}
+}
+
+func TestService_Evaluate_Integer(t *testing.T) {
+	cases := []struct {
+		name     string
+		op       string
+		args     []models.Number
+		expected string
+		approx   float64
+		wantErr  bool
+	}{
+		{
+			name:     "beyond float precision",
+			op:       "+",
+			args:     []models.Number{bigint("9007199254740992"), bigint("1")},
+			expected: "9007199254740993",
+			approx:   9007199254740992,
+		},
+		{
+			name:     "floored division",
+			op:       "//",
+			args:     []models.Number{bigint("-7"), bigint("2")},
+			expected: "-4",
+			approx:   -4,
+		},
+		{
+			name:     "floored modulo",
+			op:       "%",
+			args:     []models.Number{bigint("7"), bigint("-2")},
+			expected: "-1",
+			approx:   -1,
+		},
+		{
+			name:     "power",
+			op:       "^",
+			args:     []models.Number{bigint("2"), bigint("100")},
+			expected: "1267650600228229401496703205376",
+			approx:   1267650600228229401496703205376,
+		},
+		{
+			name:     "and",
+			op:       "&",
+			args:     []models.Number{bigint("12"), bigint("10")},
+			expected: "8",
+			approx:   8,
+		},
+		{
+			name:     "or",
+			op:       "|",
+			args:     []models.Number{bigint("12"), bigint("10")},
+			expected: "14",
+			approx:   14,
+		},
+		{
+			name:     "xor",
+			op:       "xor",
+			args:     []models.Number{bigint("12"), bigint("10")},
+			expected: "6",
+			approx:   6,
+		},
+		{
+			name:     "not",
+			op:       "~",
+			args:     []models.Number{bigint("5")},
+			expected: "-6",
+			approx:   -6,
+		},
+		{
+			name:     "and of negative number",
+			op:       "&",
+			args:     []models.Number{bigint("-1"), bigint("255")},
+			expected: "255",
+			approx:   255,
+		},
+		{
+			name:     "left shift",
+			op:       "<<",
+			args:     []models.Number{bigint("1"), bigint("64")},
+			expected: "18446744073709551616",
+			approx:   18446744073709551616,
+		},
+		{
+			name:     "right shift",
+			op:       ">>",
+			args:     []models.Number{bigint("-9"), bigint("1")},
+			expected: "-5",
+			approx:   -5,
+		},
+		{
+			name:     "equality beyond float precision",
+			op:       "==",
+			args:     []models.Number{bigint("9007199254740993"), bigint("9007199254740992")},
+			expected: "0",
+			approx:   0,
+		},
+		{
+			name:     "logical or",
+			op:       "or",
+			args:     []models.Number{bigint("0"), bigint("-3")},
+			expected: "1",
+			approx:   1,
+		},
+		{
+			name:    "negative shift",
+			op:      "<<",
+			args:    []models.Number{bigint("1"), bigint("-1")},
+			wantErr: true,
+		},
+		{
+			name:    "negative power",
+			op:      "^",
+			args:    []models.Number{bigint("2"), bigint("-1")},
+			wantErr: true,
+		},
+		{
+			name:    "division by zero",
+			op:      "//",
+			args:    []models.Number{bigint("1"), bigint("0")},
+			wantErr: true,
+		},
+		{
+			name:    "true division",
+			op:      "/",
+			args:    []models.Number{bigint("1"), bigint("2")},
+			wantErr: true,
+		},
+		{
+			name:    "invalid number",
+			op:      "+",
+			args:    []models.Number{bigint("0x10"), bigint("1")},
+			wantErr: true,
+		},
+	}
+
+	service := NewService()
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := service.Evaluate(context.Background(), &models.AgentTask{Id: tc.name, Op: tc.op, Numbers: tc.args})
+			if tc.wantErr && err == nil {
+				t.Error("expected error, got none")
+			}
+
+			if tc.wantErr == false && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if r.Number == nil || r.Number.Integer != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, r.Number)
+			}
+
+			if r.Result != tc.approx {
+				t.Errorf("expected approximation %v, got %v", tc.approx, r.Result)
+			}
+		})
+	}
+}
+
+func TestService_Evaluate_IntegerChainedPowers(t *testing.T) {
+	service := NewService()
+	ctx := context.Background()
+
+	// Every power is within maxExponent, but ((2^10000)^10000)^10000 has
+	// about 10^12 bits.
+	r, err := service.Evaluate(ctx, &models.AgentTask{Id: "1", Op: "^", Numbers: []models.Number{bigint("2"), bigint("10000")}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = service.Evaluate(ctx, &models.AgentTask{Id: "2", Op: "^", Numbers: []models.Number{*r.Number, bigint("10000")}})
+	if err == nil {
+		t.Error("expected a power of a large base to be rejected")
+	}
+}
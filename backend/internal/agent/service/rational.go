@@ -0,0 +1,45 @@
+package service
+
+import (
+	"fmt"
+	e "github.com/distributed-calc/v1/internal/agent/errors"
+	"github.com/distributed-calc/v1/internal/agent/models"
+	"math/big"
+)
+
+// calculateRational evaluates a task of the rational mode. Operands and the
+// result are exact fractions.
+func calculateRational(op string, nums []models.Number) (*models.Number, float64, error) {
+	args := make([]*big.Rat, 0, len(nums))
+	for _, n := range nums {
+		if n.Rational == nil {
+			return nil, 0, fmt.Errorf("%w: operand is not a rational", e.ErrInvalidNumber)
+		}
+
+		num, ok := new(big.Int).SetString(n.Rational.Num, 10)
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: %q is not an integer", e.ErrInvalidNumber, n.Rational.Num)
+		}
+
+		den, ok := new(big.Int).SetString(n.Rational.Den, 10)
+		if !ok || den.Sign() == 0 {
+			return nil, 0, fmt.Errorf("%w: %q is not a denominator", e.ErrInvalidNumber, n.Rational.Den)
+		}
+
+		args = append(args, new(big.Rat).SetFrac(num, den))
+	}
+
+	res, err := exact(op, args, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f, _ := res.Float64()
+
+	return &models.Number{
+		Rational: &models.Rational{
+			Num: res.Num().String(),
+			Den: res.Denom().String(),
+		},
+	}, f, nil
+}
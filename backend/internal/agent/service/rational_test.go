@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"github.com/distributed-calc/v1/internal/agent/models"
+	"testing"
+)
+
+func rational(num, den string) models.Number {
+	return models.Number{Rational: &models.Rational{Num: num, Den: den}}
+}
+
+func TestService_Evaluate_Rational(t *testing.T) {
+	cases := []struct {
+		name     string
+		op       string
+		args     []models.Number
+		expected models.Rational
+		approx   float64
+		wantErr  bool
+	}{
+		{
+			name:     "sum is reduced",
+			op:       "+",
+			args:     []models.Number{rational("1", "6"), rational("1", "3")},
+			expected: models.Rational{Num: "1", Den: "2"},
+			approx:   0.5,
+		},
+		{
+			name:     "division",
+			op:       "/",
+			args:     []models.Number{rational("7", "1"), rational("-3", "1")},
+			expected: models.Rational{Num: "-7", Den: "3"},
+			approx:   -7.0 / 3,
+		},
+		{
+			name:     "beyond float precision",
+			op:       "*",
+			args:     []models.Number{rational("9007199254740993", "1"), rational("1", "9007199254740993")},
+			expected: models.Rational{Num: "1", Den: "1"},
+			approx:   1,
+		},
+		{
+			name:     "round",
+			op:       "round",
+			args:     []models.Number{rational("5", "2")},
+			expected: models.Rational{Num: "2", Den: "1"},
+			approx:   2,
+		},
+		{
+			name:     "comparison",
+			op:       "<",
+			args:     []models.Number{rational("1", "3"), rational("333", "1000")},
+			expected: models.Rational{Num: "0", Den: "1"},
+			approx:   0,
+		},
+		{
+			name:    "square root",
+			op:      "sqrt",
+			args:    []models.Number{rational("4", "1")},
+			wantErr: true,
+		},
+		{
+			name:    "zero denominator",
+			op:      "+",
+			args:    []models.Number{rational("1", "0"), rational("1", "1")},
+			wantErr: true,
+		},
+	}
+
+	service := NewService()
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := service.Evaluate(context.Background(), &models.AgentTask{Id: tc.name, Op: tc.op, Numbers: tc.args})
+			if tc.wantErr && err == nil {
+				t.Error("expected error, got none")
+			}
+
+			if tc.wantErr == false && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if r.Number == nil || r.Number.Rational == nil || *r.Number.Rational != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, r.Number)
+			}
+
+			if r.Result != tc.approx {
+				t.Errorf("expected approximation %v, got %v", tc.approx, r.Result)
+			}
+		})
+	}
+}
+
+func TestService_Evaluate_RationalChainedPowers(t *testing.T) {
+	service := NewService()
+	ctx := context.Background()
+
+	r, err := service.Evaluate(ctx, &models.AgentTask{Id: "1", Op: "^", Numbers: []models.Number{rational("1", "2"), rational("10000", "1")}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The denominator of the base is large, though the base is small.
+	_, err = service.Evaluate(ctx, &models.AgentTask{Id: "2", Op: "^", Numbers: []models.Number{*r.Number, rational("10000", "1")}})
+	if err == nil {
+		t.Error("expected a power of a large base to be rejected")
+	}
+}
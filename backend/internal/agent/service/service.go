@@ -1,8 +1,11 @@
 package service
 
 import (
+	"context"
 	"fmt"
+	e "github.com/distributed-calc/v1/internal/agent/errors"
 	"github.com/distributed-calc/v1/internal/agent/models"
+	"math"
 	"time"
 )
 
@@ -17,58 +20,273 @@ func NewService() *Service {
 	return &Service{}
 }
 
-func (s *Service) Evaluate(t *models.AgentTask) (*models.TaskResult, error) {
-	time.Sleep(time.Duration(t.OperationTime) * time.Millisecond)
+// Evaluate computes the task once its operation time passes. A task whose
+// ctx is cancelled meanwhile is aborted without a result.
+func (s *Service) Evaluate(ctx context.Context, t *models.AgentTask) (*models.TaskResult, error) {
+	timer := time.NewTimer(time.Duration(t.OperationTime) * time.Millisecond)
+	defer timer.Stop()
 
-	switch t.Op {
-	case "+":
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("task %s aborted: %w", t.Id, ctx.Err())
+	case <-timer.C:
+	}
+
+	var result float64
+	var number *models.Number
+	var err error
+	if len(t.Numbers) > 0 {
+		number, result, err = calculateNumbers(t)
+	} else {
+		result, err = calculate(t.Op, t.Args)
+	}
+	if err != nil {
 		return &models.TaskResult{
 			Id:     t.Id,
-			Result: t.LeftArg + t.RightArg,
-			Status: statusSuccess,
+			Status: statusFailure,
 			Final:  t.Final,
-		}, nil
+			Error:  err.Error(),
+		}, err
+	}
+
+	return &models.TaskResult{
+		Id:     t.Id,
+		Result: result,
+		Number: number,
+		Status: statusSuccess,
+		Final:  t.Final,
+	}, nil
+}
+
+// calculateNumbers evaluates a task of an exact mode, dispatching on the type
+// of its operands.
+func calculateNumbers(t *models.AgentTask) (*models.Number, float64, error) {
+	switch {
+	case t.Numbers[0].Decimal != "":
+		return calculateDecimal(t.Op, t.Numbers, t.Precision)
+	case t.Numbers[0].Rational != nil:
+		return calculateRational(t.Op, t.Numbers)
+	case t.Numbers[0].Complex != nil:
+		return calculateComplex(t.Op, t.Numbers)
+	case t.Numbers[0].Integer != "":
+		return calculateInteger(t.Op, t.Numbers)
+	}
+
+	return nil, 0, fmt.Errorf("%w: unknown type of number", e.ErrInvalidNumber)
+}
+
+// calculate evaluates a task of the float mode. Results which overflow or
+// are undefined, like exp(1000) or sin of an infinite operand, are rejected
+// as they cannot be stored.
+func calculate(op string, args []float64) (float64, error) {
+	res, err := calculateFloat(op, args)
+	if err != nil {
+		return 0, err
+	}
+
+	if math.IsNaN(res) || math.IsInf(res, 0) {
+		return 0, fmt.Errorf("%w: result of %q is not finite", e.ErrUndefined, op)
+	}
+
+	return res, nil
+}
+
+func calculateFloat(op string, args []float64) (float64, error) {
+	switch op {
+	case "", "neg", "sqrt", "abs", "ln", "log10", "exp", "sin", "cos", "tan", "floor", "ceil", "re", "im", "conj", "arg", "not":
+		if len(args) != 1 {
+			return 0, fmt.Errorf("%w: %q takes 1 argument, got %d", e.ErrArgumentsCount, op, len(args))
+		}
+		return unary(op, args[0])
+	case "+", "-", "*", "/", "//", "%", "^", "<", "<=", ">", ">=", "==", "!=", "and", "or":
+		if len(args) != 2 {
+			return 0, fmt.Errorf("%w: %q takes 2 arguments, got %d", e.ErrArgumentsCount, op, len(args))
+		}
+		return binary(op, args[0], args[1])
+	case "min", "max":
+		if len(args) < 1 {
+			return 0, fmt.Errorf("%w: %q takes at least 1 argument", e.ErrArgumentsCount, op)
+		}
+		res := args[0]
+		for _, arg := range args[1:] {
+			if op == "min" {
+				res = math.Min(res, arg)
+			} else {
+				res = math.Max(res, arg)
+			}
+		}
+		return res, nil
+	case opCompensatedSum:
+		if len(args) < 1 {
+			return 0, fmt.Errorf("%w: %q takes at least 1 argument", e.ErrArgumentsCount, op)
+		}
+		return compensatedSum(args), nil
+	case "round":
+		if len(args) < 1 || len(args) > 2 {
+			return 0, fmt.Errorf("%w: %q takes 1 or 2 arguments, got %d", e.ErrArgumentsCount, op, len(args))
+		}
+		if len(args) == 1 {
+			return math.Round(args[0]), nil
+		}
+		if args[1] != math.Trunc(args[1]) {
+			return 0, fmt.Errorf("%w: round to %v digits", e.ErrUndefined, args[1])
+		}
+		scale := math.Pow(10, args[1])
+		return math.Round(args[0]*scale) / scale, nil
+	}
+
+	return 0, fmt.Errorf("%w %s", e.ErrUnknownOperation, op)
+}
+
+// opCompensatedSum sums many numbers at once keeping the rounding error of
+// the partial sums.
+const opCompensatedSum = "ksum"
+
+// compensatedSum is the Neumaier variant of the Kahan summation, which also
+// handles addends larger than the running sum.
+func compensatedSum(args []float64) float64 {
+	var sum, c float64
+	for _, x := range args {
+		t := sum + x
+		if math.Abs(sum) >= math.Abs(x) {
+			c += (sum - t) + x
+		} else {
+			c += (x - t) + sum
+		}
+		sum = t
+	}
+
+	return sum + c
+}
+
+func unary(op string, x float64) (float64, error) {
+	switch op {
+	case "":
+		return x, nil
+	case "neg":
+		return -x, nil
+	case "sqrt":
+		if x < 0 {
+			return 0, fmt.Errorf("%w: square root of negative number %v", e.ErrUndefined, x)
+		}
+		return math.Sqrt(x), nil
+	case "abs":
+		return math.Abs(x), nil
+	case "ln", "log10":
+		if x <= 0 {
+			return 0, fmt.Errorf("%w: logarithm of non-positive number %v", e.ErrUndefined, x)
+		}
+		if op == "ln" {
+			return math.Log(x), nil
+		}
+		return math.Log10(x), nil
+	case "exp":
+		return math.Exp(x), nil
+	case "sin":
+		return math.Sin(x), nil
+	case "cos":
+		return math.Cos(x), nil
+	case "tan":
+		return math.Tan(x), nil
+	case "floor":
+		return math.Floor(x), nil
+	case "ceil":
+		return math.Ceil(x), nil
+	case "re", "conj":
+		return x, nil
+	case "im":
+		return 0, nil
+	case "arg":
+		return math.Atan2(0, x), nil
+	case "not":
+		return boolean(x == 0), nil
+	}
+
+	return 0, fmt.Errorf("%w %s", e.ErrUnknownOperation, op)
+}
+
+func binary(op string, left, right float64) (float64, error) {
+	switch op {
+	case "+":
+		return left + right, nil
 	case "-":
-		return &models.TaskResult{
-			Id:     t.Id,
-			Result: t.LeftArg - t.RightArg,
-			Status: statusSuccess,
-			Final:  t.Final,
-		}, nil
+		return left - right, nil
 	case "*":
-		return &models.TaskResult{
-			Id:     t.Id,
-			Result: t.LeftArg * t.RightArg,
-			Status: statusSuccess,
-			Final:  t.Final,
-		}, nil
+		return left * right, nil
 	case "/":
-		if t.RightArg == 0 {
-			return &models.TaskResult{
-				Id:     t.Id,
-				Status: statusFailure,
-				Final:  t.Final,
-			}, fmt.Errorf("division by zero")
+		if right == 0 {
+			return 0, e.ErrDivisionByZero
+		}
+		return left / right, nil
+	case "//":
+		if right == 0 {
+			return 0, e.ErrDivisionByZero
+		}
+		return math.Floor(left / right), nil
+	case "%":
+		// Floored modulo, so that a == (a // b) * b + a % b holds
+		if right == 0 {
+			return 0, e.ErrDivisionByZero
+		}
+		return left - right*math.Floor(left/right), nil
+	case "^":
+		if left == 0 && right < 0 {
+			return 0, fmt.Errorf("%w: zero to a negative power", e.ErrDivisionByZero)
 		}
+		res := math.Pow(left, right)
+		if math.IsNaN(res) {
+			return 0, fmt.Errorf("%w: negative base %v to a fractional power %v", e.ErrUndefined, left, right)
+		}
+		if math.IsInf(res, 0) {
+			return 0, fmt.Errorf("%w: %v to the power %v overflows", e.ErrUndefined, left, right)
+		}
+		return res, nil
+	case "<":
+		return boolean(left < right), nil
+	case "<=":
+		return boolean(left <= right), nil
+	case ">":
+		return boolean(left > right), nil
+	case ">=":
+		return boolean(left >= right), nil
+	case "==":
+		return boolean(left == right), nil
+	case "!=":
+		return boolean(left != right), nil
+	case "and":
+		return boolean(left != 0 && right != 0), nil
+	case "or":
+		return boolean(left != 0 || right != 0), nil
+	}
 
-		return &models.TaskResult{
-			Id:     t.Id,
-			Result: t.LeftArg / t.RightArg,
-			Status: statusSuccess,
-			Final:  t.Final,
-		}, nil
-	case "":
-		return &models.TaskResult{
-			Id:     t.Id,
-			Result: t.LeftArg,
-			Status: statusSuccess,
-			Final:  t.Final,
-		}, nil
+	return 0, fmt.Errorf("%w %s", e.ErrUnknownOperation, op)
+}
+
+// compare evaluates a comparison given the sign of the difference of its
+// operands.
+func compare(op string, cmp int) bool {
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "==":
+		return cmp == 0
 	}
 
-	return &models.TaskResult{
-		Id:     t.Id,
-		Status: statusFailure,
-		Final:  t.Final,
-	}, fmt.Errorf("unknown operation %s", t.Op)
+	return cmp != 0
+}
+
+// boolean represents results of comparisons and logical operators, any
+// non-zero number is true when used as a condition.
+func boolean(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
 }
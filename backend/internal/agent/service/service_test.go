@@ -1,9 +1,13 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/distributed-calc/v1/internal/agent/models"
+	"math"
 	"testing"
+	"time"
 )
 
 func TestNewService(t *testing.T) {
@@ -25,8 +29,7 @@ func TestService_Evaluate(t *testing.T) {
 			task: &models.AgentTask{
 				Id:            fmt.Sprint(1),
 				Op:            "+",
-				LeftArg:       2,
-				RightArg:      3,
+				Args:          []float64{2, 3},
 				OperationTime: 100,
 			},
 			expected: &models.TaskResult{
@@ -40,8 +43,7 @@ func TestService_Evaluate(t *testing.T) {
 			task: &models.AgentTask{
 				Id:            fmt.Sprint(2),
 				Op:            "-",
-				LeftArg:       5,
-				RightArg:      3,
+				Args:          []float64{5, 3},
 				OperationTime: 200,
 			},
 			expected: &models.TaskResult{
@@ -55,8 +57,7 @@ func TestService_Evaluate(t *testing.T) {
 			task: &models.AgentTask{
 				Id:            fmt.Sprint(3),
 				Op:            "*",
-				LeftArg:       2,
-				RightArg:      3,
+				Args:          []float64{2, 3},
 				OperationTime: 300,
 			},
 			expected: &models.TaskResult{
@@ -70,8 +71,7 @@ func TestService_Evaluate(t *testing.T) {
 			task: &models.AgentTask{
 				Id:            fmt.Sprint(4),
 				Op:            "/",
-				LeftArg:       10,
-				RightArg:      2,
+				Args:          []float64{10, 2},
 				OperationTime: 400,
 			},
 			expected: &models.TaskResult{
@@ -80,13 +80,312 @@ func TestService_Evaluate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "negation",
+			task: &models.AgentTask{
+				Id:            fmt.Sprint(6),
+				Op:            "neg",
+				Args:          []float64{7},
+				OperationTime: 100,
+			},
+			expected: &models.TaskResult{
+				Id:     fmt.Sprint(6),
+				Result: -7,
+			},
+			wantErr: false,
+		},
+		{
+			name: "power",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(7),
+				Op:   "^",
+				Args: []float64{2, -2},
+			},
+			expected: &models.TaskResult{
+				Id:     fmt.Sprint(7),
+				Result: 0.25,
+			},
+			wantErr: false,
+		},
+		{
+			name: "modulo of negative number",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(8),
+				Op:   "%",
+				Args: []float64{-7, 3},
+			},
+			expected: &models.TaskResult{
+				Id:     fmt.Sprint(8),
+				Result: 2,
+			},
+			wantErr: false,
+		},
+		{
+			name: "integer division",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(9),
+				Op:   "//",
+				Args: []float64{-7, 2},
+			},
+			expected: &models.TaskResult{
+				Id:     fmt.Sprint(9),
+				Result: -4,
+			},
+			wantErr: false,
+		},
+		{
+			name: "zero to a negative power",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(10),
+				Op:   "^",
+				Args: []float64{0, -1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative base to a fractional power",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(11),
+				Op:   "^",
+				Args: []float64{-8, 0.5},
+			},
+			wantErr: true,
+		},
+		{
+			name: "modulo by zero",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(12),
+				Op:   "%",
+				Args: []float64{1, 0},
+			},
+			wantErr: true,
+		},
+		{
+			name: "integer division by zero",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(13),
+				Op:   "//",
+				Args: []float64{1, 0},
+			},
+			wantErr: true,
+		},
+		{
+			name: "square root",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(15),
+				Op:   "sqrt",
+				Args: []float64{9},
+			},
+			expected: &models.TaskResult{
+				Id:     fmt.Sprint(15),
+				Result: 3,
+			},
+			wantErr: false,
+		},
+		{
+			name: "minimum of many arguments",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(16),
+				Op:   "min",
+				Args: []float64{4, -1, 7, 2},
+			},
+			expected: &models.TaskResult{
+				Id:     fmt.Sprint(16),
+				Result: -1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "compensated sum",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(16),
+				Op:   "ksum",
+				Args: []float64{1, 1e100, 1, -1e100},
+			},
+			expected: &models.TaskResult{
+				Id:     fmt.Sprint(16),
+				Result: 2,
+			},
+			wantErr: false,
+		},
+		{
+			name: "round to digits",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(17),
+				Op:   "round",
+				Args: []float64{3.14159, 2},
+			},
+			expected: &models.TaskResult{
+				Id:     fmt.Sprint(17),
+				Result: 3.14,
+			},
+			wantErr: false,
+		},
+		{
+			name: "argument of negative real number",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(21),
+				Op:   "arg",
+				Args: []float64{-2},
+			},
+			expected: &models.TaskResult{
+				Id:     fmt.Sprint(21),
+				Result: math.Pi,
+			},
+			wantErr: false,
+		},
+		{
+			name: "comparison",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(22),
+				Op:   "<=",
+				Args: []float64{2, 2},
+			},
+			expected: &models.TaskResult{
+				Id:     fmt.Sprint(22),
+				Result: 1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "logical and",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(23),
+				Op:   "and",
+				Args: []float64{-0.5, 0},
+			},
+			expected: &models.TaskResult{
+				Id:     fmt.Sprint(23),
+				Result: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "logical not",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(24),
+				Op:   "not",
+				Args: []float64{0},
+			},
+			expected: &models.TaskResult{
+				Id:     fmt.Sprint(24),
+				Result: 1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "square root of negative number",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(18),
+				Op:   "sqrt",
+				Args: []float64{-1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "logarithm of zero",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(19),
+				Op:   "ln",
+				Args: []float64{0},
+			},
+			wantErr: true,
+		},
+		{
+			name: "overflowing power",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(25),
+				Op:   "^",
+				Args: []float64{10, 400},
+			},
+			wantErr: true,
+		},
+		{
+			name: "overflowing power of negative base",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(26),
+				Op:   "^",
+				Args: []float64{-8, 1e300},
+			},
+			wantErr: true,
+		},
+		{
+			name: "overflowing exponential",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(27),
+				Op:   "exp",
+				Args: []float64{1000},
+			},
+			wantErr: true,
+		},
+		{
+			name: "overflowing product",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(28),
+				Op:   "*",
+				Args: []float64{1e308, 10},
+			},
+			wantErr: true,
+		},
+		{
+			name: "overflowing sum",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(29),
+				Op:   "+",
+				Args: []float64{math.MaxFloat64, math.MaxFloat64},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tangent of infinite operand",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(30),
+				Op:   "tan",
+				Args: []float64{math.Inf(1)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "sine of infinite operand",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(31),
+				Op:   "sin",
+				Args: []float64{math.Inf(-1)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "overflowing compensated sum",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(32),
+				Op:   opCompensatedSum,
+				Args: []float64{math.MaxFloat64, math.MaxFloat64},
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong number of arguments",
+			task: &models.AgentTask{
+				Id:   fmt.Sprint(20),
+				Op:   "+",
+				Args: []float64{1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown operation",
+			task: &models.AgentTask{
+				Id: fmt.Sprint(14),
+				Op: "?",
+			},
+			wantErr: true,
+		},
 		{
 			name: "division by zero",
 			task: &models.AgentTask{
 				Id:            fmt.Sprint(5),
 				Op:            "/",
-				LeftArg:       5,
-				RightArg:      0,
+				Args:          []float64{5, 0},
 				OperationTime: 500,
 			},
 			wantErr: true,
@@ -97,11 +396,15 @@ func TestService_Evaluate(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			r, err := service.Evaluate(tc.task)
+			r, err := service.Evaluate(context.Background(), tc.task)
 			if tc.wantErr && err == nil {
 				t.Error("expected error, got none")
 			}
 
+			if tc.wantErr && r.Status != statusFailure {
+				t.Errorf("expected status %s, got %s", statusFailure, r.Status)
+			}
+
 			if tc.wantErr == false && err != nil {
 				t.Errorf("expected no error, got %v", err)
 			}
@@ -116,3 +419,22 @@ func TestService_Evaluate(t *testing.T) {
 		})
 	}
 }
+
+func TestService_Evaluate_Aborted(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	r, err := NewService().Evaluate(ctx, &models.AgentTask{Id: "1", Op: "+", Args: []float64{1, 2}, OperationTime: 10_000})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	if r != nil {
+		t.Errorf("expected no result of an aborted task, got %v", r)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the task to be aborted at once, took %v", elapsed)
+	}
+}
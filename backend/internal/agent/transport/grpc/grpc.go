@@ -9,21 +9,34 @@ import (
 	pb "github.com/distributed-calc/v1/pkg/proto/orchestrator"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"io"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Service interface {
-	Evaluate(task *models.AgentTask) (*models.TaskResult, error)
+	Evaluate(ctx context.Context, task *models.AgentTask) (*models.TaskResult, error)
 }
 
+// errStreamClosed is returned when the orchestrator closes the stream.
+var errStreamClosed = errors.New("stream closed by orchestrator")
+
 type Server struct {
 	cfg     *config.Config
 	client  pb.OrchestratorClient
 	in      chan *models.AgentTask
 	out     chan *models.TaskResult
 	service Service
+	// received is set once a task arrives on the current stream.
+	received atomic.Bool
+
+	// running holds tasks received on the current stream until they are
+	// computed, with cancel functions of those being computed.
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
 }
 
 func NewServer(cfg *config.Config, client *grpc.ClientConn, service Service) *Server {
@@ -36,15 +49,65 @@ func NewServer(cfg *config.Config, client *grpc.ClientConn, service Service) *Se
 	}
 }
 
+// Run processes tasks until ctx is cancelled. A broken stream is opened
+// again after a backoff which doubles on every attempt, Run gives up after
+// MaxRetries attempts in a row which received no tasks. Tasks being computed
+// when a stream breaks are taken back by the orchestrator.
 func (s *Server) Run(ctx context.Context) error {
+	agentID := s.cfg.AgentID
+	if agentID == "" {
+		agentID, _ = os.Hostname()
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, "agent-id", agentID)
+
+	backoff := s.cfg.RetryBackoff
+	for retries := 0; ; retries++ {
+		err := s.process(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err == nil {
+			err = errStreamClosed
+		}
+
+		if s.received.Load() {
+			retries, backoff = 0, s.cfg.RetryBackoff
+		}
+
+		if retries >= s.cfg.MaxRetries {
+			return fmt.Errorf("task processing finished with error: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+}
+
+// maxRetryBackoff limits the time between attempts to open a stream.
+const maxRetryBackoff = time.Minute
+
+// process runs a single stream until it breaks or ctx is cancelled.
+func (s *Server) process(ctx context.Context) error {
+	s.in = make(chan *models.AgentTask, s.cfg.BufferSize)
+	s.out = make(chan *models.TaskResult, s.cfg.BufferSize)
+	s.received.Store(false)
+
+	s.mu.Lock()
+	s.running = make(map[string]context.CancelFunc)
+	s.mu.Unlock()
+
 	stream, err := s.client.ProcessTasks(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to connect to orchestrator: %w", err)
 	}
 
-	ctx = stream.Context()
-
-	eg, ctx := errgroup.WithContext(ctx)
+	eg, ctx := errgroup.WithContext(stream.Context())
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -56,14 +119,9 @@ func (s *Server) Run(ctx context.Context) error {
 		return s.sendTaskResults(ctx, stream)
 	})
 
-	go s.runWorkers(ctx)
-
-	err = eg.Wait()
-	if err != nil {
-		return fmt.Errorf("task processing finished with error: %w", err)
-	}
+	go s.runWorkers(ctx, s.in, s.out)
 
-	return nil
+	return eg.Wait()
 }
 
 func (s *Server) getTasks(ctx context.Context, stream grpc.BidiStreamingClient[pb.TaskResult, pb.Task]) error {
@@ -82,15 +140,39 @@ func (s *Server) getTasks(ctx context.Context, stream grpc.BidiStreamingClient[p
 			if err != nil {
 				return fmt.Errorf("failed to receive task: %w", err)
 			}
+			s.received.Store(true)
 
-			s.in <- &models.AgentTask{
+			if msg.GetCancel() {
+				s.abort(msg.GetId())
+				continue
+			}
+
+			task := &models.AgentTask{
 				Id:            msg.GetId(),
-				LeftArg:       msg.GetLeftArg(),
-				RightArg:      msg.GetRightArg(),
+				Args:          msg.GetArgs(),
 				Op:            msg.GetOp(),
 				OperationTime: msg.GetOperationTime(),
 				Final:         msg.GetFinal(),
 			}
+
+			for _, n := range msg.GetNumbers() {
+				task.Numbers = append(task.Numbers, numberFromProto(n))
+			}
+
+			if p := msg.GetPrecision(); p != nil {
+				task.Precision = &models.Precision{
+					Scale:    p.GetScale(),
+					Rounding: p.GetRounding(),
+				}
+			}
+
+			s.track(task.Id)
+
+			select {
+			case s.in <- task:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
 	}
 }
@@ -109,12 +191,19 @@ func (s *Server) sendTaskResults(ctx context.Context, stream grpc.BidiStreamingC
 				return nil
 			}
 
-			err := stream.Send(&pb.TaskResult{
+			res := &pb.TaskResult{
 				Id:     task.Id,
 				Result: task.Result,
 				Status: task.Status,
 				Final:  task.Final,
-			})
+				Error:  task.Error,
+			}
+
+			if task.Number != nil {
+				res.Number = numberToProto(task.Number)
+			}
+
+			err := stream.Send(res)
 			if err != nil {
 				return fmt.Errorf("failed to send task result: %w", err)
 			}
@@ -122,8 +211,8 @@ func (s *Server) sendTaskResults(ctx context.Context, stream grpc.BidiStreamingC
 	}
 }
 
-func (s *Server) runWorkers(ctx context.Context) {
-	defer close(s.out)
+func (s *Server) runWorkers(ctx context.Context, in <-chan *models.AgentTask, out chan<- *models.TaskResult) {
+	defer close(out)
 
 	wg := &sync.WaitGroup{}
 
@@ -136,13 +225,31 @@ func (s *Server) runWorkers(ctx context.Context) {
 				select {
 				case <-ctx.Done():
 					return
-				case task, ok := <-s.in:
+				case task, ok := <-in:
 					if !ok {
 						return
 					}
 
-					result, _ := s.service.Evaluate(task)
-					s.out <- result
+					taskCtx, ok := s.start(ctx, task.Id)
+					if !ok {
+						continue
+					}
+
+					result, _ := s.service.Evaluate(taskCtx, task)
+					aborted := taskCtx.Err() != nil
+					s.finish(task.Id)
+
+					// The orchestrator has deleted aborted tasks, their
+					// results would be ignored.
+					if aborted {
+						continue
+					}
+
+					select {
+					case out <- result:
+					case <-ctx.Done():
+						return
+					}
 				}
 			}
 		}()
@@ -150,3 +257,87 @@ func (s *Server) runWorkers(ctx context.Context) {
 
 	wg.Wait()
 }
+
+// track registers the received task, so that it may be aborted before a
+// worker starts computing it.
+func (s *Server) track(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running == nil {
+		s.running = make(map[string]context.CancelFunc)
+	}
+	s.running[id] = nil
+}
+
+// start returns the context the task is computed with, or false if it was
+// aborted while waiting for a worker.
+func (s *Server) start(ctx context.Context, id string) (context.Context, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.running[id]; !ok {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.running[id] = cancel
+
+	return ctx, true
+}
+
+func (s *Server) finish(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cancel := s.running[id]; cancel != nil {
+		cancel()
+	}
+	delete(s.running, id)
+}
+
+// abort stops computing the task, or drops it if no worker has started it
+// yet. Tasks already computed are left alone.
+func (s *Server) abort(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cancel, ok := s.running[id]
+	if !ok {
+		return
+	}
+
+	if cancel != nil {
+		cancel()
+		return
+	}
+	delete(s.running, id)
+}
+
+func numberFromProto(n *pb.Number) models.Number {
+	switch k := n.GetKind().(type) {
+	case *pb.Number_Decimal:
+		return models.Number{Decimal: k.Decimal}
+	case *pb.Number_Rational:
+		return models.Number{Rational: &models.Rational{Num: k.Rational.GetNum(), Den: k.Rational.GetDen()}}
+	case *pb.Number_Complex:
+		return models.Number{Complex: &models.Complex{Re: k.Complex.GetRe(), Im: k.Complex.GetIm()}}
+	case *pb.Number_Integer:
+		return models.Number{Integer: k.Integer}
+	}
+
+	return models.Number{}
+}
+
+func numberToProto(n *models.Number) *pb.Number {
+	switch {
+	case n.Rational != nil:
+		return &pb.Number{Kind: &pb.Number_Rational{Rational: &pb.Rational{Num: n.Rational.Num, Den: n.Rational.Den}}}
+	case n.Complex != nil:
+		return &pb.Number{Kind: &pb.Number_Complex{Complex: &pb.Complex{Re: n.Complex.Re, Im: n.Complex.Im}}}
+	case n.Integer != "":
+		return &pb.Number{Kind: &pb.Number_Integer{Integer: n.Integer}}
+	default:
+		return &pb.Number{Kind: &pb.Number_Decimal{Decimal: n.Decimal}}
+	}
+}
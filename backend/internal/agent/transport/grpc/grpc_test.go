@@ -9,8 +9,10 @@ import (
 	"github.com/distributed-calc/v1/internal/agent/service"
 	pb "github.com/distributed-calc/v1/pkg/proto/orchestrator"
 	"github.com/distributed-calc/v1/test/mock"
+	"google.golang.org/grpc"
 	"io"
 	"testing"
+	"time"
 )
 
 func TestGetTasks(t *testing.T) {
@@ -103,3 +105,113 @@ func TestSendTaskResults(t *testing.T) {
 		t.Errorf("error getting tasks: %v", err)
 	}
 }
+
+// failingClient refuses to open streams and counts attempts.
+type failingClient struct {
+	calls int
+}
+
+func (c *failingClient) ProcessTasks(context.Context, ...grpc.CallOption) (grpc.BidiStreamingClient[pb.TaskResult, pb.Task], error) {
+	c.calls++
+	return nil, errors.New("connection refused")
+}
+
+func TestRun_Retries(t *testing.T) {
+	client := &failingClient{}
+	server := &Server{
+		cfg: &config.Config{
+			WorkersLimit: 1,
+			MaxRetries:   3,
+			RetryBackoff: time.Millisecond,
+			BufferSize:   1,
+		},
+		client:  client,
+		service: service.NewService(),
+	}
+
+	err := server.Run(context.Background())
+	if err == nil {
+		t.Fatal("expected error after retries are exhausted")
+	}
+
+	if client.calls != 4 {
+		t.Errorf("expected 4 attempts to open a stream, got %d", client.calls)
+	}
+}
+
+func TestRun_Cancelled(t *testing.T) {
+	server := &Server{
+		cfg: &config.Config{
+			WorkersLimit: 1,
+			MaxRetries:   3,
+			RetryBackoff: time.Hour,
+			BufferSize:   1,
+		},
+		client:  &failingClient{},
+		service: service.NewService(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := server.Run(ctx)
+	if err != nil {
+		t.Errorf("expected no error when cancelled while waiting, got %v", err)
+	}
+}
+
+func TestRunWorkers_Abort(t *testing.T) {
+	server := &Server{
+		cfg:     &config.Config{WorkersLimit: 1},
+		service: service.NewService(),
+	}
+
+	in := make(chan *models.AgentTask, 3)
+	out := make(chan *models.TaskResult, 3)
+
+	tasks := []*models.AgentTask{
+		{Id: "dropped", Op: "+", Args: []float64{1, 2}},
+		{Id: "aborted", Op: "+", Args: []float64{1, 2}, OperationTime: 10_000},
+		{Id: "computed", Op: "+", Args: []float64{1, 2}},
+	}
+	for _, task := range tasks {
+		server.track(task.Id)
+	}
+
+	// A task may be aborted before a worker starts it.
+	server.abort("dropped")
+
+	for _, task := range tasks {
+		in <- task
+	}
+	close(in)
+
+	go server.runWorkers(context.Background(), in, out)
+
+	deadline := time.After(time.Second)
+	for {
+		server.mu.Lock()
+		started := server.running["aborted"] != nil
+		server.mu.Unlock()
+		if started {
+			break
+		}
+
+		select {
+		case <-deadline:
+			t.Fatal("expected the long task to be started")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	server.abort("aborted")
+
+	var results []string
+	for result := range out {
+		results = append(results, result.Id)
+	}
+
+	if len(results) != 1 || results[0] != "computed" {
+		t.Errorf("expected only the result of the computed task, got %v", results)
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"github.com/distributed-calc/v1/internal/orchestrator/parser"
 	"github.com/ilyakaznacheev/cleanenv"
 	"time"
 )
@@ -9,6 +10,13 @@ import (
 var (
 	errInvalidPort      = fmt.Errorf("port must be number between 1 and 65535")
 	errInvalidSleepTime = fmt.Errorf("sleep time must be positive")
+	errUnknownFunction  = fmt.Errorf("operation time is set for unknown function")
+	errInvalidLimit     = fmt.Errorf("limit must not be negative")
+	errInvalidLease     = fmt.Errorf("lease duration and check interval must be positive")
+	errLeaseTooShort    = fmt.Errorf("lease duration must be longer than every operation time")
+	errInvalidRetries   = fmt.Errorf("max retries must not be negative")
+	errUnknownOperation = fmt.Errorf("max retries is set for unknown operation")
+	errInvalidBackoff   = fmt.Errorf("retry backoff must be positive and not longer than its maximum")
 )
 
 type Config struct {
@@ -20,8 +28,45 @@ type Config struct {
 	SubtractionTime    time.Duration `env:"SUBTRACTION_TIME" env-default:"1ms"`
 	MultiplicationTime time.Duration `env:"MULTIPLICATION_TIME" env-default:"1ms"`
 	DivisionTime       time.Duration `env:"DIVISION_TIME" env-default:"1ms"`
+	NegationTime       time.Duration `env:"NEGATION_TIME" env-default:"1ms"`
+	PowerTime          time.Duration `env:"POWER_TIME" env-default:"1ms"`
+	ModuloTime         time.Duration `env:"MODULO_TIME" env-default:"1ms"`
+	IntDivisionTime    time.Duration `env:"INT_DIVISION_TIME" env-default:"1ms"`
+	BitwiseTime        time.Duration `env:"BITWISE_TIME" env-default:"1ms"`
+	LogicTime          time.Duration `env:"LOGIC_TIME" env-default:"1ms"`
+
+	// DefaultFunctionTime applies to built-in functions missing in FunctionTimes,
+	// which is read as 'sqrt:5ms,sin:10ms'
+	DefaultFunctionTime time.Duration            `env:"FUNCTION_TIME" env-default:"1ms"`
+	FunctionTimes       map[string]time.Duration `env:"FUNCTION_TIMES"`
 
 	PollDelay time.Duration `env:"POLL_DELAY" env-default:"500ms"`
+
+	// LeaseDuration is how long an agent may hold a task before it is
+	// returned to ready, expired leases are looked for every LeaseCheckInterval.
+	LeaseDuration      time.Duration `env:"LEASE_DURATION" env-default:"30s"`
+	LeaseCheckInterval time.Duration `env:"LEASE_CHECK_INTERVAL" env-default:"5s"`
+
+	// MaxRetries is how many times a task lost by agents is sent again before
+	// it is moved to the dead letters, OpMaxRetries overrides it per operation.
+	// Retries wait RetryBackoff doubled on every retry up to RetryBackoffMax.
+	MaxRetries      int            `env:"MAX_RETRIES" env-default:"3"`
+	OpMaxRetries    map[string]int `env:"OP_MAX_RETRIES"`
+	RetryBackoff    time.Duration  `env:"RETRY_BACKOFF" env-default:"1s"`
+	RetryBackoffMax time.Duration  `env:"RETRY_BACKOFF_MAX" env-default:"1m"`
+
+	// Admins are logins of users allowed to manage dead letters.
+	Admins []string `env:"ADMINS"`
+
+	// Limits of submitted expressions, zero disables a limit. The length is
+	// counted in bytes, the depth in nested operands of the syntax tree. A
+	// standard deviation takes four tasks per value, so MaxTasks admits it
+	// over any list within MaxNodes.
+	MaxExpressionLength   int `env:"MAX_EXPRESSION_LENGTH" env-default:"65536"`
+	MaxNodes              int `env:"MAX_AST_NODES" env-default:"10000"`
+	MaxDepth              int `env:"MAX_NESTING_DEPTH" env-default:"100"`
+	MaxTasks              int `env:"MAX_TASKS" env-default:"50000"`
+	MaxPendingExpressions int `env:"MAX_PENDING_EXPRESSIONS" env-default:"100"`
 }
 
 func NewConfig() (*Config, error) {
@@ -43,5 +88,82 @@ func NewConfig() (*Config, error) {
 		return nil, errInvalidSleepTime
 	}
 
+	if cfg.NegationTime < 0 || cfg.PowerTime < 0 || cfg.ModuloTime < 0 || cfg.IntDivisionTime < 0 {
+		return nil, errInvalidSleepTime
+	}
+
+	if cfg.BitwiseTime < 0 || cfg.LogicTime < 0 || cfg.DefaultFunctionTime < 0 {
+		return nil, errInvalidSleepTime
+	}
+
+	if cfg.MaxExpressionLength < 0 || cfg.MaxNodes < 0 || cfg.MaxDepth < 0 || cfg.MaxTasks < 0 || cfg.MaxPendingExpressions < 0 {
+		return nil, errInvalidLimit
+	}
+
+	for name, d := range cfg.FunctionTimes {
+		if !parser.IsFunction(name) {
+			return nil, fmt.Errorf("%w: %s", errUnknownFunction, name)
+		}
+
+		if d < 0 {
+			return nil, errInvalidSleepTime
+		}
+	}
+
+	if cfg.LeaseDuration <= 0 || cfg.LeaseCheckInterval <= 0 {
+		return nil, errInvalidLease
+	}
+
+	if cfg.LeaseDuration <= cfg.longestOperationTime() {
+		return nil, errLeaseTooShort
+	}
+
+	if cfg.MaxRetries < 0 {
+		return nil, errInvalidRetries
+	}
+
+	for op, n := range cfg.OpMaxRetries {
+		if !parser.IsOperator(op) && !parser.IsFunction(op) {
+			return nil, fmt.Errorf("%w: %s", errUnknownOperation, op)
+		}
+
+		if n < 0 {
+			return nil, errInvalidRetries
+		}
+	}
+
+	if cfg.RetryBackoff <= 0 || cfg.RetryBackoffMax < cfg.RetryBackoff {
+		return nil, errInvalidBackoff
+	}
+
 	return &cfg, nil
 }
+
+func (c *Config) FunctionTime(name string) time.Duration {
+	if d, ok := c.FunctionTimes[name]; ok {
+		return d
+	}
+
+	return c.DefaultFunctionTime
+}
+
+// RetryLimit reports how many times a lost task computing op is retried.
+func (c *Config) RetryLimit(op string) int {
+	if n, ok := c.OpMaxRetries[op]; ok {
+		return n
+	}
+
+	return c.MaxRetries
+}
+
+// longestOperationTime reports the longest time an agent takes to compute a
+// task, leases must outlast it.
+func (c *Config) longestOperationTime() time.Duration {
+	longest := max(c.AdditionTime, c.SubtractionTime, c.MultiplicationTime, c.DivisionTime, c.NegationTime,
+		c.PowerTime, c.ModuloTime, c.IntDivisionTime, c.BitwiseTime, c.LogicTime, c.DefaultFunctionTime)
+	for _, d := range c.FunctionTimes {
+		longest = max(longest, d)
+	}
+
+	return longest
+}
@@ -11,4 +11,13 @@ var (
 	ErrBadRequest             = errors.New("bad request")
 	ErrConflict               = errors.New("conflict")
 	ErrUserAlreadyExists      = errors.New("this login has already been registered")
+	ErrVariableDoesNotExist   = errors.New("variable does not exist")
+	ErrVariableAlreadyExists  = errors.New("variable already exists")
+	ErrInvalidVariable        = errors.New("invalid variable")
+	ErrInvalidMode            = errors.New("invalid evaluation mode")
+	ErrInvalidLocale          = errors.New("invalid locale")
+	ErrExpressionTooLarge     = errors.New("expression is too large")
+	ErrExpressionTooComplex   = errors.New("expression is too complex")
+	ErrTooManyExpressions     = errors.New("too many pending expressions")
+	ErrForbidden              = errors.New("forbidden")
 )
@@ -1,43 +1,253 @@
 package models
 
+import (
+	"math/big"
+	"time"
+)
+
+// Number is a value of an exact evaluation mode. Exactly one field is set.
+type Number struct {
+	Decimal  string    `json:"decimal,omitempty" bson:"decimal,omitempty"`
+	Rational *Rational `json:"rational,omitempty" bson:"rational,omitempty"`
+	Complex  *Complex  `json:"complex,omitempty" bson:"complex,omitempty"`
+	Integer  string    `json:"integer,omitempty" bson:"integer,omitempty"`
+}
+
+// Rational is a reduced fraction of arbitrary-precision integers in base 10.
+type Rational struct {
+	Num string `json:"num" bson:"num"`
+	Den string `json:"den" bson:"den"`
+}
+
+type Complex struct {
+	Re float64 `json:"re" bson:"re"`
+	Im float64 `json:"im" bson:"im"`
+}
+
+// Precision is the scale and the rounding mode results of the decimal mode
+// are rounded to.
+type Precision struct {
+	Scale    int32  `json:"scale" bson:"scale"`
+	Rounding string `json:"rounding" bson:"rounding"`
+}
+
+// Arg is an operand of a task. While TaskID is set, the operand is the
+// not yet known result of that task. Number is set in exact modes, Value
+// then holds its approximation.
+type Arg struct {
+	TaskID *string `bson:"task_id,omitempty"`
+	Value  float64 `bson:"value"`
+	Number *Number `bson:"number,omitempty"`
+}
+
 type Task struct {
-	ID       string  `bson:"_id"`
-	ExpID    string  `bson:"exp_id"`
-	Op       string  `bson:"op"`
-	LeftID   *string `bson:"left_id,omitempty"`
-	RightID  *string `bson:"right_id,omitempty"`
-	LeftArg  float64 `bson:"left_arg"`
-	RightArg float64 `bson:"right_arg"`
-	Result   float64
-	Status   string `bson:"status"`
-	Final    bool   `bson:"final"`
+	ID     string `bson:"_id"`
+	ExpID  string `bson:"exp_id"`
+	Op     string `bson:"op"`
+	Args   []Arg  `bson:"args"`
+	Result float64
+	Number *Number `bson:"number,omitempty"`
+	Status string  `bson:"status"`
+	Final  bool    `bson:"final"`
+	// Precision is set on tasks of the decimal mode.
+	Precision *Precision `bson:"precision,omitempty"`
+	// Name is set on the task computing an assignment of a script, its
+	// result is saved to Values of the expression.
+	Name string `bson:"name,omitempty"`
+	// Unit is the unit of the result in SI base units, or the target unit
+	// of a conversion. Agents compute magnitudes only.
+	Unit string `bson:"unit,omitempty"`
+	// Guards are branches of conditionals the task belongs to, see
+	// BranchGuard. The task is not dispatched until all of them are chosen.
+	Guards []string `bson:"guards,omitempty"`
+	// Condition is set on tasks whose result chooses branches of 'if'.
+	Condition bool `bson:"condition,omitempty"`
+	// Source is the sub-expression the task computes, it is reported when
+	// the task fails.
+	Source string `bson:"source,omitempty"`
+	// ClaimedBy identifies the agent stream which took the task, it is set
+	// with ClaimedAt when the task moves from ready to in_progress.
+	ClaimedBy string    `bson:"claimed_by,omitempty"`
+	ClaimedAt time.Time `bson:"claimed_at,omitempty"`
+	// LeaseUntil is the deadline of the claim, the task is returned to
+	// ready if it is not finished by then.
+	LeaseUntil time.Time `bson:"lease_until,omitempty"`
+	// Retries counts how many times the task was lost by agents, a retried
+	// task is not claimed before RetryAt.
+	Retries int       `bson:"retries,omitempty"`
+	RetryAt time.Time `bson:"retry_at,omitempty"`
+}
+
+// DeadLetter is a task which was lost by agents more times than it may be
+// retried. It is kept aside until an admin requeues it, its expression is
+// stalled meanwhile.
+type DeadLetter struct {
+	ID       string    `json:"id" bson:"_id"`
+	ExpID    string    `json:"expression_id" bson:"exp_id"`
+	Op       string    `json:"op" bson:"op"`
+	Retries  int       `json:"retries" bson:"retries"`
+	Reason   string    `json:"reason" bson:"reason"`
+	FailedAt time.Time `json:"failed_at" bson:"failed_at"`
+	Task     *Task     `json:"-" bson:"task"`
+}
+
+// BranchGuard names the branch of conditionals taken when the result of
+// the condition task condID is true or false.
+func BranchGuard(condID string, branch bool) string {
+	if branch {
+		return condID + ":then"
+	}
+
+	return condID + ":else"
+}
+
+// IsTrue reports whether the result of the task is not zero.
+func (t *Task) IsTrue() bool {
+	n := t.Number
+	switch {
+	case n == nil:
+		return t.Result != 0
+	case n.Rational != nil:
+		return n.Rational.Num != "0"
+	case n.Complex != nil:
+		return n.Complex.Re != 0 || n.Complex.Im != 0
+	}
+
+	val := n.Decimal
+	if n.Integer != "" {
+		val = n.Integer
+	}
+
+	x, ok := new(big.Rat).SetString(val)
+	return ok && x.Sign() != 0
 }
 
 type TaskResult struct {
 	Id     string  `json:"id"`
 	Result float64 `json:"result"`
+	Number *Number `json:"number,omitempty"`
 	Status string  `json:"status"`
 	Final  bool    `json:"final"`
+	// Error is the reason of a failure reported by the agent.
+	Error string `json:"error,omitempty"`
 }
 
 type AgentTask struct {
-	Id            string  `json:"id"`
-	LeftArg       float64 `json:"left_arg"`
-	RightArg      float64 `json:"right_arg"`
-	Op            string  `json:"op"`
-	OperationTime int64   `json:"op_time"`
-	Final         bool    `json:"final"`
+	Id            string     `json:"id"`
+	Args          []float64  `json:"args"`
+	Numbers       []Number   `json:"numbers,omitempty"`
+	Precision     *Precision `json:"precision,omitempty"`
+	Op            string     `json:"op"`
+	OperationTime int64      `json:"op_time"`
+	Final         bool       `json:"final"`
 }
 
 type Expression struct {
-	Id     string  `json:"id" bson:"_id"`
-	UserID string  `json:"user_id" bson:"user_id"`
-	Result float64 `json:"result" bson:"result"`
-	Status string  `json:"status" bson:"status"`
+	Id         string             `json:"id" bson:"_id"`
+	UserID     string             `json:"user_id" bson:"user_id"`
+	Expression string             `json:"expression" bson:"expression"`
+	Variables  map[string]float64 `json:"variables,omitempty" bson:"variables,omitempty"`
+	Values     map[string]float64 `json:"values,omitempty" bson:"values,omitempty"`
+	Mode       string             `json:"mode" bson:"mode"`
+	Precision  *Precision         `json:"precision,omitempty" bson:"precision,omitempty"`
+	Result     float64            `json:"result" bson:"result"`
+	// Decimal is the exact result of the decimal mode or the decimal
+	// approximation of Fraction.
+	Decimal string `json:"decimal,omitempty" bson:"decimal,omitempty"`
+	// Fraction is the exact result of the rational mode, like "7/3".
+	Fraction string `json:"fraction,omitempty" bson:"fraction,omitempty"`
+	// Complex is the result of the complex mode, Result is its real part.
+	Complex *Complex `json:"complex,omitempty" bson:"complex,omitempty"`
+	// Unit is the unit of Result, empty for dimensionless results.
+	Unit string `json:"unit,omitempty" bson:"unit,omitempty"`
+	// Base is the base Integer is formatted in.
+	Base int `json:"base,omitempty" bson:"base,omitempty"`
+	// Integer is the exact result of the integer mode.
+	Integer string `json:"integer,omitempty" bson:"integer,omitempty"`
+	// Compensated sums are computed with the Kahan-Neumaier summation.
+	Compensated bool `json:"compensated,omitempty" bson:"compensated,omitempty"`
+	// Locale the expression is written in.
+	Locale string `json:"locale,omitempty" bson:"locale,omitempty"`
+	// Rebalance compiles chains of associative operators into balanced trees.
+	Rebalance bool `json:"rebalance,omitempty" bson:"rebalance,omitempty"`
+	// CriticalPath is the number of tasks on the longest chain of tasks
+	// waiting for each other, the least number of rounds of agents.
+	CriticalPath int    `json:"critical_path" bson:"critical_path"`
+	Status       string `json:"status" bson:"status"`
+	// Error is the reason a failed expression could not be evaluated,
+	// FailedExpression is the sub-expression whose evaluation failed.
+	Error            string `json:"error,omitempty" bson:"error,omitempty"`
+	FailedExpression string `json:"failed_expression,omitempty" bson:"failed_expression,omitempty"`
+}
+
+// Explanation is the graph of tasks an expression compiles to. It is
+// returned by the explain endpoint and never persisted.
+type Explanation struct {
+	Expression string          `json:"expression"`
+	Mode       string          `json:"mode"`
+	Unit       string          `json:"unit,omitempty"`
+	Tasks      []ExplainedTask `json:"tasks"`
+	// CriticalPath is the number of tasks on the longest chain, Duration
+	// is the estimated time in milliseconds of the slowest chain.
+	CriticalPath int   `json:"critical_path"`
+	Duration     int64 `json:"duration_ms"`
+}
+
+// ExplainedTask is a task of an Explanation. Args are literals or IDs of
+// the tasks whose results they are, Dependencies also include conditions
+// the task is guarded by.
+type ExplainedTask struct {
+	ID           string   `json:"id"`
+	Op           string   `json:"op"`
+	Args         []string `json:"args"`
+	Dependencies []string `json:"dependencies"`
+	Guards       []string `json:"guards,omitempty"`
+	Name         string   `json:"name,omitempty"`
+	Unit         string   `json:"unit,omitempty"`
+	Final        bool     `json:"final,omitempty"`
+	Condition    bool     `json:"condition,omitempty"`
+	Ready        bool     `json:"ready"`
+	// Duration is the estimated operation time in milliseconds, Start the
+	// earliest time the task may be dispatched at.
+	Duration int64 `json:"duration_ms"`
+	Start    int64 `json:"start_ms"`
+	// Critical is set on tasks of the longest chain.
+	Critical bool `json:"critical,omitempty"`
+}
+
+type Variable struct {
+	UserID string  `json:"-" bson:"user_id"`
+	Name   string  `json:"name" bson:"name"`
+	Value  float64 `json:"value" bson:"value"`
 }
 
 type CalculateRequest struct {
-	Expression string `json:"expression"`
+	Expression string     `json:"expression"`
+	Mode       string     `json:"mode"`
+	Precision  *Precision `json:"precision"`
+	Base       int        `json:"base"`
+	// Compensated enables the compensated summation in the float mode.
+	Compensated bool `json:"compensated"`
+	// Optimize inlines literals and simplifies identities before tasks are
+	// dispatched, it is enabled by default.
+	Optimize *bool `json:"optimize"`
+	// Rebalance compiles chains like 1+2+3+4 into balanced trees, it is
+	// enabled by default. Disable it for left to right float semantics.
+	Rebalance *bool `json:"rebalance"`
+	// Locale is a language tag like "de" or "fr-FR", numbers are read with
+	// a decimal comma in languages which use it.
+	Locale string `json:"locale"`
+}
+
+// ExpressionError is the body of responses to expressions which cannot be
+// evaluated. Offset and Length locate the offending part of the expression in
+// bytes, they are omitted when the error is not caused by a part of it.
+type ExpressionError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Offset  *int   `json:"offset,omitempty"`
+	Length  *int   `json:"length,omitempty"`
+	Hint    string `json:"hint,omitempty"`
 }
 
 type UserCredentials struct {
@@ -0,0 +1,166 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/distributed-calc/v1/internal/orchestrator/units"
+	"strings"
+)
+
+// Node is an element of the expression syntax tree. Pos and End are byte
+// offsets of the node in the source expression.
+type Node interface {
+	Pos() int
+	End() int
+}
+
+// NumberLit is a number literal, Value is the imaginary part of the number
+// when Imag is set.
+type NumberLit struct {
+	Value    float64
+	Imag     bool
+	Raw      string
+	ValuePos int
+	ValueEnd int
+}
+
+type Ident struct {
+	Name    string
+	NamePos int
+}
+
+type BinaryExpr struct {
+	Op    string
+	X     Node
+	Y     Node
+	OpPos int
+}
+
+type UnaryExpr struct {
+	Op    string
+	X     Node
+	OpPos int
+}
+
+type CallExpr struct {
+	Func    string
+	Args    []Node
+	FuncPos int
+	Lparen  int
+	Rparen  int
+}
+
+type ParenExpr struct {
+	X      Node
+	Lparen int
+	Rparen int
+}
+
+// ListExpr is a list of arguments of a variadic function, like
+// 'sum([1, 2, 3])'. Lists are flattened into arguments of the call.
+type ListExpr struct {
+	Elems  []Node
+	Lbrack int
+	Rbrack int
+}
+
+// UnitExpr is a unit of measurement following a number, like 'km/h' or
+// 's^-1'. Value is the resolved unit.
+type UnitExpr struct {
+	Factors []UnitFactor
+	Value   units.Unit
+	UnitPos int
+	UnitEnd int
+}
+
+// UnitFactor is a unit symbol raised to Exp, which is negated for factors
+// after '/'.
+type UnitFactor struct {
+	Symbol string
+	Exp    int
+}
+
+// QuantityExpr is a number with a unit, like '3 km'.
+type QuantityExpr struct {
+	X    *NumberLit
+	Unit *UnitExpr
+}
+
+// ConvertExpr converts X to Unit, like '3 km to mi'.
+type ConvertExpr struct {
+	X     Node
+	Unit  *UnitExpr
+	ToPos int
+}
+
+// AssignStmt binds the value of X to Name for the following statements of
+// a script.
+type AssignStmt struct {
+	Name  *Ident
+	X     Node
+	EqPos int
+}
+
+// Script is a sequence of statements separated by ';'. Every statement but
+// the last one is an *AssignStmt; the value of the last one is the result.
+type Script struct {
+	Stmts []Node
+}
+
+func (n *NumberLit) Pos() int { return n.ValuePos }
+func (n *NumberLit) End() int { return n.ValueEnd }
+
+func (n *Ident) Pos() int { return n.NamePos }
+func (n *Ident) End() int { return n.NamePos + len(n.Name) }
+
+func (n *BinaryExpr) Pos() int { return n.X.Pos() }
+func (n *BinaryExpr) End() int { return n.Y.End() }
+
+func (n *UnaryExpr) Pos() int { return n.OpPos }
+func (n *UnaryExpr) End() int { return n.X.End() }
+
+func (n *CallExpr) Pos() int { return n.FuncPos }
+func (n *CallExpr) End() int { return n.Rparen + 1 }
+
+func (n *ParenExpr) Pos() int { return n.Lparen }
+func (n *ParenExpr) End() int { return n.Rparen + 1 }
+
+func (n *ListExpr) Pos() int { return n.Lbrack }
+func (n *ListExpr) End() int { return n.Rbrack + 1 }
+
+func (n *UnitExpr) Pos() int { return n.UnitPos }
+func (n *UnitExpr) End() int { return n.UnitEnd }
+
+func (n *QuantityExpr) Pos() int { return n.X.Pos() }
+func (n *QuantityExpr) End() int { return n.Unit.End() }
+
+func (n *ConvertExpr) Pos() int { return n.X.Pos() }
+func (n *ConvertExpr) End() int { return n.Unit.End() }
+
+func (n *AssignStmt) Pos() int { return n.Name.Pos() }
+func (n *AssignStmt) End() int { return n.X.End() }
+
+func (n *Script) Pos() int { return n.Stmts[0].Pos() }
+func (n *Script) End() int { return n.Stmts[len(n.Stmts)-1].End() }
+
+// String formats the unit as written, without spaces, like 'kg*m/s^2'.
+func (n *UnitExpr) String() string {
+	var b strings.Builder
+	for i, f := range n.Factors {
+		exp := f.Exp
+		if i > 0 {
+			if exp < 0 {
+				b.WriteString("/")
+				exp = -exp
+			} else {
+				b.WriteString("*")
+			}
+		}
+
+		b.WriteString(f.Symbol)
+		if exp != 1 {
+			fmt.Fprintf(&b, "^%d", exp)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package parser
+
+import "math"
+
+// Constants are predefined names available in every expression.
+var Constants = map[string]float64{
+	"pi":  math.Pi,
+	"e":   math.E,
+	"tau": 2 * math.Pi,
+}
+
+// ImaginaryUnit is the name of the square root of -1. An expression using it
+// or an imaginary literal like '4i' is evaluated with complex numbers.
+const ImaginaryUnit = "i"
+
+// IsReserved reports whether name is taken by a constant, a function or a
+// word operator and therefore cannot be used as a variable name.
+func IsReserved(name string) bool {
+	_, ok := Constants[name]
+	return ok || name == ImaginaryUnit || IsFunction(name) || wordOperators[name]
+}
+
+// IsIdent reports whether name is a valid identifier.
+func IsIdent(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		if !isIdentStart(r) && (i == 0 || !isDigit(r)) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrTooDeep is wrapped by errors of expressions nested deeper than
+// Options.MaxDepth.
+var ErrTooDeep = errors.New("expression is nested too deeply")
+
+// Code identifies the kind of an Error. Unlike messages, codes are stable and
+// may be relied on by clients.
+type Code string
+
+const (
+	CodeEmptyExpression   Code = "empty_expression"
+	CodeInvalidCharacter  Code = "invalid_character"
+	CodeInvalidNumber     Code = "invalid_number"
+	CodeUnexpectedToken   Code = "unexpected_token"
+	CodeUnbalanced        Code = "unbalanced_brackets"
+	CodeEmptyGroup        Code = "empty_group"
+	CodeChainedComparison Code = "chained_comparison"
+	CodeUnknownFunction   Code = "unknown_function"
+	CodeArgumentCount     Code = "argument_count"
+	CodeInvalidUnit       Code = "invalid_unit"
+	CodeInvalidAssignment Code = "invalid_assignment"
+	CodeUndefinedName     Code = "undefined_name"
+	CodeUnused            Code = "unused"
+	CodeTooDeep           Code = "too_deep"
+
+	// Codes of errors found once the expression is parsed, when it is
+	// checked against the evaluation mode and units.
+	CodeUnsupported       Code = "unsupported_in_mode"
+	CodeIncompatibleUnits Code = "incompatible_units"
+	CodeInvalidConversion Code = "invalid_conversion"
+)
+
+// Error is a syntax error located at a byte offset of the source expression.
+// Hint suggests how to fix it and may be empty.
+type Error struct {
+	Code   Code
+	Offset int
+	Length int
+	Msg    string
+	Hint   string
+
+	err error
+}
+
+func newError(code Code, offset, length int, format string, args ...any) *Error {
+	return &Error{
+		Code:   code,
+		Offset: offset,
+		Length: length,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *Error) withHint(format string, args ...any) *Error {
+	e.Hint = fmt.Sprintf(format, args...)
+	return e
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Msg, e.Offset)
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Suggest returns the candidate closest to a misspelled name, or an empty
+// string if none is close enough to be meant.
+func Suggest(name string, candidates []string) string {
+	candidates = slices.Sorted(slices.Values(candidates))
+
+	var best string
+	limit := max(1, len(name)/3)
+	for _, c := range candidates {
+		if d := distance(name, c); d <= limit {
+			best, limit = c, d-1
+		}
+	}
+
+	return best
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
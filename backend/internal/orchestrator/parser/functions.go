@@ -0,0 +1,56 @@
+package parser
+
+// Variadic marks a function accepting any number of arguments above MinArgs.
+const Variadic = -1
+
+type Function struct {
+	MinArgs int
+	MaxArgs int
+}
+
+// Functions lists built-in functions. Each call is compiled into a task
+// whose op is the function name. Only the branch of 'if' chosen by its
+// condition is evaluated.
+var Functions = map[string]Function{
+	"sqrt":    {MinArgs: 1, MaxArgs: 1},
+	"abs":     {MinArgs: 1, MaxArgs: 1},
+	"ln":      {MinArgs: 1, MaxArgs: 1},
+	"log10":   {MinArgs: 1, MaxArgs: 1},
+	"exp":     {MinArgs: 1, MaxArgs: 1},
+	"sin":     {MinArgs: 1, MaxArgs: 1},
+	"cos":     {MinArgs: 1, MaxArgs: 1},
+	"tan":     {MinArgs: 1, MaxArgs: 1},
+	"min":     {MinArgs: 1, MaxArgs: Variadic},
+	"max":     {MinArgs: 1, MaxArgs: Variadic},
+	"round":   {MinArgs: 1, MaxArgs: 2},
+	"floor":   {MinArgs: 1, MaxArgs: 1},
+	"ceil":    {MinArgs: 1, MaxArgs: 1},
+	"re":      {MinArgs: 1, MaxArgs: 1},
+	"im":      {MinArgs: 1, MaxArgs: 1},
+	"conj":    {MinArgs: 1, MaxArgs: 1},
+	"arg":     {MinArgs: 1, MaxArgs: 1},
+	"if":      {MinArgs: 3, MaxArgs: 3},
+	"sum":     {MinArgs: 1, MaxArgs: Variadic},
+	"product": {MinArgs: 1, MaxArgs: Variadic},
+	"avg":     {MinArgs: 1, MaxArgs: Variadic},
+	"stddev":  {MinArgs: 1, MaxArgs: Variadic},
+}
+
+func IsFunction(name string) bool {
+	_, ok := Functions[name]
+	return ok
+}
+
+// Flatten replaces lists among args of a call with their elements.
+func Flatten(args []Node) []Node {
+	flat := make([]Node, 0, len(args))
+	for _, arg := range args {
+		if list, ok := arg.(*ListExpr); ok {
+			flat = append(flat, list.Elems...)
+		} else {
+			flat = append(flat, arg)
+		}
+	}
+
+	return flat
+}
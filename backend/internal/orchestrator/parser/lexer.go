@@ -0,0 +1,352 @@
+package parser
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type lexer struct {
+	src  string
+	pos  int
+	opts Options
+}
+
+// Options change how numbers are read and limit the syntax tree.
+type Options struct {
+	// DecimalComma makes ',' between digits the decimal separator instead of
+	// '.', like in '3,14'. Arguments are then separated by a comma followed
+	// by a space.
+	DecimalComma bool
+
+	// MaxDepth limits nesting of parentheses, calls, lists and operators,
+	// zero means no limit. Parsing stops with ErrTooDeep once it is exceeded.
+	MaxDepth int
+}
+
+func isOperator(r rune) bool {
+	return r == '+' || r == '-' || r == '*' || r == '/' || r == '%' || r == '^' || r == '&' || r == '|' || r == '~' || r == '<' || r == '>'
+}
+
+// multiCharOperators are matched before single character ones.
+var multiCharOperators = []string{"//", "<<", ">>", "<=", ">=", "==", "!="}
+
+// wordOperators are identifiers lexed as operators.
+var wordOperators = map[string]bool{
+	"xor": true,
+	"to":  true,
+	"and": true,
+	"or":  true,
+	"not": true,
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// digitSeparators may be written between digits of numbers, like
+// '1_000_000' or '1 000 000' with thin spaces.
+var digitSeparators = []string{"_", "\u2009", "\u202f"}
+
+// Tokenize splits src into tokens. The last token is always EOF.
+func Tokenize(src string) ([]Token, error) {
+	return TokenizeWith(src, Options{})
+}
+
+// TokenizeWith is like Tokenize, but reads numbers according to opts.
+func TokenizeWith(src string, opts Options) ([]Token, error) {
+	l := &lexer{src: src, opts: opts}
+
+	tokens := make([]Token, 0, len(src))
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, tok)
+		if tok.Kind == EOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) peek() rune {
+	if l.pos >= len(l.src) {
+		return utf8.RuneError
+	}
+
+	r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	return r
+}
+
+func (l *lexer) next() (Token, error) {
+	for l.pos < len(l.src) {
+		r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		l.pos += size
+	}
+
+	if l.pos >= len(l.src) {
+		return Token{Kind: EOF, Pos: l.pos}, nil
+	}
+
+	start := l.pos
+	r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+
+	switch {
+	case isDigit(r) || r == '.':
+		return l.number()
+	case isIdentStart(r):
+		return l.ident(), nil
+	case l.multiCharOperator() != "":
+		op := l.multiCharOperator()
+		l.pos += len(op)
+		return Token{Kind: Operator, Value: op, Pos: start}, nil
+	case isOperator(r):
+		l.pos += size
+		return Token{Kind: Operator, Value: string(r), Pos: start}, nil
+	case r == '(':
+		l.pos += size
+		return Token{Kind: LParen, Value: "(", Pos: start}, nil
+	case r == ')':
+		l.pos += size
+		return Token{Kind: RParen, Value: ")", Pos: start}, nil
+	case r == ',':
+		l.pos += size
+		return Token{Kind: Comma, Value: ",", Pos: start}, nil
+	case r == '=':
+		l.pos += size
+		return Token{Kind: Assign, Value: "=", Pos: start}, nil
+	case r == ';':
+		l.pos += size
+		return Token{Kind: Semicolon, Value: ";", Pos: start}, nil
+	case r == '[':
+		l.pos += size
+		return Token{Kind: LBrack, Value: "[", Pos: start}, nil
+	case r == ']':
+		l.pos += size
+		return Token{Kind: RBrack, Value: "]", Pos: start}, nil
+	}
+
+	if r == utf8.RuneError && size == 1 {
+		return Token{}, newError(CodeInvalidCharacter, start, 1, "invalid utf-8 encoding")
+	}
+
+	err := newError(CodeInvalidCharacter, start, size, "invalid character %q", r)
+	if op, ok := lookalikes[r]; ok {
+		return Token{}, err.withHint("use '%s' instead", op)
+	}
+
+	return Token{}, err
+}
+
+// lookalikes are characters often pasted instead of operators.
+var lookalikes = map[rune]string{
+	'×': "*",
+	'·': "*",
+	'÷': "/",
+	'−': "-",
+	'–': "-",
+	'²': "^2",
+	'³': "^3",
+}
+
+func (l *lexer) multiCharOperator() string {
+	for _, op := range multiCharOperators {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			return op
+		}
+	}
+
+	return ""
+}
+
+// basePrefixes are prefixes of integer literals in other bases than 10.
+var basePrefixes = map[string]func(r rune) bool{
+	"0x": func(r rune) bool { return isDigit(r) || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F') },
+	"0o": func(r rune) bool { return r >= '0' && r <= '7' },
+	"0b": func(r rune) bool { return r == '0' || r == '1' },
+}
+
+func (l *lexer) number() (Token, error) {
+	start := l.pos
+
+	if len(l.src) >= l.pos+2 {
+		if isBaseDigit, ok := basePrefixes[strings.ToLower(l.src[l.pos:l.pos+2])]; ok {
+			return l.baseNumber(isBaseDigit)
+		}
+	}
+
+	var digits int
+	var dot bool
+	for l.pos < len(l.src) {
+		r := l.peek()
+		if isDigit(r) {
+			digits++
+			l.pos++
+			continue
+		}
+
+		if r == '.' && l.opts.DecimalComma {
+			return Token{}, newError(CodeInvalidNumber, l.pos, 1, "'.' is not a decimal separator, use ','")
+		}
+
+		// A decimal comma must be followed by a digit, otherwise it
+		// separates arguments.
+		if r == '.' || (r == ',' && l.opts.DecimalComma && l.digitAt(l.pos+1)) {
+			if dot && r == ',' {
+				return Token{}, newError(CodeInvalidNumber, l.pos, 1, "multiple decimal commas in the same number, separate arguments with ', '")
+			}
+			if dot {
+				return Token{}, newError(CodeInvalidNumber, l.pos, 1, "multiple decimal points in the same number")
+			}
+			dot = true
+			l.pos++
+			continue
+		}
+
+		ok, err := l.digitSeparator(isDigit)
+		if err != nil {
+			return Token{}, err
+		}
+		if !ok {
+			break
+		}
+	}
+
+	if digits == 0 {
+		return Token{}, newError(CodeInvalidNumber, start, l.pos-start, "number has no digits")
+	}
+
+	err := l.exponent()
+	if err != nil {
+		return Token{}, err
+	}
+
+	// An imaginary literal like '4i'
+	if strings.HasPrefix(l.src[l.pos:], ImaginaryUnit) {
+		next := l.pos + len(ImaginaryUnit)
+		if next == len(l.src) || !isIdentStart(rune(l.src[next])) && !isDigit(rune(l.src[next])) {
+			l.pos = next
+		}
+	}
+
+	return Token{Kind: Number, Value: l.src[start:l.pos], Pos: start}, nil
+}
+
+// baseNumber reads an integer literal with a base prefix like '0xff'.
+func (l *lexer) baseNumber(isBaseDigit func(r rune) bool) (Token, error) {
+	start := l.pos
+	l.pos += 2
+
+	for l.pos < len(l.src) {
+		if isBaseDigit(l.peek()) {
+			l.pos++
+			continue
+		}
+
+		ok, err := l.digitSeparator(isBaseDigit)
+		if err != nil {
+			return Token{}, err
+		}
+		if !ok {
+			break
+		}
+	}
+
+	if l.pos == start+2 {
+		return Token{}, newError(CodeInvalidNumber, start, 2, "number has no digits")
+	}
+
+	if r := l.peek(); isIdentStart(r) || isDigit(r) {
+		return Token{}, newError(CodeInvalidNumber, l.pos, 1, "invalid digit %q in %s literal", r, l.src[start:start+2])
+	}
+
+	return Token{Kind: Number, Value: l.src[start:l.pos], Pos: start}, nil
+}
+
+// exponent reads the exponent of scientific notation, like 'e23' or 'E-5'.
+// An 'e' not followed by digits is not an exponent, so '2e' stays a number
+// and the constant e.
+func (l *lexer) exponent() error {
+	if r := l.peek(); r != 'e' && r != 'E' {
+		return nil
+	}
+
+	next := l.pos + 1
+	if next < len(l.src) && (l.src[next] == '+' || l.src[next] == '-') {
+		next++
+	}
+	if !l.digitAt(next) {
+		return nil
+	}
+
+	l.pos = next
+	for l.pos < len(l.src) {
+		if isDigit(l.peek()) {
+			l.pos++
+			continue
+		}
+
+		ok, err := l.digitSeparator(isDigit)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// digitSeparator skips a digit separator between two digits. Underscores
+// anywhere else are errors, while thin spaces are whitespace.
+func (l *lexer) digitSeparator(isDigit func(r rune) bool) (bool, error) {
+	for _, sep := range digitSeparators {
+		if !strings.HasPrefix(l.src[l.pos:], sep) {
+			continue
+		}
+
+		next, _ := utf8.DecodeRuneInString(l.src[l.pos+len(sep):])
+		prev, _ := utf8.DecodeLastRuneInString(l.src[:l.pos])
+		if isDigit(prev) && isDigit(next) {
+			l.pos += len(sep)
+			return true, nil
+		}
+		if sep == "_" {
+			return false, newError(CodeInvalidNumber, l.pos, 1, "digit separator '_' must be between digits")
+		}
+	}
+
+	return false, nil
+}
+
+func (l *lexer) digitAt(pos int) bool {
+	return pos < len(l.src) && isDigit(rune(l.src[pos]))
+}
+
+func (l *lexer) ident() Token {
+	start := l.pos
+	for l.pos < len(l.src) {
+		r := l.peek()
+		if !isIdentStart(r) && !isDigit(r) {
+			break
+		}
+		l.pos++
+	}
+
+	word := l.src[start:l.pos]
+	if wordOperators[word] {
+		return Token{Kind: Operator, Value: word, Pos: start}
+	}
+
+	return Token{Kind: Name, Value: word, Pos: start}
+}
@@ -0,0 +1,542 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"github.com/distributed-calc/v1/internal/orchestrator/units"
+	"maps"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// precedence of 'to' is the lowest, it applies to the whole expression
+// unless parenthesized.
+var precedence = map[string]int{
+	"to":  0,
+	"or":  1,
+	"and": 2,
+	"<":   4,
+	"<=":  4,
+	">":   4,
+	">=":  4,
+	"==":  4,
+	"!=":  4,
+	"|":   5,
+	"xor": 6,
+	"&":   7,
+	"<<":  8,
+	">>":  8,
+	"+":   9,
+	"-":   9,
+	"*":   10,
+	"/":   10,
+	"//":  10,
+	"%":   10,
+	"^":   12,
+}
+
+// IsOperator reports whether op is a binary or unary operator computed by
+// agents.
+func IsOperator(op string) bool {
+	_, binary := precedence[op]
+	return binary && op != "to" || op == "~" || op == "not"
+}
+
+// IsComparison reports whether op compares its operands.
+func IsComparison(op string) bool {
+	return precedence[op] == precedence["=="]
+}
+
+var rightAssociative = map[string]bool{
+	"^": true,
+}
+
+// unaryPrecedence makes a sign bind tighter than multiplicative operators but
+// looser than '^', so '2*-3' is '2*(-3)' and '-2^2' is '-(2^2)'.
+const unaryPrecedence = 11
+
+// notPrecedence makes 'not' bind looser than comparisons but tighter than
+// 'and', so 'not a < b and c' is '(not (a < b)) and c'.
+const notPrecedence = 3
+
+type parser struct {
+	tokens   []Token
+	pos      int
+	depth    int
+	maxDepth int
+}
+
+// Parse turns src into a syntax tree. Binary operators are parsed with
+// precedence climbing; '^' is right-associative, the rest are
+// left-associative. Bitwise operators bind looser than arithmetic ones, from
+// the loosest: '|', 'xor', '&', '<<' and '>>'. Comparisons bind looser than
+// bitwise operators and cannot be chained, 'or' and 'and' are the loosest.
+// Unary '+', '-', '~' and 'not' are accepted wherever an operand is expected.
+func Parse(src string) (Node, error) {
+	tokens, err := Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokens[0].Kind == EOF {
+		return nil, newError(CodeEmptyExpression, 0, 0, "expression is empty")
+	}
+
+	p := &parser{tokens: tokens}
+
+	node, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.Kind != EOF {
+		return nil, p.unexpected(tok)
+	}
+
+	return node, nil
+}
+
+// unexpected reports a token found where an operator or the end of the
+// operand was expected.
+func (p *parser) unexpected(tok Token) error {
+	if tok.Kind == RParen {
+		return newError(CodeUnbalanced, tok.Pos, 1, "unexpected ')' without matching '('").
+			withHint("remove it or add a matching '('")
+	}
+
+	err := newError(CodeUnexpectedToken, tok.Pos, len(tok.Value), "unexpected %s, operator expected", tok)
+	if tok.Kind == Number || tok.Kind == Name || tok.Kind == LParen {
+		return err.withHint("insert an operator like '*' before %s", tok)
+	}
+
+	return err
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) prev() Token {
+	if p.pos == 0 {
+		return Token{Kind: EOF}
+	}
+
+	return p.tokens[p.pos-1]
+}
+
+func (p *parser) next() Token {
+	tok := p.tokens[p.pos]
+	if tok.Kind != EOF {
+		p.pos++
+	}
+
+	return tok
+}
+
+func (p *parser) parseExpr(minPrec int) (Node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+
+	if p.maxDepth > 0 && p.depth > p.maxDepth {
+		tok := p.peek()
+		err := newError(CodeTooDeep, tok.Pos, len(tok.Value), "expression is nested deeper than %d levels", p.maxDepth)
+		err.err = ErrTooDeep
+		return nil, err.withHint("assign nested parts to names, like 'a = (...); a * 2'")
+	}
+
+	lhs, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		prec, ok := precedence[tok.Value]
+		if tok.Kind != Operator || !ok || prec < minPrec {
+			return lhs, nil
+		}
+		p.next()
+
+		if tok.Value == "to" {
+			unit, err := p.parseUnit()
+			if err != nil {
+				return nil, err
+			}
+
+			lhs = &ConvertExpr{X: lhs, Unit: unit, ToPos: tok.Pos}
+			continue
+		}
+
+		if !rightAssociative[tok.Value] {
+			prec++
+		}
+
+		if IsComparison(tok.Value) {
+			if x, ok := lhs.(*BinaryExpr); ok && IsComparison(x.Op) {
+				return nil, newError(CodeChainedComparison, tok.Pos, len(tok.Value), "comparisons cannot be chained, use 'and'")
+			}
+		}
+
+		rhs, err := p.parseExpr(prec)
+		if err != nil {
+			return nil, err
+		}
+
+		lhs = &BinaryExpr{
+			Op:    tok.Value,
+			X:     lhs,
+			Y:     rhs,
+			OpPos: tok.Pos,
+		}
+	}
+}
+
+func (p *parser) parseOperand() (Node, error) {
+	tok := p.peek()
+
+	switch tok.Kind {
+	case Number:
+		p.next()
+		lit, err := number(tok)
+		if err != nil || !p.atUnit() {
+			return lit, err
+		}
+		unit, err := p.parseUnit()
+		if err != nil {
+			return nil, err
+		}
+		return &QuantityExpr{X: lit, Unit: unit}, nil
+	case LParen:
+		return p.parseParen()
+	case Name:
+		if p.tokens[p.pos+1].Kind == LParen {
+			return p.parseCall()
+		}
+		if IsFunction(tok.Value) {
+			return nil, newError(CodeUnexpectedToken, tok.Pos, len(tok.Value), "function %q must be called with '('", tok.Value).
+				withHint("call it like %s(x)", tok.Value)
+		}
+		p.next()
+		return &Ident{Name: tok.Value, NamePos: tok.Pos}, nil
+	case Operator:
+		return p.parseUnary()
+	case RParen:
+		if p.prev().Kind == LParen {
+			return nil, newError(CodeEmptyGroup, p.prev().Pos, 2, "empty parentheses")
+		}
+		return nil, newError(CodeUnexpectedToken, tok.Pos, 1, "unexpected ')', operand expected")
+	case EOF:
+		return nil, newError(CodeUnexpectedToken, tok.Pos, 0, "unexpected end of expression, operand expected").
+			withHint("the expression is incomplete")
+	}
+
+	return nil, newError(CodeUnexpectedToken, tok.Pos, len(tok.Value), "unexpected %s, operand expected", tok)
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	op := p.peek()
+	if op.Value != "+" && op.Value != "-" && op.Value != "~" && op.Value != "not" {
+		return nil, newError(CodeUnexpectedToken, op.Pos, len(op.Value), "unexpected operator %q, operand expected", op.Value)
+	}
+	p.next()
+
+	prec := unaryPrecedence
+	if op.Value == "not" {
+		prec = notPrecedence + 1
+	}
+
+	x, err := p.parseExpr(prec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UnaryExpr{
+		Op:    op.Value,
+		X:     x,
+		OpPos: op.Pos,
+	}, nil
+}
+
+func (p *parser) parseParen() (Node, error) {
+	lparen := p.next()
+
+	x, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	switch tok.Kind {
+	case RParen:
+		p.next()
+	case EOF:
+		return nil, newError(CodeUnbalanced, lparen.Pos, 1, "unclosed '('").withHint("add a matching ')'")
+	default:
+		return nil, newError(CodeUnexpectedToken, tok.Pos, len(tok.Value), "unexpected %s, ')' expected", tok)
+	}
+
+	return &ParenExpr{
+		X:      x,
+		Lparen: lparen.Pos,
+		Rparen: tok.Pos,
+	}, nil
+}
+
+func (p *parser) parseCall() (Node, error) {
+	name := p.next()
+
+	fn, ok := Functions[name.Value]
+	if !ok {
+		err := newError(CodeUnknownFunction, name.Pos, len(name.Value), "unknown function %q", name.Value)
+		if fn := Suggest(name.Value, slices.Collect(maps.Keys(Functions))); fn != "" {
+			err.withHint("did you mean %q?", fn)
+		}
+		return nil, err
+	}
+
+	lparen := p.next()
+
+	args := make([]Node, 0, fn.MinArgs)
+	for p.peek().Kind != RParen || len(args) > 0 {
+		var arg Node
+		var err error
+		if p.peek().Kind == LBrack && fn.MaxArgs == Variadic {
+			arg, err = p.parseList()
+		} else {
+			arg, err = p.parseExpr(0)
+		}
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		tok := p.peek()
+		if tok.Kind == RParen {
+			break
+		}
+		if tok.Kind == EOF {
+			return nil, newError(CodeUnbalanced, lparen.Pos, 1, "unclosed '('").withHint("add a matching ')'")
+		}
+		if tok.Kind != Comma {
+			return nil, newError(CodeUnexpectedToken, tok.Pos, len(tok.Value), "unexpected %s, ',' or ')' expected", tok)
+		}
+		p.next()
+	}
+	rparen := p.next()
+
+	if n := len(Flatten(args)); n < fn.MinArgs || (fn.MaxArgs != Variadic && n > fn.MaxArgs) {
+		return nil, newError(CodeArgumentCount, name.Pos, rparen.End()-name.Pos, "function %q %s, got %d", name.Value, arity(fn), n)
+	}
+
+	return &CallExpr{
+		Func:    name.Value,
+		Args:    args,
+		FuncPos: name.Pos,
+		Lparen:  lparen.Pos,
+		Rparen:  rparen.Pos,
+	}, nil
+}
+
+// atUnit reports whether the next token is a unit symbol rather than a name
+// of a function or a variable being assigned.
+func (p *parser) atUnit() bool {
+	tok := p.peek()
+	if tok.Kind != Name || !units.IsUnit(tok.Value) {
+		return false
+	}
+
+	next := p.tokens[p.pos+1].Kind
+	return next != LParen && next != Assign
+}
+
+// parseUnit parses a product of unit symbols with optional integer
+// exponents, like 'kg m/s^2'. A '*' or '/' continues the unit only if a unit
+// symbol follows it, so in '3 m / 2' the unit is 'm'.
+func (p *parser) parseUnit() (*UnitExpr, error) {
+	tok := p.peek()
+	if tok.Kind == Name && !units.IsUnit(tok.Value) {
+		return nil, newError(CodeInvalidUnit, tok.Pos, len(tok.Value), "unknown unit %q", tok.Value)
+	}
+	if tok.Kind != Name {
+		return nil, newError(CodeUnexpectedToken, tok.Pos, len(tok.Value), "unexpected %s, unit expected", tok)
+	}
+
+	unit := &UnitExpr{Value: units.One, UnitPos: tok.Pos}
+
+	sign := 1
+	for {
+		sym := p.next()
+		f := UnitFactor{Symbol: sym.Value, Exp: 1}
+		unit.UnitEnd = sym.End()
+
+		if p.peek().Value == "^" {
+			p.next()
+			exp, end, err := p.unitExponent()
+			if err != nil {
+				return nil, err
+			}
+			f.Exp = exp
+			unit.UnitEnd = end
+		}
+
+		f.Exp *= sign
+		u, _ := units.Lookup(f.Symbol)
+		unit.Value = unit.Value.Mul(u.Pow(f.Exp))
+		unit.Factors = append(unit.Factors, f)
+
+		sign = 1
+		switch tok := p.peek(); {
+		case p.atUnit():
+		case (tok.Value == "*" || tok.Value == "/") && p.tokens[p.pos+1].Kind == Name && units.IsUnit(p.tokens[p.pos+1].Value):
+			if tok.Value == "/" {
+				sign = -1
+			}
+			p.next()
+		default:
+			return unit, nil
+		}
+	}
+}
+
+// unitExponent parses a signed integer exponent of a unit symbol.
+func (p *parser) unitExponent() (int, int, error) {
+	sign := 1
+	if tok := p.peek(); tok.Value == "-" || tok.Value == "+" {
+		p.next()
+		if tok.Value == "-" {
+			sign = -1
+		}
+	}
+
+	tok := p.peek()
+	if tok.Kind != Number {
+		return 0, 0, newError(CodeUnexpectedToken, tok.Pos, len(tok.Value), "unexpected %s, exponent of unit expected", tok)
+	}
+	p.next()
+
+	exp, err := strconv.Atoi(tok.Value)
+	if err != nil {
+		return 0, 0, newError(CodeInvalidUnit, tok.Pos, len(tok.Value), "exponent of unit must be an integer")
+	}
+
+	return sign * exp, tok.End(), nil
+}
+
+// parseList parses a list of numbers or expressions like '[1, 2, x]'.
+func (p *parser) parseList() (Node, error) {
+	lbrack := p.next()
+
+	if rbrack := p.peek(); rbrack.Kind == RBrack {
+		return nil, newError(CodeEmptyGroup, lbrack.Pos, rbrack.End()-lbrack.Pos, "empty list")
+	}
+
+	elems := make([]Node, 0)
+	for {
+		elem, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+
+		tok := p.next()
+		switch tok.Kind {
+		case RBrack:
+			return &ListExpr{Elems: elems, Lbrack: lbrack.Pos, Rbrack: tok.Pos}, nil
+		case EOF:
+			return nil, newError(CodeUnbalanced, lbrack.Pos, 1, "unclosed '['").withHint("add a matching ']'")
+		case Comma:
+		default:
+			return nil, newError(CodeUnexpectedToken, tok.Pos, len(tok.Value), "unexpected %s, ',' or ']' expected", tok)
+		}
+	}
+}
+
+func arity(fn Function) string {
+	switch {
+	case fn.MaxArgs == Variadic:
+		return fmt.Sprintf("takes at least %d argument(s)", fn.MinArgs)
+	case fn.MinArgs == fn.MaxArgs:
+		return fmt.Sprintf("takes %d argument(s)", fn.MinArgs)
+	}
+
+	return fmt.Sprintf("takes from %d to %d arguments", fn.MinArgs, fn.MaxArgs)
+}
+
+// maxExponent bounds exponents of scientific notation. Larger ones are out
+// of range of floats, and would make exact modes write out huge numbers.
+const maxExponent = 400
+
+// number converts a number token into a literal. Raw of the literal has no
+// digit separators and a decimal point instead of a decimal comma.
+func number(tok Token) (*NumberLit, error) {
+	clean := tok.Value
+	for _, sep := range digitSeparators {
+		clean = strings.ReplaceAll(clean, sep, "")
+	}
+	clean = strings.Replace(clean, ",", ".", 1)
+
+	if hasBasePrefix(clean) {
+		val, ok := new(big.Int).SetString(clean, 0)
+		if !ok {
+			return nil, newError(CodeInvalidNumber, tok.Pos, len(tok.Value), "invalid number %q", tok.Value)
+		}
+		f, _ := new(big.Float).SetInt(val).Float64()
+
+		return &NumberLit{
+			Value:    f,
+			Raw:      clean,
+			ValuePos: tok.Pos,
+			ValueEnd: tok.End(),
+		}, nil
+	}
+
+	raw, imag := strings.CutSuffix(clean, ImaginaryUnit)
+
+	// Floats underflow to zero without an error.
+	if _, exp, found := strings.Cut(strings.ToLower(raw), "e"); found {
+		e, err := strconv.Atoi(exp)
+		if err != nil || e < -maxExponent || e > maxExponent {
+			return nil, newError(CodeInvalidNumber, tok.Pos, len(tok.Value), "exponent of number %s is out of range", tok.Value)
+		}
+	}
+
+	val, err := strconv.ParseFloat(raw, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return nil, newError(CodeInvalidNumber, tok.Pos, len(tok.Value), "number %s is out of range", tok.Value)
+	}
+	if err != nil {
+		return nil, newError(CodeInvalidNumber, tok.Pos, len(tok.Value), "invalid number %q", tok.Value)
+	}
+
+	return &NumberLit{
+		Value:    val,
+		Imag:     imag,
+		Raw:      clean,
+		ValuePos: tok.Pos,
+		ValueEnd: tok.End(),
+	}, nil
+}
+
+func hasBasePrefix(raw string) bool {
+	return len(raw) > 2 && basePrefixes[strings.ToLower(raw[:2])] != nil
+}
+
+// Int reports the exact value of the literal if it is an integer.
+func (n *NumberLit) Int() (*big.Int, bool) {
+	if n.Imag {
+		return nil, false
+	}
+
+	if hasBasePrefix(n.Raw) {
+		return new(big.Int).SetString(n.Raw, 0)
+	}
+
+	r, ok := new(big.Rat).SetString(n.Raw)
+	if !ok || !r.IsInt() {
+		return nil, false
+	}
+
+	return r.Num(), true
+}
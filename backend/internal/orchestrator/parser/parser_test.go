@@ -0,0 +1,852 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// sexpr renders a tree in prefix notation to make its shape easy to compare.
+func sexpr(node Node) string {
+	switch n := node.(type) {
+	case *NumberLit:
+		return n.Raw
+	case *BinaryExpr:
+		return fmt.Sprintf("(%s %s %s)", n.Op, sexpr(n.X), sexpr(n.Y))
+	case *Ident:
+		return n.Name
+	case *UnaryExpr:
+		return fmt.Sprintf("(%s %s)", n.Op, sexpr(n.X))
+	case *CallExpr:
+		args := make([]string, 0, len(n.Args))
+		for _, arg := range n.Args {
+			args = append(args, sexpr(arg))
+		}
+		return fmt.Sprintf("(%s %s)", n.Func, strings.Join(args, " "))
+	case *ParenExpr:
+		return sexpr(n.X)
+	case *QuantityExpr:
+		return fmt.Sprintf("[%s %s]", sexpr(n.X), n.Unit)
+	case *ConvertExpr:
+		return fmt.Sprintf("(to %s %s)", sexpr(n.X), n.Unit)
+	case *ListExpr:
+		elems := make([]string, 0, len(n.Elems))
+		for _, elem := range n.Elems {
+			elems = append(elems, sexpr(elem))
+		}
+		return fmt.Sprintf("[%s]", strings.Join(elems, " "))
+	}
+
+	return "?"
+}
+
+func TestTokenize(t *testing.T) {
+	tokens, err := Tokenize(" 3.14 *(2-1)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Token{
+		{Kind: Number, Value: "3.14", Pos: 1},
+		{Kind: Operator, Value: "*", Pos: 6},
+		{Kind: LParen, Value: "(", Pos: 7},
+		{Kind: Number, Value: "2", Pos: 8},
+		{Kind: Operator, Value: "-", Pos: 9},
+		{Kind: Number, Value: "1", Pos: 10},
+		{Kind: RParen, Value: ")", Pos: 11},
+		{Kind: EOF, Pos: 12},
+	}
+
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d: %v", len(expected), len(tokens), tokens)
+	}
+
+	for i := range expected {
+		if tokens[i] != expected[i] {
+			t.Errorf("token %d: expected %v, got %v", i, expected[i], tokens[i])
+		}
+	}
+}
+
+func TestTokenize_Operators(t *testing.T) {
+	tokens, err := Tokenize("7//2^3%4/ /1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var ops []string
+	for _, tok := range tokens {
+		if tok.Kind == Operator {
+			ops = append(ops, tok.Value)
+		}
+	}
+
+	expected := fmt.Sprint([]string{"//", "^", "%", "/", "/"})
+	if fmt.Sprint(ops) != expected {
+		t.Errorf("expected operators %s, got %v", expected, ops)
+	}
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name     string
+		exp      string
+		expected string
+	}{
+		{
+			name:     "single number",
+			exp:      "42",
+			expected: "42",
+		},
+		{
+			name:     "operator priority",
+			exp:      "2+2*3",
+			expected: "(+ 2 (* 2 3))",
+		},
+		{
+			name:     "left associativity",
+			exp:      "8-4-2",
+			expected: "(- (- 8 4) 2)",
+		},
+		{
+			name:     "parenthesis",
+			exp:      "(2+2)*3",
+			expected: "(* (+ 2 2) 3)",
+		},
+		{
+			name:     "signed numbers",
+			exp:      "-2*(+3-1)",
+			expected: "(* (- 2) (- (+ 3) 1))",
+		},
+		{
+			name:     "sign after operator",
+			exp:      "2*-3",
+			expected: "(* 2 (- 3))",
+		},
+		{
+			name:     "sign before parenthesis",
+			exp:      "-(4+5)",
+			expected: "(- (+ 4 5))",
+		},
+		{
+			name:     "repeated signs",
+			exp:      "2--+3",
+			expected: "(- 2 (- (+ 3)))",
+		},
+		{
+			name:     "sign binds tighter than multiplication",
+			exp:      "-2*3",
+			expected: "(* (- 2) 3)",
+		},
+		{
+			name:     "power is right associative",
+			exp:      "2^3^2",
+			expected: "(^ 2 (^ 3 2))",
+		},
+		{
+			name:     "power binds tighter than sign",
+			exp:      "-2^2",
+			expected: "(- (^ 2 2))",
+		},
+		{
+			name:     "negative exponent",
+			exp:      "2^-1",
+			expected: "(^ 2 (- 1))",
+		},
+		{
+			name:     "power binds tighter than multiplication",
+			exp:      "2*3^2",
+			expected: "(* 2 (^ 3 2))",
+		},
+		{
+			name:     "modulo and integer division are multiplicative",
+			exp:      "7+9%4//2",
+			expected: "(+ 7 (// (% 9 4) 2))",
+		},
+		{
+			name:     "function call",
+			exp:      "2*sqrt(4+5)",
+			expected: "(* 2 (sqrt (+ 4 5)))",
+		},
+		{
+			name:     "variadic function call",
+			exp:      "max(1, -2, min(3,4))",
+			expected: "(max 1 (- 2) (min 3 4))",
+		},
+		{
+			name:     "identifiers",
+			exp:      "2*pi*r_1",
+			expected: "(* (* 2 pi) r_1)",
+		},
+		{
+			name:     "imaginary literals",
+			exp:      "3+4i*i-.5i",
+			expected: "(- (+ 3 (* 4i i)) .5i)",
+		},
+		{
+			name:     "bitwise precedence",
+			exp:      "1 | 2 xor 3 & 4 << 5 + 6",
+			expected: "(| 1 (xor 2 (& 3 (<< 4 (+ 5 6)))))",
+		},
+		{
+			name:     "bitwise not",
+			exp:      "~a >> 1 & -b",
+			expected: "(& (>> (~ a) 1) (- b))",
+		},
+		{
+			name:     "base prefixes",
+			exp:      "0xFf + 0o17 + 0B101",
+			expected: "(+ (+ 0xFf 0o17) 0B101)",
+		},
+		{
+			name:     "units",
+			exp:      "3 m * 2 s^-1 + 4 km/h",
+			expected: "(+ (* [3 m] [2 s^-1]) [4 km/h])",
+		},
+		{
+			name:     "compound unit",
+			exp:      "2 kg m/s^2 / 4",
+			expected: "(/ [2 kg*m/s^2] 4)",
+		},
+		{
+			name:     "unit without space",
+			exp:      "5km + 2in",
+			expected: "(+ [5 km] [2 in])",
+		},
+		{
+			name:     "unit before division by name",
+			exp:      "3 m / x",
+			expected: "(/ [3 m] x)",
+		},
+		{
+			name:     "conversion",
+			exp:      "(1 mi to km) * 2 + 1 km to m",
+			expected: "(to (+ (* (to [1 mi] km) 2) [1 km]) m)",
+		},
+		{
+			name:     "comparisons and logic",
+			exp:      "not a < b and c >= 1 | 2 or d != e",
+			expected: "(or (and (not (< a b)) (>= c (| 1 2))) (!= d e))",
+		},
+		{
+			name:     "conditional",
+			exp:      "if(x == 0, 1, x / 2)",
+			expected: "(if (== x 0) 1 (/ x 2))",
+		},
+		{
+			name:     "scientific notation",
+			exp:      "6.02e23 * 1E-3 + 2e+2i",
+			expected: "(+ (* 6.02e23 1E-3) 2e+2i)",
+		},
+		{
+			name:     "digit separators",
+			exp:      "1_000_000 + 0xff_ff + 1\u2009000.000\u202f5",
+			expected: "(+ (+ 1000000 0xffff) 1000.0005)",
+		},
+		{
+			name:     "thin space between number and operator",
+			exp:      "1\u2009+\u20092",
+			expected: "(+ 1 2)",
+		},
+		{
+			name:     "aggregation of a list",
+			exp:      "sum([1, 2, x * 2], 4)",
+			expected: "(sum [1 2 (* x 2)] 4)",
+		},
+		{
+			name:     "list as the only argument",
+			exp:      "stddev([1.5, -2])",
+			expected: "(stddev [1.5 (- 2)])",
+		},
+		{
+			name:     "whitespace",
+			exp:      "\t1 /\n 2 ",
+			expected: "(/ 1 2)",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			node, err := Parse(tc.exp)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if got := sexpr(node); got != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	cases := []struct {
+		name   string
+		exp    string
+		offset int
+	}{
+		{
+			name:   "empty expression",
+			exp:    "",
+			offset: 0,
+		},
+		{
+			name:   "invalid character",
+			exp:    "2+2a",
+			offset: 3,
+		},
+		{
+			name:   "multiple decimal points",
+			exp:    "3.14.2+2",
+			offset: 4,
+		},
+		{
+			name:   "operator at the beginning",
+			exp:    "*2+3",
+			offset: 0,
+		},
+		{
+			name:   "operator at the end",
+			exp:    "2+3*",
+			offset: 4,
+		},
+		{
+			name:   "sequent operators",
+			exp:    "2*/3",
+			offset: 2,
+		},
+		{
+			name:   "empty parentheses",
+			exp:    "2+()-1",
+			offset: 2,
+		},
+		{
+			name:   "unclosed parenthesis",
+			exp:    "(2+(3)",
+			offset: 0,
+		},
+		{
+			name:   "unmatched closing parenthesis",
+			exp:    "2+3)",
+			offset: 3,
+		},
+		{
+			name:   "missing operator",
+			exp:    "2 2",
+			offset: 2,
+		},
+		{
+			name:   "sign at the end",
+			exp:    "2*-",
+			offset: 3,
+		},
+		{
+			name:   "unknown function",
+			exp:    "1+foo(2)",
+			offset: 2,
+		},
+		{
+			name:   "function without call",
+			exp:    "sqrt+1",
+			offset: 0,
+		},
+		{
+			name:   "too many arguments",
+			exp:    "1+sqrt(1, 2)",
+			offset: 2,
+		},
+		{
+			name:   "too few arguments",
+			exp:    "min()",
+			offset: 0,
+		},
+		{
+			name:   "trailing comma",
+			exp:    "min(1,)",
+			offset: 6,
+		},
+		{
+			name:   "unclosed call",
+			exp:    "min(1, 2",
+			offset: 3,
+		},
+		{
+			name:   "comma outside of call",
+			exp:    "(1, 2)",
+			offset: 2,
+		},
+		{
+			name:   "identifier after number",
+			exp:    "2ix",
+			offset: 1,
+		},
+		{
+			name:   "hex literal without digits",
+			exp:    "1+0x",
+			offset: 2,
+		},
+		{
+			name:   "invalid binary digit",
+			exp:    "0b102",
+			offset: 4,
+		},
+		{
+			name:   "unknown unit",
+			exp:    "1 km to parsec",
+			offset: 8,
+		},
+		{
+			name:   "fractional unit exponent",
+			exp:    "1 m^0.5",
+			offset: 4,
+		},
+		{
+			name:   "chained comparison",
+			exp:    "1 < x < 3",
+			offset: 6,
+		},
+		{
+			name:   "single equals sign",
+			exp:    "x = 1",
+			offset: 2,
+		},
+		{
+			name:   "list outside of a call",
+			exp:    "[1, 2] + 1",
+			offset: 0,
+		},
+		{
+			name:   "list of a fixed arity function",
+			exp:    "sqrt([4])",
+			offset: 5,
+		},
+		{
+			name:   "empty list",
+			exp:    "sum([])",
+			offset: 4,
+		},
+		{
+			name:   "unclosed list",
+			exp:    "avg([1, 2",
+			offset: 4,
+		},
+		{
+			name:   "double underscore",
+			exp:    "1__000",
+			offset: 1,
+		},
+		{
+			name:   "trailing underscore",
+			exp:    "2 * 1_",
+			offset: 5,
+		},
+		{
+			name:   "underscore after decimal point",
+			exp:    "1._5",
+			offset: 2,
+		},
+		{
+			name:   "number out of range",
+			exp:    "1 + 1e400",
+			offset: 4,
+		},
+		{
+			name:   "exponent out of range",
+			exp:    "1 + 1e-9999999",
+			offset: 4,
+		},
+		{
+			name:   "exponent without digits",
+			exp:    "2e+",
+			offset: 1,
+		},
+		{
+			name:   "lone dot",
+			exp:    "1+.",
+			offset: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse(tc.exp)
+			if err == nil {
+				t.Fatal("expected error, got none")
+			}
+
+			var perr *Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("expected *Error, got %T", err)
+			}
+
+			if perr.Offset != tc.offset {
+				t.Errorf("expected offset %d, got %d (%v)", tc.offset, perr.Offset, err)
+			}
+		})
+	}
+}
+
+func TestInspect(t *testing.T) {
+	node, err := Parse("a + max(b, -c) * (d)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var names []string
+	Inspect(node, func(n Node) bool {
+		if id, ok := n.(*Ident); ok {
+			names = append(names, id.Name)
+		}
+		return true
+	})
+
+	if got := strings.Join(names, ","); got != "a,b,c,d" {
+		t.Errorf("expected identifiers a,b,c,d, got %s", got)
+	}
+}
+
+func TestIsIdent(t *testing.T) {
+	cases := map[string]bool{
+		"rate":   true,
+		"_x1":    true,
+		"X":      true,
+		"":       false,
+		"1x":     false,
+		"a-b":    false,
+		"ставка": false,
+	}
+
+	for name, expected := range cases {
+		if got := IsIdent(name); got != expected {
+			t.Errorf("IsIdent(%q): expected %v, got %v", name, expected, got)
+		}
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	cases := []struct {
+		name     string
+		src      string
+		expected []string
+	}{
+		{
+			name:     "single expression",
+			src:      "2+2",
+			expected: []string{"(+ 2 2)"},
+		},
+		{
+			name:     "assignments",
+			src:      "a = 2+3; b = a*a; b - a/2",
+			expected: []string{"a = (+ 2 3)", "b = (* a a)", "(- b (/ a 2))"},
+		},
+		{
+			name:     "last statement is assignment",
+			src:      "a = 1; b = -a;",
+			expected: []string{"a = 1", "b = (- a)"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			script, err := ParseScript(tc.src)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			stmts := make([]string, 0, len(script.Stmts))
+			for _, stmt := range script.Stmts {
+				if as, ok := stmt.(*AssignStmt); ok {
+					stmts = append(stmts, as.Name.Name+" = "+sexpr(as.X))
+					continue
+				}
+				stmts = append(stmts, sexpr(stmt))
+			}
+
+			if fmt.Sprint(stmts) != fmt.Sprint(tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, stmts)
+			}
+		})
+	}
+}
+
+func TestParseScript_Errors(t *testing.T) {
+	cases := []struct {
+		name   string
+		src    string
+		offset int
+	}{
+		{
+			name:   "empty statement",
+			src:    "a = 1;; a",
+			offset: 6,
+		},
+		{
+			name:   "assignment without value",
+			src:    "a = ; a",
+			offset: 4,
+		},
+		{
+			name:   "assignment to expression",
+			src:    "2 = 3",
+			offset: 2,
+		},
+		{
+			name:   "assignment to constant",
+			src:    "pi = 3; pi",
+			offset: 0,
+		},
+		{
+			name:   "assignment to function",
+			src:    "sqrt = 3; 1",
+			offset: 0,
+		},
+		{
+			name:   "reassignment",
+			src:    "a = 1; a = 2; a",
+			offset: 7,
+		},
+		{
+			name:   "use before assignment",
+			src:    "b = a+1; a = 2; a*b",
+			offset: 4,
+		},
+		{
+			name:   "self reference",
+			src:    "a = a+1; a",
+			offset: 4,
+		},
+		{
+			name:   "unused assignment",
+			src:    "a = 1; b = 2; a",
+			offset: 7,
+		},
+		{
+			name:   "unused expression",
+			src:    "1+1; 2",
+			offset: 0,
+		},
+		{
+			name:   "missing separator",
+			src:    "a = 1 a",
+			offset: 6,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseScript(tc.src)
+			if err == nil {
+				t.Fatal("expected error, got none")
+			}
+
+			var perr *Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("expected *Error, got %T", err)
+			}
+
+			if perr.Offset != tc.offset {
+				t.Errorf("expected offset %d, got %d (%v)", tc.offset, perr.Offset, err)
+			}
+		})
+	}
+}
+
+func TestParseScriptWith_DecimalComma(t *testing.T) {
+	cases := []struct {
+		name     string
+		src      string
+		expected string
+		offset   int
+	}{
+		{
+			name:     "decimal comma",
+			src:      "3,14 * 2",
+			expected: "(* 3.14 2)",
+		},
+		{
+			name:     "arguments separated by comma and space",
+			src:      "max(1,5, 2, 2,25)",
+			expected: "(max 1.5 2 2.25)",
+		},
+		{
+			name:     "list",
+			src:      "sum([0,5, 1,5e2])",
+			expected: "(sum [0.5 1.5e2])",
+		},
+		{
+			name:     "thousands separated by thin spaces",
+			src:      "1\u2009000,5",
+			expected: "1000.5",
+		},
+		{
+			name:   "decimal point",
+			src:    "1.5 + 1",
+			offset: 1,
+		},
+		{
+			name:   "arguments separated by comma only",
+			src:    "max(1,5,2)",
+			offset: 7,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			script, err := ParseScriptWith(tc.src, Options{DecimalComma: true})
+			if tc.expected == "" {
+				var perr *Error
+				if !errors.As(err, &perr) {
+					t.Fatalf("expected *Error, got %v", err)
+				}
+				if perr.Offset != tc.offset {
+					t.Errorf("expected offset %d, got %d (%v)", tc.offset, perr.Offset, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if got := sexpr(script.Stmts[0]); got != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseScriptWith_MaxDepth(t *testing.T) {
+	cases := []struct {
+		name   string
+		src    string
+		offset int
+		err    bool
+	}{
+		{
+			name: "within limit",
+			src:  "((1))",
+		},
+		{
+			name: "long chain is not nested",
+			src:  "1 + 2 - 3 + 4 * 5 + 6",
+		},
+		{
+			name:   "parentheses",
+			src:    "(((1)))",
+			offset: 3,
+			err:    true,
+		},
+		{
+			name:   "calls",
+			src:    "abs(abs(abs(1)))",
+			offset: 12,
+			err:    true,
+		},
+		{
+			name:   "signs",
+			src:    "a = 1; - - - a",
+			offset: 13,
+			err:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseScriptWith(tc.src, Options{MaxDepth: 3})
+			if !tc.err {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrTooDeep) {
+				t.Fatalf("expected ErrTooDeep, got %v", err)
+			}
+
+			var perr *Error
+			if !errors.As(err, &perr) || perr.Offset != tc.offset {
+				t.Errorf("expected offset %d, got %v", tc.offset, err)
+			}
+		})
+	}
+}
+
+func TestParseScript_ErrorCodes(t *testing.T) {
+	cases := []struct {
+		src    string
+		code   Code
+		offset int
+		length int
+		hint   string
+	}{
+		{src: "", code: CodeEmptyExpression},
+		{src: "2 × 3", code: CodeInvalidCharacter, offset: 2, length: 2, hint: "use '*' instead"},
+		{src: "1.2.3", code: CodeInvalidNumber, offset: 3, length: 1},
+		{src: "2 * 1e-99999", code: CodeInvalidNumber, offset: 4, length: 8},
+		{src: "2 3", code: CodeUnexpectedToken, offset: 2, length: 1, hint: `insert an operator like '*' before number "3"`},
+		{src: "(1 + 2", code: CodeUnbalanced, offset: 0, length: 1, hint: "add a matching ')'"},
+		{src: "1 + 2)", code: CodeUnbalanced, offset: 5, length: 1, hint: "remove it or add a matching '('"},
+		{src: "sum([])", code: CodeEmptyGroup, offset: 4, length: 2},
+		{src: "1 < 2 < 3", code: CodeChainedComparison, offset: 6, length: 1},
+		{src: "sqr(4)", code: CodeUnknownFunction, offset: 0, length: 3, hint: `did you mean "sqrt"?`},
+		{src: "frobnicate(4)", code: CodeUnknownFunction, offset: 0, length: 10},
+		{src: "max()", code: CodeArgumentCount, offset: 0, length: 5},
+		{src: "3 km to parsec", code: CodeInvalidUnit, offset: 8, length: 6},
+		{src: "a = 1; a = 2; a", code: CodeInvalidAssignment, offset: 7, length: 1, hint: "use another name"},
+		{src: "a = b; b = 1; a", code: CodeUndefinedName, offset: 4, length: 1, hint: "assign it in an earlier statement"},
+		{src: "a = 1; 2", code: CodeUnused, offset: 0, length: 1, hint: "use it in a later statement or remove the assignment"},
+		{src: "1 +", code: CodeUnexpectedToken, offset: 3, hint: "the expression is incomplete"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.src, func(t *testing.T) {
+			_, err := ParseScript(tc.src)
+
+			var perr *Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("expected *Error, got %v", err)
+			}
+
+			if perr.Code != tc.code || perr.Offset != tc.offset || perr.Length != tc.length || perr.Hint != tc.hint {
+				t.Errorf("expected %s at %d+%d with hint %q, got %s at %d+%d with hint %q (%v)",
+					tc.code, tc.offset, tc.length, tc.hint, perr.Code, perr.Offset, perr.Length, perr.Hint, err)
+			}
+		})
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	candidates := []string{"sqrt", "sin", "sum", "stddev", "ceil"}
+
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{name: "sqr", expected: "sqrt"},
+		{name: "sine", expected: "sin"},
+		{name: "std", expected: ""},
+		{name: "ceiling", expected: ""},
+		{name: "x", expected: ""},
+	}
+
+	for _, tc := range cases {
+		if got := Suggest(tc.name, candidates); got != tc.expected {
+			t.Errorf("Suggest(%q): expected %q, got %q", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestIsOperator(t *testing.T) {
+	for _, op := range []string{"+", "//", "^", "<=", "xor", "and", "~", "not"} {
+		if !IsOperator(op) {
+			t.Errorf("expected %q to be an operator", op)
+		}
+	}
+
+	for _, op := range []string{"to", "sqrt", "neg", "**", ""} {
+		if IsOperator(op) {
+			t.Errorf("expected %q not to be an operator", op)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package parser
+
+// ParseScript turns src into a script of statements separated by ';', like
+// 'a = 2+3; b = a*a; b - a/2'. A trailing ';' is allowed. Names assigned by
+// the script are checked to be assigned once, not used before assignment and
+// used by a later statement unless assigned by the last one.
+func ParseScript(src string) (*Script, error) {
+	return ParseScriptWith(src, Options{})
+}
+
+// ParseScriptWith is like ParseScript, but reads numbers according to opts.
+func ParseScriptWith(src string, opts Options) (*Script, error) {
+	tokens, err := TokenizeWith(src, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokens[0].Kind == EOF {
+		return nil, newError(CodeEmptyExpression, 0, 0, "expression is empty")
+	}
+
+	p := &parser{tokens: tokens, maxDepth: opts.MaxDepth}
+	script := &Script{}
+
+	for {
+		stmt, err := p.parseStmt()
+		if err != nil {
+			return nil, err
+		}
+		script.Stmts = append(script.Stmts, stmt)
+
+		tok := p.peek()
+		if tok.Kind == EOF {
+			break
+		}
+		if tok.Kind != Semicolon {
+			return nil, p.unexpected(tok)
+		}
+
+		p.next()
+		if p.peek().Kind == EOF {
+			break
+		}
+	}
+
+	err = resolve(script)
+	if err != nil {
+		return nil, err
+	}
+
+	return script, nil
+}
+
+func (p *parser) parseStmt() (Node, error) {
+	name := p.peek()
+	if name.Kind != Name || p.tokens[p.pos+1].Kind != Assign {
+		return p.parseExpr(0)
+	}
+
+	if IsReserved(name.Value) {
+		return nil, newError(CodeInvalidAssignment, name.Pos, len(name.Value), "cannot assign to reserved name %q", name.Value)
+	}
+
+	p.next()
+	eq := p.next()
+
+	x, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AssignStmt{
+		Name:  &Ident{Name: name.Value, NamePos: name.Pos},
+		X:     x,
+		EqPos: eq.Pos,
+	}, nil
+}
+
+func resolve(script *Script) error {
+	assigned := make(map[string]bool)
+	for _, stmt := range script.Stmts {
+		if as, ok := stmt.(*AssignStmt); ok {
+			if assigned[as.Name.Name] {
+				return newError(CodeInvalidAssignment, as.Name.Pos(), len(as.Name.Name), "%q is already assigned", as.Name.Name).
+					withHint("use another name")
+			}
+			assigned[as.Name.Name] = true
+		}
+	}
+
+	defined := make(map[string]bool)
+	used := make(map[string]bool)
+	for i, stmt := range script.Stmts {
+		as, ok := stmt.(*AssignStmt)
+		if !ok && i < len(script.Stmts)-1 {
+			return newError(CodeUnused, stmt.Pos(), stmt.End()-stmt.Pos(), "result of the statement is not used").
+				withHint("assign it to a name used later, or make it the last statement")
+		}
+
+		x := stmt
+		if ok {
+			x = as.X
+		}
+
+		var err error
+		Inspect(x, func(n Node) bool {
+			if id, ok := n.(*Ident); ok && assigned[id.Name] {
+				if !defined[id.Name] && err == nil {
+					err = newError(CodeUndefinedName, id.Pos(), len(id.Name), "%q is used before assignment", id.Name).
+						withHint("assign it in an earlier statement")
+				}
+				used[id.Name] = true
+			}
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			defined[as.Name.Name] = true
+		}
+	}
+
+	for _, stmt := range script.Stmts[:len(script.Stmts)-1] {
+		as := stmt.(*AssignStmt)
+		if !used[as.Name.Name] {
+			return newError(CodeUnused, as.Name.Pos(), len(as.Name.Name), "%q is assigned but never used", as.Name.Name).
+				withHint("use it in a later statement or remove the assignment")
+		}
+	}
+
+	return nil
+}
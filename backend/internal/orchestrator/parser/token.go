@@ -0,0 +1,68 @@
+package parser
+
+import "fmt"
+
+type Kind int
+
+const (
+	EOF Kind = iota
+	Number
+	Operator
+	LParen
+	RParen
+	Name
+	Comma
+	Assign
+	Semicolon
+	LBrack
+	RBrack
+)
+
+func (k Kind) String() string {
+	switch k {
+	case EOF:
+		return "end of expression"
+	case Number:
+		return "number"
+	case Operator:
+		return "operator"
+	case LParen:
+		return "'('"
+	case RParen:
+		return "')'"
+	case Name:
+		return "identifier"
+	case Comma:
+		return "','"
+	case Assign:
+		return "'='"
+	case Semicolon:
+		return "';'"
+	case LBrack:
+		return "'['"
+	case RBrack:
+		return "']'"
+	}
+
+	return fmt.Sprintf("token(%d)", int(k))
+}
+
+// Token is a lexical unit of an expression. Pos is the byte offset of the
+// first character of the token in the source.
+type Token struct {
+	Kind  Kind
+	Value string
+	Pos   int
+}
+
+func (t Token) End() int {
+	return t.Pos + len(t.Value)
+}
+
+func (t Token) String() string {
+	if t.Kind == EOF {
+		return t.Kind.String()
+	}
+
+	return fmt.Sprintf("%s %q", t.Kind, t.Value)
+}
@@ -0,0 +1,40 @@
+package parser
+
+// Inspect traverses the tree in depth-first order, calling f for every node.
+// Children of a node are skipped when f returns false.
+func Inspect(node Node, f func(Node) bool) {
+	if !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *BinaryExpr:
+		Inspect(n.X, f)
+		Inspect(n.Y, f)
+	case *UnaryExpr:
+		Inspect(n.X, f)
+	case *CallExpr:
+		for _, arg := range n.Args {
+			Inspect(arg, f)
+		}
+	case *ParenExpr:
+		Inspect(n.X, f)
+	case *ListExpr:
+		for _, elem := range n.Elems {
+			Inspect(elem, f)
+		}
+	case *QuantityExpr:
+		Inspect(n.X, f)
+		Inspect(n.Unit, f)
+	case *ConvertExpr:
+		Inspect(n.X, f)
+		Inspect(n.Unit, f)
+	case *AssignStmt:
+		Inspect(n.Name, f)
+		Inspect(n.X, f)
+	case *Script:
+		for _, stmt := range n.Stmts {
+			Inspect(stmt, f)
+		}
+	}
+}
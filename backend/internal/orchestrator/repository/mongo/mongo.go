@@ -11,12 +11,16 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
 )
 
 const (
-	collUsers = "users"
-	collExp   = "expressions"
-	collTasks = "tasks"
+	collUsers     = "users"
+	collExp       = "expressions"
+	collTasks     = "tasks"
+	collVariables = "variables"
+	// collDeadLetters keeps tasks which exhausted their retries.
+	collDeadLetters = "dead_letters"
 )
 
 type Repository struct {
@@ -159,13 +163,40 @@ func (r *Repository) GetUserByID(ctx context.Context, id string) (*models.User,
 }
 
 func (r *Repository) Update(ctx context.Context, exp *models.Expression) error {
+	set := bson.M{
+		"result": exp.Result,
+		"status": exp.Status,
+	}
+
+	if exp.Decimal != "" {
+		set["decimal"] = exp.Decimal
+	}
+
+	if exp.Fraction != "" {
+		set["fraction"] = exp.Fraction
+	}
+
+	if exp.Complex != nil {
+		set["complex"] = exp.Complex
+	}
+
+	if exp.Integer != "" {
+		set["integer"] = exp.Integer
+	}
+
+	if exp.Error != "" {
+		set["error"] = exp.Error
+	}
+
+	if exp.FailedExpression != "" {
+		set["failed_expression"] = exp.FailedExpression
+	}
+
 	_, err := r.client.
 		Database(r.cfg.DBName).
 		Collection(collExp).
-		UpdateByID(ctx, exp.Id, bson.M{
-			"$set": bson.M{
-				"result": exp.Result,
-				"status": exp.Status},
+		UpdateOne(ctx, bson.M{"_id": exp.Id, "status": bson.M{"$in": bson.A{"pending", "stalled"}}}, bson.M{
+			"$set": set,
 		})
 	if err != nil {
 		return fmt.Errorf("failed to update exp: %w", err)
@@ -174,6 +205,23 @@ func (r *Repository) Update(ctx context.Context, exp *models.Expression) error {
 	return nil
 }
 
+// CountPending reports the number of expressions of the user which are still
+// being evaluated.
+func (r *Repository) CountPending(ctx context.Context, userID string) (int64, error) {
+	n, err := r.client.
+		Database(r.cfg.DBName).
+		Collection(collExp).
+		CountDocuments(ctx, bson.M{
+			"user_id": userID,
+			"status":  "pending",
+		})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pending expressions for user %s: %w", userID, err)
+	}
+
+	return n, nil
+}
+
 func (r *Repository) AddTasks(ctx context.Context, tasks []*models.Task) error {
 	docs := make([]interface{}, 0, len(tasks))
 	for _, task := range tasks {
@@ -191,11 +239,11 @@ func (r *Repository) AddTasks(ctx context.Context, tasks []*models.Task) error {
 	return nil
 }
 
-func (r *Repository) GetTask(ctx context.Context) (*models.Task, error) {
+func (r *Repository) GetTask(ctx context.Context, id string) (*models.Task, error) {
 	res := r.client.
 		Database(r.cfg.DBName).
 		Collection(collTasks).
-		FindOne(ctx, bson.M{"status": "ready"})
+		FindOne(ctx, bson.M{"_id": id})
 	if errors.Is(res.Err(), mongo.ErrNoDocuments) {
 		return nil, fmt.Errorf("task not found: %w", sql.ErrNoRows)
 	}
@@ -213,97 +261,471 @@ func (r *Repository) GetTask(ctx context.Context) (*models.Task, error) {
 	return &task, nil
 }
 
-func (r *Repository) UpdateTask(ctx context.Context, task *models.Task) error {
-	session, err := r.client.StartSession()
+// ClaimTask atomically moves a ready task to in_progress on behalf of the
+// claimant, so concurrent claims never return the same task.
+func (r *Repository) ClaimTask(ctx context.Context, claimant string, lease time.Duration) (*models.Task, error) {
+	now := time.Now()
+	res := r.client.
+		Database(r.cfg.DBName).
+		Collection(collTasks).
+		FindOneAndUpdate(ctx,
+			bson.M{"status": "ready", "retry_at": bson.M{"$not": bson.M{"$gt": now}}},
+			bson.M{"$set": bson.M{
+				"status":      "in_progress",
+				"claimed_by":  claimant,
+				"claimed_at":  now,
+				"lease_until": now.Add(lease),
+			}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		)
+	if errors.Is(res.Err(), mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("task not found: %w", sql.ErrNoRows)
+	}
+
+	if res.Err() != nil {
+		return nil, fmt.Errorf("failed to claim task: %w", res.Err())
+	}
+
+	var task models.Task
+	err := res.Decode(&task)
 	if err != nil {
-		return fmt.Errorf("failed to start session: %w", err)
+		return nil, fmt.Errorf("failed to claim task: %w", err)
 	}
-	defer session.EndSession(ctx)
 
-	err = session.StartTransaction()
+	return &task, nil
+}
+
+// ExpiredTasks lists tasks whose lease ended before now.
+func (r *Repository) ExpiredTasks(ctx context.Context, now time.Time) ([]*models.Task, error) {
+	tasks, err := r.findTasks(ctx, bson.M{"status": "in_progress", "lease_until": bson.M{"$lt": now}})
 	if err != nil {
-		return fmt.Errorf("failed to start transaction: %w", err)
+		return nil, fmt.Errorf("failed to get expired tasks: %w", err)
 	}
 
-	client := session.Client()
+	return tasks, nil
+}
 
-	_, err = client.
-		Database(r.cfg.DBName).
-		Collection(collTasks).DeleteOne(ctx, bson.M{"_id": task.ID})
-	//UpdateByID(ctx, task.ID, bson.M{
-	//	"$set": bson.M{
-	//		"result": task.Result,
-	//		"status": task.Status,
-	//	},
-	//})
+// ClaimedTasks lists tasks held by the claimant.
+func (r *Repository) ClaimedTasks(ctx context.Context, claimant string) ([]*models.Task, error) {
+	tasks, err := r.findTasks(ctx, bson.M{"status": "in_progress", "claimed_by": claimant})
 	if err != nil {
-		return fmt.Errorf("failed to update task: %w", err)
+		return nil, fmt.Errorf("failed to get claimed tasks: %w", err)
+	}
+
+	return tasks, nil
+}
+
+// RunningTasks lists claimed tasks of the expression.
+func (r *Repository) RunningTasks(ctx context.Context, expID string) ([]*models.Task, error) {
+	tasks, err := r.findTasks(ctx, bson.M{"status": "in_progress", "exp_id": expID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get running tasks: %w", err)
 	}
 
-	_, err = client.
+	return tasks, nil
+}
+
+func (r *Repository) findTasks(ctx context.Context, filter bson.M) ([]*models.Task, error) {
+	res, err := r.client.
 		Database(r.cfg.DBName).
 		Collection(collTasks).
-		UpdateMany(ctx, bson.M{"left_id": task.ID}, bson.M{
-			"$unset": bson.M{
-				"left_id": "",
-			},
-			"$set": bson.M{
-				"left_arg": task.Result,
-			},
-		})
+		Find(ctx, filter)
 	if err != nil {
-		return fmt.Errorf("failed to update task: %w", err)
+		return nil, err
+	}
+
+	var tasks []*models.Task
+	err = res.All(ctx, &tasks)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = client.
+	return tasks, nil
+}
+
+// RequeueTask returns the task claimed by task.ClaimedBy to ready, it is not
+// claimed again before task.RetryAt.
+func (r *Repository) RequeueTask(ctx context.Context, task *models.Task) error {
+	_, err := r.client.
 		Database(r.cfg.DBName).
 		Collection(collTasks).
-		UpdateMany(ctx, bson.M{"right_id": task.ID}, bson.M{
-			"$unset": bson.M{
-				"right_id": "",
-			},
-			"$set": bson.M{
-				"right_arg": task.Result,
-			},
-		})
+		UpdateOne(ctx,
+			bson.M{"_id": task.ID, "status": "in_progress", "claimed_by": task.ClaimedBy},
+			bson.M{
+				"$set":   bson.M{"status": "ready", "retries": task.Retries, "retry_at": task.RetryAt},
+				"$unset": bson.M{"claimed_by": "", "claimed_at": "", "lease_until": ""},
+			})
 	if err != nil {
-		return fmt.Errorf("failed to update task: %w", err)
+		return fmt.Errorf("failed to requeue task: %w", err)
+	}
+
+	return nil
+}
+
+// AddDeadLetter deletes the claimed task and keeps it in the dead letters.
+func (r *Repository) AddDeadLetter(ctx context.Context, dl *models.DeadLetter) error {
+	err := r.transaction(ctx, func(sc mongo.SessionContext) error {
+		db := sc.Client().Database(r.cfg.DBName)
+
+		res, err := db.
+			Collection(collTasks).
+			DeleteOne(sc, bson.M{"_id": dl.ID, "status": "in_progress", "claimed_by": dl.Task.ClaimedBy})
+		if err != nil {
+			return err
+		}
+
+		// The task was finished meanwhile.
+		if res.DeletedCount == 0 {
+			return nil
+		}
+
+		_, err = db.
+			Collection(collDeadLetters).
+			InsertOne(sc, dl)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.
+			Collection(collExp).
+			UpdateOne(sc, bson.M{"_id": dl.ExpID, "status": "pending"}, bson.M{"$set": bson.M{"status": "stalled"}})
+
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add dead letter: %w", err)
 	}
 
-	_, err = client.
+	return nil
+}
+
+func (r *Repository) GetDeadLetters(ctx context.Context) ([]*models.DeadLetter, error) {
+	res, err := r.client.
 		Database(r.cfg.DBName).
-		Collection(collTasks).
-		UpdateMany(ctx,
+		Collection(collDeadLetters).
+		Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"failed_at": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letters: %w", err)
+	}
+
+	dls := make([]*models.DeadLetter, 0)
+	err = res.All(ctx, &dls)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letters: %w", err)
+	}
+
+	return dls, nil
+}
+
+// RequeueDeadLetter moves the task of the dead letter back to the tasks as
+// ready, with no retries made.
+func (r *Repository) RequeueDeadLetter(ctx context.Context, id string) error {
+	err := r.transaction(ctx, func(sc mongo.SessionContext) error {
+		db := sc.Client().Database(r.cfg.DBName)
+
+		var dl models.DeadLetter
+		err := db.
+			Collection(collDeadLetters).
+			FindOneAndDelete(sc, bson.M{"_id": id}).
+			Decode(&dl)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("dead letter not found: %w", sql.ErrNoRows)
+		}
+		if err != nil {
+			return err
+		}
+
+		task := dl.Task
+		task.Status = "ready"
+		task.ClaimedBy = ""
+		task.ClaimedAt = time.Time{}
+		task.LeaseUntil = time.Time{}
+		task.Retries = 0
+		task.RetryAt = time.Time{}
+
+		_, err = db.
+			Collection(collTasks).
+			InsertOne(sc, task)
+		if err != nil {
+			return err
+		}
+
+		left, err := db.
+			Collection(collDeadLetters).
+			CountDocuments(sc, bson.M{"exp_id": dl.ExpID})
+		if err != nil {
+			return err
+		}
+
+		if left == 0 {
+			_, err = db.
+				Collection(collExp).
+				UpdateOne(sc, bson.M{"_id": dl.ExpID, "status": "stalled"}, bson.M{"$set": bson.M{"status": "pending"}})
+		}
+
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to requeue dead letter: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateTask deletes the finished task and passes its result to every
+// argument waiting for it, a task may be shared by several consumers and
+// used by the same consumer more than once. Only tasks of the expression
+// task.ExpID are updated.
+func (r *Repository) UpdateTask(ctx context.Context, task *models.Task) error {
+	err := r.transaction(ctx, func(sc mongo.SessionContext) error {
+		client := sc.Client()
+		coll := client.Database(r.cfg.DBName).Collection(collTasks)
+
+		var done models.Task
+		err := coll.
+			FindOneAndDelete(sc, bson.M{"_id": task.ID}).
+			Decode(&done)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+
+		if done.Name != "" {
+			_, err = client.
+				Database(r.cfg.DBName).
+				Collection(collExp).
+				UpdateByID(sc, done.ExpID, bson.M{
+					"$set": bson.M{
+						"values." + done.Name: task.Result,
+					},
+				})
+			if err != nil {
+				return err
+			}
+		}
+
+		if done.Condition {
+			err = r.chooseBranch(sc, client, &done, task.IsTrue())
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = coll.UpdateMany(sc,
+			bson.M{"exp_id": task.ExpID, "args.task_id": task.ID},
+			bson.M{
+				"$unset": bson.M{
+					"args.$[arg].task_id": "",
+				},
+				"$set": bson.M{
+					"args.$[arg].value":  task.Result,
+					"args.$[arg].number": task.Number,
+				},
+			},
+			options.Update().SetArrayFilters(options.ArrayFilters{
+				Filters: []interface{}{bson.M{"arg.task_id": task.ID}},
+			}))
+		if err != nil {
+			return err
+		}
+
+		_, err = coll.UpdateMany(sc,
 			bson.M{
-				"left_id":  bson.M{"$exists": false},
-				"right_id": bson.M{"$exists": false},
+				"exp_id":       task.ExpID,
+				"args.task_id": bson.M{"$exists": false},
+				"guards.0":     bson.M{"$exists": false},
+				"status":       bson.M{"$ne": "in_progress"},
 			},
 			bson.M{
 				"$set": bson.M{
 					"status": "ready",
 				},
 			})
+
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to update task: %w", err)
 	}
 
-	err = session.CommitTransaction(ctx)
+	return nil
+}
+
+// transaction runs fn in a transaction. Transactions of sibling tasks may
+// conflict on the arguments of their consumer, fn is run again on transient
+// errors and the commit is retried while its result is unknown.
+func (r *Repository) transaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	session, err := r.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+
+	return err
+}
+
+// chooseBranch deletes tasks of the branches of cond which are not taken,
+// releases tasks of the taken ones and turns the 'if' tasks of cond into
+// identities of the taken branch.
+func (r *Repository) chooseBranch(ctx context.Context, client *mongo.Client, cond *models.Task, branch bool) error {
+	coll := client.Database(r.cfg.DBName).Collection(collTasks)
+
+	_, err := coll.DeleteMany(ctx, bson.M{
+		"exp_id": cond.ExpID,
+		"guards": models.BranchGuard(cond.ID, !branch),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete discarded branch: %w", err)
+	}
+
+	chosen := models.BranchGuard(cond.ID, branch)
+	_, err = coll.UpdateMany(ctx,
+		bson.M{"exp_id": cond.ExpID, "guards": chosen},
+		bson.M{"$pull": bson.M{"guards": chosen}})
+	if err != nil {
+		return fmt.Errorf("failed to release chosen branch: %w", err)
+	}
+
+	discarded := "args.2"
+	if !branch {
+		discarded = "args.1"
+	}
+
+	// Unset elements of an array become null, so they are pulled after.
+	_, err = coll.UpdateMany(ctx,
+		bson.M{"exp_id": cond.ExpID, "op": "if", "args.0.task_id": cond.ID},
+		bson.M{
+			"$set":   bson.M{"op": ""},
+			"$unset": bson.M{"args.0": "", discarded: ""},
+		})
+	if err != nil {
+		return fmt.Errorf("failed to update conditional: %w", err)
+	}
+
+	_, err = coll.UpdateMany(ctx,
+		bson.M{"exp_id": cond.ExpID, "args": nil},
+		bson.M{"$pull": bson.M{"args": nil}})
 	if err != nil {
-		return fmt.Errorf("failed to update task: failed to commmit transaction %w", err)
+		return fmt.Errorf("failed to update conditional: %w", err)
 	}
 
 	return nil
 }
 
+// DeleteTasks deletes the tasks of the expression, dead letters included.
 func (r *Repository) DeleteTasks(ctx context.Context, expID string) error {
+	for _, coll := range []string{collTasks, collDeadLetters} {
+		_, err := r.client.
+			Database(r.cfg.DBName).
+			Collection(coll).
+			DeleteMany(ctx, bson.M{
+				"exp_id": expID,
+			})
+		if err != nil {
+			return fmt.Errorf("failed to delete tasks: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Repository) AddVariable(ctx context.Context, v *models.Variable) error {
 	_, err := r.client.
 		Database(r.cfg.DBName).
-		Collection(collTasks).
-		DeleteMany(ctx, bson.M{
-			"exp_id": expID,
+		Collection(collVariables).
+		InsertOne(ctx, v)
+	if err != nil {
+		var e mongo.WriteException
+		if errors.As(err, &e) {
+			if e.HasErrorCode(11000) {
+				return fmt.Errorf("failed to add variable: %w", errors2.ErrVariableAlreadyExists)
+			}
+		}
+		return fmt.Errorf("failed to add variable: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetVariable(ctx context.Context, userID, name string) (*models.Variable, error) {
+	res := r.client.
+		Database(r.cfg.DBName).
+		Collection(collVariables).
+		FindOne(ctx, bson.M{"user_id": userID, "name": name})
+	if errors.Is(res.Err(), mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("failed to get variable: %w", errors2.ErrVariableDoesNotExist)
+	}
+
+	if res.Err() != nil {
+		return nil, fmt.Errorf("failed to get variable: %w", res.Err())
+	}
+
+	var v models.Variable
+	err := res.Decode(&v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variable: %w", err)
+	}
+
+	return &v, nil
+}
+
+func (r *Repository) GetVariables(ctx context.Context, userID string) ([]*models.Variable, error) {
+	res, err := r.client.
+		Database(r.cfg.DBName).
+		Collection(collVariables).
+		Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variables for user %s: %w", userID, err)
+	}
+
+	vars := make([]*models.Variable, 0, res.RemainingBatchLength())
+	for res.Next(ctx) {
+		var v models.Variable
+		err := res.Decode(&v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get variables for user %s: %w", userID, err)
+		}
+
+		vars = append(vars, &v)
+	}
+
+	return vars, nil
+}
+
+func (r *Repository) UpdateVariable(ctx context.Context, v *models.Variable) error {
+	res, err := r.client.
+		Database(r.cfg.DBName).
+		Collection(collVariables).
+		UpdateOne(ctx, bson.M{"user_id": v.UserID, "name": v.Name}, bson.M{
+			"$set": bson.M{
+				"value": v.Value,
+			},
 		})
 	if err != nil {
-		return fmt.Errorf("failed to delete tasks: %w", err)
+		return fmt.Errorf("failed to update variable: %w", err)
+	}
+
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("failed to update variable: %w", errors2.ErrVariableDoesNotExist)
+	}
+
+	return nil
+}
+
+func (r *Repository) DeleteVariable(ctx context.Context, userID, name string) error {
+	res, err := r.client.
+		Database(r.cfg.DBName).
+		Collection(collVariables).
+		DeleteOne(ctx, bson.M{"user_id": userID, "name": name})
+	if err != nil {
+		return fmt.Errorf("failed to delete variable: %w", err)
+	}
+
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("failed to delete variable: %w", errors2.ErrVariableDoesNotExist)
 	}
 
 	return nil
@@ -2,9 +2,15 @@ package mongo
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	errors2 "github.com/distributed-calc/v1/internal/orchestrator/errors"
 	"github.com/distributed-calc/v1/internal/orchestrator/models"
 	"github.com/distributed-calc/v1/pkg/mongo"
 	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"sync"
 	"testing"
 	"time"
 )
@@ -126,22 +132,22 @@ func TestRepository_AddTasks(t *testing.T) {
 			name: "success",
 			tasks: []*models.Task{
 				{
-					ID:       uuid.NewString(),
-					ExpID:    uuid.NewString(),
-					Op:       "+",
-					LeftArg:  10,
-					RightArg: 20,
-					LeftID:   nil,
-					RightID:  &id,
+					ID:    uuid.NewString(),
+					ExpID: uuid.NewString(),
+					Op:    "+",
+					Args:  []models.Arg{{Value: 10}, {TaskID: &id}},
 				},
 				{
-					ID:       uuid.NewString(),
-					ExpID:    uuid.NewString(),
-					Op:       "-",
-					LeftArg:  10,
-					RightArg: 20,
-					LeftID:   nil,
-					RightID:  nil,
+					ID:    uuid.NewString(),
+					ExpID: uuid.NewString(),
+					Op:    "-",
+					Args:  []models.Arg{{Value: 10}, {Value: 20}},
+				},
+				{
+					ID:    uuid.NewString(),
+					ExpID: uuid.NewString(),
+					Op:    "max",
+					Args:  []models.Arg{{Value: 10}, {Value: 20}, {TaskID: &id}},
 				},
 			},
 		},
@@ -237,6 +243,49 @@ func TestRepository_Get(t *testing.T) {
 	}
 }
 
+func TestRepository_CountPending(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cfg, err := mongo.NewMongoConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := mongo.NewMongoClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Database(cfg.DBName).Collection(collExp).Drop(ctx)
+	})
+
+	repo := NewMongoRepository(cfg, client)
+
+	expressions := []*models.Expression{
+		{Id: "test:count:1", UserID: "test:count", Status: "pending"},
+		{Id: "test:count:2", UserID: "test:count", Status: "pending"},
+		{Id: "test:count:3", UserID: "test:count", Status: "completed"},
+		{Id: "test:count:4", UserID: "test:count:other", Status: "pending"},
+	}
+	for _, exp := range expressions {
+		err = repo.Add(ctx, exp)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := repo.CountPending(ctx, "test:count")
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+
+	if n != 2 {
+		t.Errorf("expected 2 pending expressions, got %d", n)
+	}
+}
+
 func TestRepository_GetAll(t *testing.T) {
 	cases := []struct {
 		name    string
@@ -346,7 +395,7 @@ func TestRepository_GetUser(t *testing.T) {
 	}
 }
 
-func TestRepository_GetTask(t *testing.T) {
+func TestRepository_ClaimTask(t *testing.T) {
 	cases := []struct {
 		name    string
 		wantErr bool
@@ -391,11 +440,15 @@ func TestRepository_GetTask(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 			defer cancel()
 
-			_, err := repo.GetTask(ctx)
+			task, err := repo.ClaimTask(ctx, "agent", time.Minute)
 			if tc.wantErr == false && err != nil {
 				t.Errorf("expected no error got %v", err)
 			}
 
+			if err == nil && (task.Status != "in_progress" || task.ClaimedBy != "agent" || task.ClaimedAt.IsZero() || !task.LeaseUntil.After(task.ClaimedAt)) {
+				t.Errorf("expected task claimed by agent, got %s by %q at %v until %v", task.Status, task.ClaimedBy, task.ClaimedAt, task.LeaseUntil)
+			}
+
 			if tc.wantErr == true && err == nil {
 				t.Errorf("expected error got none")
 			}
@@ -403,6 +456,207 @@ func TestRepository_GetTask(t *testing.T) {
 	}
 }
 
+func TestRepository_ClaimTask_Concurrent(t *testing.T) {
+	const (
+		tasks    = 200
+		claimers = 16
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg, err := mongo.NewMongoConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := mongo.NewMongoClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Database(cfg.DBName).Collection(collTasks).Drop(ctx)
+	})
+
+	repo := NewMongoRepository(cfg, client)
+
+	expID := uuid.NewString()
+	batch := make([]*models.Task, 0, tasks)
+	for i := range tasks {
+		batch = append(batch, &models.Task{
+			ID:     fmt.Sprintf("%s:%d", expID, i),
+			ExpID:  expID,
+			Status: "ready",
+		})
+	}
+
+	err = repo.AddTasks(ctx, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu      sync.Mutex
+		claimed = make(map[string]string, tasks)
+		wg      sync.WaitGroup
+	)
+
+	for i := range claimers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			claimant := fmt.Sprintf("agent-%d", i)
+			for {
+				task, err := repo.ClaimTask(ctx, claimant, time.Minute)
+				if errors.Is(err, sql.ErrNoRows) {
+					return
+				}
+				if err != nil {
+					t.Errorf("expected no error got %v", err)
+					return
+				}
+
+				mu.Lock()
+				if other, ok := claimed[task.ID]; ok {
+					t.Errorf("task %s claimed by %s and %s", task.ID, other, claimant)
+				}
+				claimed[task.ID] = claimant
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(claimed) != tasks {
+		t.Errorf("expected %d claimed tasks, got %d", tasks, len(claimed))
+	}
+}
+
+func TestRepository_RetryTasks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cfg, err := mongo.NewMongoConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := mongo.NewMongoClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Database(cfg.DBName).Collection(collTasks).Drop(ctx)
+		client.Database(cfg.DBName).Collection(collDeadLetters).Drop(ctx)
+		client.Database(cfg.DBName).Collection(collExp).Drop(ctx)
+	})
+
+	repo := NewMongoRepository(cfg, client)
+
+	expID := uuid.NewString()
+	err = repo.Add(ctx, &models.Expression{Id: expID, Status: "pending"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.AddTasks(ctx, []*models.Task{
+		{ID: expID + ":0", ExpID: expID, Status: "ready"},
+		{ID: expID + ":1", ExpID: expID, Status: "ready"},
+		{ID: expID + ":2", ExpID: expID, Status: "ready"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The lease of the dead agent has already expired.
+	for _, claimant := range []string{"dead", "alive", "closed"} {
+		lease := time.Minute
+		if claimant == "dead" {
+			lease = -time.Second
+		}
+
+		_, err = repo.ClaimTask(ctx, claimant, lease)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expired, err := repo.ExpiredTasks(ctx, time.Now())
+	if err != nil || len(expired) != 1 || expired[0].ClaimedBy != "dead" {
+		t.Fatalf("expected the task of the dead agent to expire, got %v, %v", expired, err)
+	}
+
+	released, err := repo.ClaimedTasks(ctx, "closed")
+	if err != nil || len(released) != 1 {
+		t.Fatalf("expected 1 task of the closed stream, got %v, %v", released, err)
+	}
+
+	running, err := repo.RunningTasks(ctx, expID)
+	if err != nil || len(running) != 3 {
+		t.Fatalf("expected 3 running tasks of the expression, got %v, %v", running, err)
+	}
+
+	// The retried task is not ready before its backoff passes.
+	retried := expired[0]
+	retried.Retries = 1
+	retried.RetryAt = time.Now().Add(time.Hour)
+	err = repo.RequeueTask(ctx, retried)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.AddDeadLetter(ctx, &models.DeadLetter{
+		ID:       released[0].ID,
+		ExpID:    expID,
+		Retries:  3,
+		Reason:   "agent stream closed",
+		FailedAt: time.Now(),
+		Task:     released[0],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.ClaimTask(ctx, "other", time.Minute)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected no ready tasks, got %v", err)
+	}
+
+	exp, err := repo.Get(ctx, expID)
+	if err != nil || exp.Status != "stalled" {
+		t.Errorf("expected the expression to be stalled, got %v, %v", exp, err)
+	}
+
+	dls, err := repo.GetDeadLetters(ctx)
+	if err != nil || len(dls) != 1 || dls[0].ID != released[0].ID || dls[0].Task == nil {
+		t.Fatalf("expected the task of the closed stream in dead letters, got %v, %v", dls, err)
+	}
+
+	err = repo.RequeueDeadLetter(ctx, dls[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task, err := repo.ClaimTask(ctx, "other", time.Minute)
+	if err != nil || task.ID != dls[0].ID || task.Retries != 0 {
+		t.Errorf("expected requeued dead letter to be claimed, got %v, %v", task, err)
+	}
+
+	exp, err = repo.Get(ctx, expID)
+	if err != nil || exp.Status != "pending" {
+		t.Errorf("expected the expression to be pending again, got %v, %v", exp, err)
+	}
+
+	err = repo.RequeueDeadLetter(ctx, dls[0].ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected dead letter to be gone, got %v", err)
+	}
+}
+
 func TestRepository_Update(t *testing.T) {
 	cases := []struct {
 		name    string
@@ -437,7 +691,7 @@ func TestRepository_Update(t *testing.T) {
 
 	repo := NewMongoRepository(cfg, client)
 
-	err = repo.Add(ctx, &models.Expression{Id: "test:update:1", UserID: "test:update:1"})
+	err = repo.Add(ctx, &models.Expression{Id: "test:update:1", UserID: "test:update:1", Status: "pending"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -459,6 +713,53 @@ func TestRepository_Update(t *testing.T) {
 	}
 }
 
+func TestRepository_Update_Failed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cfg, err := mongo.NewMongoConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := mongo.NewMongoClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Database(cfg.DBName).Collection(collExp).Drop(ctx)
+	})
+
+	repo := NewMongoRepository(cfg, client)
+
+	id := uuid.NewString()
+	err = repo.Add(ctx, &models.Expression{Id: id, UserID: "user", Status: "pending"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.Update(ctx, &models.Expression{Id: id, Status: "failed", Error: "division by zero", FailedExpression: "1 / 0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A late result must not complete the failed expression.
+	err = repo.Update(ctx, &models.Expression{Id: id, Status: "completed", Result: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp.Status != "failed" || exp.Error != "division by zero" || exp.FailedExpression != "1 / 0" || exp.Result != 0 {
+		t.Errorf("expected failed expression, got %s with %q at %q and result %v", exp.Status, exp.Error, exp.FailedExpression, exp.Result)
+	}
+}
+
 func TestRepository_UpdateTask(t *testing.T) {
 	cases := []struct {
 		name    string
@@ -468,7 +769,8 @@ func TestRepository_UpdateTask(t *testing.T) {
 		{
 			name: "success",
 			task: &models.Task{
-				ID: "test:update:task:1",
+				ID:    "test:update:task:1",
+				ExpID: "test:2",
 			},
 		},
 	}
@@ -492,13 +794,19 @@ func TestRepository_UpdateTask(t *testing.T) {
 
 	repo := NewMongoRepository(cfg, client)
 
-	leftID := "test:update:task:1"
+	argID := "test:update:task:1"
 	err = repo.AddTasks(ctx, []*models.Task{
 		{
 			ID:     uuid.NewString(),
 			ExpID:  "test:2",
 			Status: "pending",
-			LeftID: &leftID,
+			Args:   []models.Arg{{TaskID: &argID}, {Value: 1}},
+		},
+		{
+			ID:     uuid.NewString(),
+			ExpID:  "test:2",
+			Status: "pending",
+			Args:   []models.Arg{{TaskID: &argID}, {TaskID: &argID}, {Value: 1}},
 		},
 	})
 	if err != nil {
@@ -522,6 +830,190 @@ func TestRepository_UpdateTask(t *testing.T) {
 	}
 }
 
+func TestRepository_UpdateTask_NamedValue(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cfg, err := mongo.NewMongoConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := mongo.NewMongoClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Database(cfg.DBName).Collection(collTasks).Drop(ctx)
+		client.Database(cfg.DBName).Collection(collExp).Drop(ctx)
+	})
+
+	repo := NewMongoRepository(cfg, client)
+
+	err = repo.Add(ctx, &models.Expression{Id: "test:named", Status: "pending"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.AddTasks(ctx, []*models.Task{
+		{
+			ID:     "test:named:1",
+			ExpID:  "test:named",
+			Status: "ready",
+			Args:   []models.Arg{{Value: 5}},
+			Name:   "a",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.UpdateTask(ctx, &models.Task{ID: "test:named:1", ExpID: "test:named", Result: 5})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+
+	exp, err := repo.Get(ctx, "test:named")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp.Values["a"] != 5 {
+		t.Errorf("expected value of a to be saved, got %v", exp.Values)
+	}
+}
+
+func TestRepository_UpdateTask_FanOut(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cfg, err := mongo.NewMongoConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := mongo.NewMongoClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Database(cfg.DBName).Collection(collTasks).Drop(ctx)
+	})
+
+	repo := NewMongoRepository(cfg, client)
+
+	shared, other := "test:cse:1", "test:cse:2"
+	err = repo.AddTasks(ctx, []*models.Task{
+		{ID: shared, ExpID: "test:cse", Status: "ready", Op: "+", Args: []models.Arg{{Value: 1}, {Value: 2}}},
+		{ID: other, ExpID: "test:cse", Status: "ready", Args: []models.Arg{{Value: 4}}},
+		{ID: "test:cse:3", ExpID: "test:cse", Op: "*", Args: []models.Arg{{TaskID: &shared}, {TaskID: &shared}}},
+		{ID: "test:cse:4", ExpID: "test:cse", Op: "-", Args: []models.Arg{{TaskID: &other}, {TaskID: &shared}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.UpdateTask(ctx, &models.Task{ID: shared, ExpID: "test:cse", Result: 3})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+
+	coll := client.Database(cfg.DBName).Collection(collTasks)
+
+	var square, diff models.Task
+	err = coll.FindOne(ctx, bson.M{"_id": "test:cse:3"}).Decode(&square)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = coll.FindOne(ctx, bson.M{"_id": "test:cse:4"}).Decode(&diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, arg := range square.Args {
+		if arg.TaskID != nil || arg.Value != 3 {
+			t.Errorf("expected both arguments of the square to be 3, got %+v", square.Args)
+		}
+	}
+	if square.Status != "ready" {
+		t.Errorf("expected the square to be ready, got %s", square.Status)
+	}
+
+	if diff.Args[1].TaskID != nil || diff.Args[1].Value != 3 || diff.Args[0].TaskID == nil {
+		t.Errorf("expected only the second argument of the difference to be filled, got %+v", diff.Args)
+	}
+	if diff.Status == "ready" {
+		t.Error("expected the difference to wait for its first argument")
+	}
+}
+
+func TestRepository_UpdateTask_Branches(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cfg, err := mongo.NewMongoConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := mongo.NewMongoClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Database(cfg.DBName).Collection(collTasks).Drop(ctx)
+	})
+
+	repo := NewMongoRepository(cfg, client)
+
+	cond, then, els := "test:if:1", "test:if:2", "test:if:3"
+	err = repo.AddTasks(ctx, []*models.Task{
+		{ID: cond, ExpID: "test:if", Status: "ready", Args: []models.Arg{{Value: 1}}, Condition: true},
+		{ID: then, ExpID: "test:if", Args: []models.Arg{{Value: 2}}, Guards: []string{models.BranchGuard(cond, true)}},
+		{ID: els, ExpID: "test:if", Args: []models.Arg{{Value: 3}}, Guards: []string{models.BranchGuard(cond, false)}},
+		{ID: "test:if:4", ExpID: "test:if", Op: "if", Args: []models.Arg{{TaskID: &cond}, {TaskID: &then}, {TaskID: &els}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.UpdateTask(ctx, &models.Task{ID: cond, ExpID: "test:if", Result: 1})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+
+	coll := client.Database(cfg.DBName).Collection(collTasks)
+
+	n, err := coll.CountDocuments(ctx, bson.M{"_id": els})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Error("expected task of discarded branch to be deleted")
+	}
+
+	var chosen models.Task
+	err = coll.FindOne(ctx, bson.M{"_id": then}).Decode(&chosen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chosen.Status != "ready" || len(chosen.Guards) != 0 {
+		t.Errorf("expected task of chosen branch to be ready, got %+v", chosen)
+	}
+
+	var conditional models.Task
+	err = coll.FindOne(ctx, bson.M{"_id": "test:if:4"}).Decode(&conditional)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conditional.Op != "" || len(conditional.Args) != 1 || *conditional.Args[0].TaskID != then {
+		t.Errorf("expected identity of chosen branch, got %+v", conditional)
+	}
+}
+
 func TestRepository_DeleteTasks(t *testing.T) {
 	cases := []struct {
 		name    string
@@ -580,3 +1072,69 @@ func TestRepository_DeleteTasks(t *testing.T) {
 		})
 	}
 }
+
+func TestRepository_Variables(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cfg, err := mongo.NewMongoConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := mongo.NewMongoClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Database(cfg.DBName).Collection(collVariables).Drop(ctx)
+	})
+
+	repo := NewMongoRepository(cfg, client)
+
+	userID := uuid.NewString()
+
+	err = repo.AddVariable(ctx, &models.Variable{UserID: userID, Name: "rate", Value: 0.07})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+
+	err = repo.UpdateVariable(ctx, &models.Variable{UserID: userID, Name: "rate", Value: 0.08})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+
+	v, err := repo.GetVariable(ctx, userID, "rate")
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+
+	if v.Value != 0.08 {
+		t.Errorf("expected value 0.08 got %v", v.Value)
+	}
+
+	vars, err := repo.GetVariables(ctx, userID)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+
+	if len(vars) != 1 {
+		t.Errorf("expected 1 variable got %d", len(vars))
+	}
+
+	err = repo.DeleteVariable(ctx, userID, "rate")
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+
+	_, err = repo.GetVariable(ctx, userID, "rate")
+	if !errors.Is(err, errors2.ErrVariableDoesNotExist) {
+		t.Errorf("expected %v got %v", errors2.ErrVariableDoesNotExist, err)
+	}
+
+	err = repo.DeleteVariable(ctx, userID, "rate")
+	if !errors.Is(err, errors2.ErrVariableDoesNotExist) {
+		t.Errorf("expected %v got %v", errors2.ErrVariableDoesNotExist, err)
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	e "github.com/distributed-calc/v1/internal/orchestrator/errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"sync"
+)
+
+// cancelBuffer is how many cancellations may wait for an agent stream to
+// pass them on. Further ones are dropped, results of the tasks they abort
+// are ignored anyway.
+const cancelBuffer = 64
+
+// cancellations passes IDs of cancelled tasks to the agent streams which
+// claimed them. Only streams of this instance are subscribed, agents
+// connected to other replicas are not told to abort.
+type cancellations struct {
+	mu   sync.Mutex
+	subs map[string]chan string
+}
+
+// Cancel marks the pending or stalled expression of the user as cancelled,
+// deletes its remaining tasks and tells agents computing them to abort.
+// Aborting is best-effort: agents connected to other orchestrator replicas
+// keep computing their tasks, whose results FinishTask then ignores.
+func (s *Service) Cancel(ctx context.Context, id, userID string) error {
+	exp, err := s.Get(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+
+	if exp.Status != StatusPending && exp.Status != StatusStalled {
+		return fmt.Errorf("%w: expression is already %s", e.ErrConflict, exp.Status)
+	}
+
+	// Claimed tasks are listed before they are deleted, the agents holding
+	// them are told to abort only once the expression is cancelled.
+	running, err := s.taskRepo.RunningTasks(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get running tasks: %w", err)
+	}
+
+	err = s.expRepo.Update(ctx, &models.Expression{Id: id, Status: StatusCancelled})
+	if err != nil {
+		return fmt.Errorf("failed to cancel expression: %w", err)
+	}
+
+	// Update leaves expressions which completed or failed meanwhile as they
+	// are.
+	exp, err = s.expRepo.Get(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get expression: %w", err)
+	}
+
+	if exp.Status != StatusCancelled {
+		return fmt.Errorf("%w: expression is already %s", e.ErrConflict, exp.Status)
+	}
+
+	err = s.taskRepo.DeleteTasks(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete tasks: %w", err)
+	}
+
+	for _, t := range running {
+		s.cancels.send(t.ClaimedBy, t.ID)
+	}
+
+	return nil
+}
+
+// Cancellations subscribes the agent stream identified by claimant to IDs
+// of its tasks which were cancelled. The returned function unsubscribes it.
+func (s *Service) Cancellations(claimant string) (<-chan string, func()) {
+	s.cancels.mu.Lock()
+	defer s.cancels.mu.Unlock()
+
+	if s.cancels.subs == nil {
+		s.cancels.subs = make(map[string]chan string)
+	}
+
+	ch := make(chan string, cancelBuffer)
+	s.cancels.subs[claimant] = ch
+
+	return ch, func() {
+		s.cancels.mu.Lock()
+		defer s.cancels.mu.Unlock()
+
+		delete(s.cancels.subs, claimant)
+	}
+}
+
+func (c *cancellations) send(claimant, taskID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case c.subs[claimant] <- taskID:
+	default:
+	}
+}
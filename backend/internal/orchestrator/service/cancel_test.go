@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/config"
+	e "github.com/distributed-calc/v1/internal/orchestrator/errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"github.com/distributed-calc/v1/test/mock"
+	"testing"
+	"time"
+)
+
+func TestService_Cancel(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(&config.Config{LeaseDuration: time.Minute}, repo, repo, repo, repo, nil, nil)
+
+	ctx := context.Background()
+
+	id, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: "(1 + 2) * (3 + 4)"}, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cancelled, unsubscribe := s.Cancellations("agent")
+	defer unsubscribe()
+
+	task, err := s.GetTask(ctx, "agent")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = s.Cancel(ctx, id, "other")
+	if !errors.Is(err, e.ErrExpressionDoesNotExist) {
+		t.Errorf("expected error %v for another user, got %v", e.ErrExpressionDoesNotExist, err)
+	}
+
+	err = s.Cancel(ctx, id, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exp, err := s.Get(ctx, id, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp.Status != StatusCancelled {
+		t.Errorf("expected status %s, got %s", StatusCancelled, exp.Status)
+	}
+
+	_, err = s.GetTask(ctx, "agent")
+	if !errors.Is(err, e.ErrNoTasks) {
+		t.Errorf("expected tasks to be deleted, got %v", err)
+	}
+
+	select {
+	case got := <-cancelled:
+		if got != task.Id {
+			t.Errorf("expected cancellation of task %s, got %s", task.Id, got)
+		}
+	default:
+		t.Error("expected the agent to be told to abort its task")
+	}
+
+	err = s.Cancel(ctx, id, "user")
+	if !errors.Is(err, e.ErrConflict) {
+		t.Errorf("expected error %v for a cancelled expression, got %v", e.ErrConflict, err)
+	}
+
+	// Results of aborted tasks arriving late are ignored.
+	err = s.FinishTask(ctx, &models.TaskResult{Id: task.Id, Result: 3, Status: "completed"})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	exp, _ = s.Get(ctx, id, "user")
+	if exp.Status != StatusCancelled {
+		t.Errorf("expected status %s after a late result, got %s", StatusCancelled, exp.Status)
+	}
+}
+
+func TestService_Cancel_NotFound(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(&config.Config{}, repo, repo, repo, repo, nil, nil)
+
+	err := s.Cancel(context.Background(), "missing", "user")
+	if !errors.Is(err, e.ErrExpressionDoesNotExist) {
+		t.Errorf("expected error %v, got %v", e.ErrExpressionDoesNotExist, err)
+	}
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Explain compiles the request like Evaluate, but returns the graph of tasks
+// instead of dispatching them. Nothing is persisted and the expression does
+// not count towards the pending limit of the user.
+func (s *Service) Explain(ctx context.Context, req *models.CalculateRequest, userID string) (*models.Explanation, error) {
+	exp, tasks, err := s.compile(ctx, req, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// IDs of tasks are prefixed with the ID of the expression, which is
+	// meaningless until it is added, and have gaps left by the optimizer.
+	ids := make(map[string]string, len(tasks))
+	for i, t := range tasks {
+		ids[t.ID] = "t" + strconv.Itoa(i)
+	}
+	short := func(id string) string {
+		return ids[id]
+	}
+
+	explanation := &models.Explanation{
+		Expression:   exp.Expression,
+		Mode:         exp.Mode,
+		Unit:         exp.Unit,
+		Tasks:        make([]models.ExplainedTask, 0, len(tasks)),
+		CriticalPath: exp.CriticalPath,
+	}
+
+	index := make(map[string]int, len(tasks))
+	depth := make([]int, len(tasks))
+	finish := make([]int64, len(tasks))
+	pred := make([]int, len(tasks))
+
+	last := -1
+	for i, t := range tasks {
+		et := models.ExplainedTask{
+			ID:        short(t.ID),
+			Op:        t.Op,
+			Args:      make([]string, 0, len(t.Args)),
+			Name:      t.Name,
+			Unit:      t.Unit,
+			Final:     t.Final,
+			Condition: t.Condition,
+			Ready:     t.Status == "ready",
+			Duration:  s.operationTime(t.Op).Milliseconds(),
+		}
+
+		var deps []string
+		for _, arg := range t.Args {
+			if arg.TaskID == nil {
+				et.Args = append(et.Args, formatArg(arg))
+				continue
+			}
+			et.Args = append(et.Args, short(*arg.TaskID))
+			deps = append(deps, *arg.TaskID)
+		}
+		for _, guard := range t.Guards {
+			cond := guard[:strings.LastIndex(guard, ":")]
+			et.Guards = append(et.Guards, short(cond)+guard[len(cond):])
+			deps = append(deps, cond)
+		}
+
+		// The chain of a task continues the longest chain among its
+		// dependencies, the slowest one on ties.
+		pred[i] = -1
+		for _, dep := range deps {
+			if slices.Contains(et.Dependencies, short(dep)) {
+				continue
+			}
+			et.Dependencies = append(et.Dependencies, short(dep))
+
+			j := index[dep]
+			if pred[i] < 0 || depth[j] > depth[pred[i]] || depth[j] == depth[pred[i]] && finish[j] > finish[pred[i]] {
+				pred[i] = j
+			}
+			et.Start = max(et.Start, finish[j])
+		}
+		if et.Dependencies == nil {
+			et.Dependencies = []string{}
+		}
+
+		depth[i] = 1
+		if pred[i] >= 0 {
+			depth[i] += depth[pred[i]]
+		}
+		finish[i] = et.Start + et.Duration
+		index[t.ID] = i
+
+		if last < 0 || depth[i] > depth[last] || depth[i] == depth[last] && finish[i] > finish[last] {
+			last = i
+		}
+		explanation.Duration = max(explanation.Duration, finish[i])
+		explanation.Tasks = append(explanation.Tasks, et)
+	}
+
+	for i := last; i >= 0; i = pred[i] {
+		explanation.Tasks[i].Critical = true
+	}
+
+	return explanation, nil
+}
+
+// formatArg formats a literal argument of a task.
+func formatArg(arg models.Arg) string {
+	n := arg.Number
+	switch {
+	case n == nil:
+		return strconv.FormatFloat(arg.Value, 'g', -1, 64)
+	case n.Rational != nil:
+		return n.Rational.Num + "/" + n.Rational.Den
+	case n.Complex != nil:
+		return strconv.FormatComplex(complex(n.Complex.Re, n.Complex.Im), 'g', -1, 128)
+	case n.Integer != "":
+		return n.Integer
+	}
+
+	return n.Decimal
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/config"
+	e "github.com/distributed-calc/v1/internal/orchestrator/errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"github.com/distributed-calc/v1/test/mock"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestService_Explain(t *testing.T) {
+	cfg := &config.Config{
+		AdditionTime:       2 * time.Millisecond,
+		MultiplicationTime: 5 * time.Millisecond,
+		LogicTime:          time.Millisecond,
+	}
+
+	cases := []struct {
+		name         string
+		exp          string
+		ops          []string
+		dependencies [][]string
+		critical     []string
+		criticalPath int
+		duration     int64
+	}{
+		{
+			name:         "arithmetic",
+			exp:          "(1 + 2) * (3 + 4)",
+			ops:          []string{"+", "+", "*"},
+			dependencies: [][]string{{}, {}, {"t0", "t1"}},
+			critical:     []string{"t0", "t2"},
+			criticalPath: 2,
+			duration:     7,
+		},
+		{
+			name:         "shared operand",
+			exp:          "a = 2 * 3; a * a",
+			ops:          []string{"*", "*"},
+			dependencies: [][]string{{}, {"t0"}},
+			critical:     []string{"t0", "t1"},
+			criticalPath: 2,
+			duration:     10,
+		},
+		{
+			name:         "branches depend on the condition",
+			exp:          "a = 2 * 3; if(a > 5, a + 1, a * 2)",
+			ops:          []string{"*", ">", "+", "*", "if"},
+			dependencies: [][]string{{}, {"t0"}, {"t0", "t1"}, {"t0", "t1"}, {"t1", "t2", "t3"}},
+			critical:     []string{"t0", "t1", "t3", "t4"},
+			criticalPath: 4,
+			duration:     11,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := mock.NewRepository()
+			s := NewService(cfg, repo, repo, nil, repo, nil, nil)
+
+			explanation, err := s.Explain(context.Background(), &models.CalculateRequest{Expression: tc.exp}, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(explanation.Tasks) != len(tc.ops) {
+				t.Fatalf("expected %d tasks, got %d", len(tc.ops), len(explanation.Tasks))
+			}
+
+			var critical []string
+			for i, task := range explanation.Tasks {
+				if task.Op != tc.ops[i] {
+					t.Errorf("expected op %q of task %s, got %q", tc.ops[i], task.ID, task.Op)
+				}
+				if !slices.Equal(task.Dependencies, tc.dependencies[i]) {
+					t.Errorf("expected dependencies %v of task %s, got %v", tc.dependencies[i], task.ID, task.Dependencies)
+				}
+				if task.Critical {
+					critical = append(critical, task.ID)
+				}
+			}
+
+			if !slices.Equal(critical, tc.critical) {
+				t.Errorf("expected critical tasks %v, got %v", tc.critical, critical)
+			}
+
+			if explanation.CriticalPath != tc.criticalPath || explanation.Duration != tc.duration {
+				t.Errorf("expected critical path %d and duration %dms, got %d and %dms", tc.criticalPath, tc.duration, explanation.CriticalPath, explanation.Duration)
+			}
+
+			if !explanation.Tasks[len(explanation.Tasks)-1].Final {
+				t.Error("expected the last task to be final")
+			}
+
+			_, err = repo.GetAll(context.Background(), "user", "", 10)
+			if !errors.Is(err, e.ErrNoExpressions) {
+				t.Errorf("expected nothing to be persisted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestService_Explain_Invalid(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+	_, err := s.Explain(context.Background(), &models.CalculateRequest{Expression: "1 +"}, "user")
+	if !errors.Is(err, e.ErrInvalidExpression) {
+		t.Errorf("expected error %v, got %v", e.ErrInvalidExpression, err)
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	e "github.com/distributed-calc/v1/internal/orchestrator/errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
+)
+
+// fail marks the expression of the failed task as failed, deletes the rest
+// of its tasks, none of them can contribute to a result anymore, and tells
+// agents computing them to abort. The failed result is not passed to the
+// tasks waiting for it.
+func (s *Service) fail(ctx context.Context, result *models.TaskResult) error {
+	task, err := s.taskRepo.GetTask(ctx, result.Id)
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, e.ErrNoTasks) {
+		// The expression has already failed, or the task was computed by
+		// another agent after its lease expired.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	running, err := s.taskRepo.RunningTasks(ctx, task.ExpID)
+	if err != nil {
+		return err
+	}
+
+	reason := result.Error
+	if reason == "" {
+		reason = "evaluation failed"
+	}
+
+	err = s.expRepo.Update(ctx, &models.Expression{
+		Id:               task.ExpID,
+		Status:           StatusFailed,
+		Error:            reason,
+		FailedExpression: task.Source,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.taskRepo.DeleteTasks(ctx, task.ExpID)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range running {
+		if t.ID != task.ID {
+			s.cancels.send(t.ClaimedBy, t.ID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"errors"
+	agentmodels "github.com/distributed-calc/v1/internal/agent/models"
+	agent "github.com/distributed-calc/v1/internal/agent/service"
+	"github.com/distributed-calc/v1/internal/orchestrator/config"
+	e "github.com/distributed-calc/v1/internal/orchestrator/errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"github.com/distributed-calc/v1/test/mock"
+	"testing"
+)
+
+func TestService_FinishTask_Failure(t *testing.T) {
+	cases := []struct {
+		name   string
+		exp    string
+		reason string
+		failed string
+	}{
+		{
+			name:   "division by zero",
+			exp:    "(1 + 2) * (3 / 0)",
+			reason: "division by zero",
+			failed: "3 / 0",
+		},
+		{
+			name:   "function",
+			exp:    "1 + sqrt(2 - 6)",
+			reason: "result is undefined: square root of negative number -4",
+			failed: "sqrt(2 - 6)",
+		},
+		{
+			name:   "rebalanced chain",
+			exp:    "2 * (8 / 2 / (1 - 1))",
+			reason: "division by zero",
+			failed: "8 / 2 / (1 - 1)",
+		},
+		{
+			name:   "assignment",
+			exp:    "a = 1 // (2 - 2); a + 1",
+			reason: "division by zero",
+			failed: "1 // (2 - 2)",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := mock.NewRepository()
+			s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+			ctx := context.Background()
+
+			optimize := false
+			id, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: tc.exp, Optimize: &optimize}, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			failures := runFailingTasks(t, s)
+			if failures != 1 {
+				t.Errorf("expected 1 failed task, got %d", failures)
+			}
+
+			exp, err := s.Get(ctx, id, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if exp.Status != StatusFailed || exp.Error != tc.reason || exp.FailedExpression != tc.failed {
+				t.Errorf("expected expression failed with %q at %q, got %s with %q at %q", tc.reason, tc.failed, exp.Status, exp.Error, exp.FailedExpression)
+			}
+		})
+	}
+}
+
+func TestService_FinishTask_AfterFailure(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+	ctx := context.Background()
+
+	id, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: "(1 / 0) + (2 * 3)"}, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cancelled, unsubscribe := s.Cancellations("agent")
+	defer unsubscribe()
+
+	var claimed []*models.AgentTask
+	for {
+		task, err := s.GetTask(ctx, "agent")
+		if errors.Is(err, e.ErrNoTasks) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		claimed = append(claimed, task)
+	}
+
+	var failed, late *models.AgentTask
+	for _, task := range claimed {
+		switch task.Op {
+		case "/":
+			failed = task
+		case "*":
+			late = task
+		}
+	}
+	if failed == nil || late == nil {
+		t.Fatalf("expected both operands to be ready, got %v", claimed)
+	}
+
+	for range 2 {
+		// The failure may be reported again by an agent which got the task
+		// after its lease expired.
+		err = s.FinishTask(ctx, &models.TaskResult{Id: failed.Id, Status: taskFailure, Error: "division by zero"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	_, err = s.GetTask(ctx, "agent")
+	if !errors.Is(err, e.ErrNoTasks) {
+		t.Errorf("expected remaining tasks to be deleted, got %v", err)
+	}
+
+	select {
+	case got := <-cancelled:
+		if got != late.Id {
+			t.Errorf("expected cancellation of task %s, got %s", late.Id, got)
+		}
+	default:
+		t.Error("expected the agent to be told to abort its running task")
+	}
+
+	select {
+	case got := <-cancelled:
+		t.Errorf("expected only one cancellation, got another of task %s", got)
+	default:
+	}
+
+	// Results of tasks computed meanwhile change nothing.
+	err = s.FinishTask(ctx, &models.TaskResult{Id: late.Id, Result: 6, Status: StatusCompleted, Final: late.Final})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exp, err := s.Get(ctx, id, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp.Status != StatusFailed || exp.Error != "division by zero" || exp.FailedExpression != "1 / 0" {
+		t.Errorf("expected expression to stay failed, got %s with %q at %q", exp.Status, exp.Error, exp.FailedExpression)
+	}
+}
+
+// runFailingTasks computes float tasks with the agent until none is left,
+// reporting failures like agents do, and returns the number of failures.
+func runFailingTasks(t *testing.T, s *Service) int {
+	t.Helper()
+
+	ctx := context.Background()
+	calc := agent.NewService()
+
+	var failures int
+	for {
+		task, err := s.GetTask(ctx, "agent")
+		if errors.Is(err, e.ErrNoTasks) {
+			return failures
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		res, err := calc.Evaluate(context.Background(), &agentmodels.AgentTask{Id: task.Id, Args: task.Args, Op: task.Op, Final: task.Final})
+		if err != nil {
+			failures++
+		}
+
+		err = s.FinishTask(ctx, &models.TaskResult{Id: res.Id, Result: res.Result, Status: res.Status, Final: res.Final, Error: res.Error})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"context"
+	"time"
+)
+
+// Reasons tasks are taken back from agents.
+const (
+	reasonLeaseExpired = "lease expired"
+	reasonReleased     = "agent stream closed"
+)
+
+// RequeueExpired takes back tasks whose lease has ended, so that tasks
+// claimed by agents which died or lost connection are computed by others.
+// It reports the number of tasks taken back.
+func (s *Service) RequeueExpired(ctx context.Context) (int64, error) {
+	tasks, err := s.taskRepo.ExpiredTasks(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return s.retry(ctx, tasks, reasonLeaseExpired)
+}
+
+// ReleaseTasks takes back tasks claimed by a closed agent stream.
+func (s *Service) ReleaseTasks(ctx context.Context, claimant string) (int64, error) {
+	tasks, err := s.taskRepo.ClaimedTasks(ctx, claimant)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.retry(ctx, tasks, reasonReleased)
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/config"
+	e "github.com/distributed-calc/v1/internal/orchestrator/errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"github.com/distributed-calc/v1/test/mock"
+	"testing"
+	"time"
+)
+
+func TestService_RequeueExpired(t *testing.T) {
+	cases := []struct {
+		name     string
+		lease    time.Duration
+		requeued int64
+	}{
+		{
+			name:     "active lease",
+			lease:    time.Minute,
+			requeued: 0,
+		},
+		{
+			name:     "expired lease",
+			lease:    time.Millisecond,
+			requeued: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := mock.NewRepository()
+			s := NewService(&config.Config{LeaseDuration: tc.lease, MaxRetries: 3}, repo, repo, nil, repo, nil, nil)
+
+			ctx := context.Background()
+
+			optimize := false
+			_, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: "(1 + 2) * (3 + 4)", Optimize: &optimize}, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var ids []string
+			for range 2 {
+				task, err := s.GetTask(ctx, "agent")
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				ids = append(ids, task.Id)
+			}
+
+			time.Sleep(5 * time.Millisecond)
+
+			n, err := s.RequeueExpired(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n != tc.requeued {
+				t.Errorf("expected %d requeued tasks, got %d", tc.requeued, n)
+			}
+
+			for _, id := range ids {
+				task := repo.Task(id)
+				if tc.requeued > 0 && (task.Status != "ready" || task.ClaimedBy != "") {
+					t.Errorf("expected task %s to be ready, got %s by %q", id, task.Status, task.ClaimedBy)
+				}
+				if tc.requeued == 0 && task.Status != "in_progress" {
+					t.Errorf("expected task %s in progress, got %s", id, task.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestService_ReleaseTasks(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(&config.Config{LeaseDuration: time.Minute, MaxRetries: 3}, repo, repo, nil, repo, nil, nil)
+
+	ctx := context.Background()
+
+	optimize := false
+	_, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: "(1 + 2) * (3 + 4)", Optimize: &optimize}, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	closed, err := s.GetTask(ctx, "closed")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	open, err := s.GetTask(ctx, "open")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n, err := s.ReleaseTasks(ctx, "closed")
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 released task, got %d, %v", n, err)
+	}
+
+	if task := repo.Task(closed.Id); task.Status != "ready" {
+		t.Errorf("expected released task to be ready, got %s", task.Status)
+	}
+
+	if task := repo.Task(open.Id); task.Status != "in_progress" || task.ClaimedBy != "open" {
+		t.Errorf("expected task of the open stream to stay claimed, got %s by %q", task.Status, task.ClaimedBy)
+	}
+
+	for {
+		task, err := s.GetTask(ctx, "open")
+		if errors.Is(err, e.ErrNoTasks) {
+			t.Fatalf("expected released task %s to be claimed again", closed.Id)
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if task.Id == closed.Id {
+			break
+		}
+	}
+}
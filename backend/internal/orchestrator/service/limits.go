@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	e "github.com/distributed-calc/v1/internal/orchestrator/errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"github.com/distributed-calc/v1/internal/orchestrator/parser"
+)
+
+// checkLength rejects expressions longer than the configured limit before
+// they are tokenized.
+func (s *Service) checkLength(expression string) error {
+	if s.cfg.MaxExpressionLength > 0 && len(expression) > s.cfg.MaxExpressionLength {
+		return fmt.Errorf("%w: %d bytes, at most %d are allowed", e.ErrExpressionTooLarge, len(expression), s.cfg.MaxExpressionLength)
+	}
+
+	return nil
+}
+
+// checkNodes rejects scripts with more syntax tree nodes than the configured
+// limit.
+func (s *Service) checkNodes(script *parser.Script) error {
+	if s.cfg.MaxNodes <= 0 {
+		return nil
+	}
+
+	var nodes int
+	parser.Inspect(script, func(parser.Node) bool {
+		nodes++
+		return true
+	})
+
+	if nodes > s.cfg.MaxNodes {
+		return fmt.Errorf("%w: %d nodes, at most %d are allowed", e.ErrExpressionTooComplex, nodes, s.cfg.MaxNodes)
+	}
+
+	return nil
+}
+
+// checkTasks rejects expressions which need more tasks than the configured
+// limit after optimization.
+func (s *Service) checkTasks(tasks []*models.Task) error {
+	if s.cfg.MaxTasks > 0 && len(tasks) > s.cfg.MaxTasks {
+		return fmt.Errorf("%w: %d tasks, at most %d are allowed", e.ErrExpressionTooComplex, len(tasks), s.cfg.MaxTasks)
+	}
+
+	return nil
+}
+
+// admit rejects a new expression of a user who has as many pending
+// expressions as the configured limit. Concurrent submissions may exceed the
+// limit slightly, since the count is not locked until the expression is added.
+func (s *Service) admit(ctx context.Context, userID string) error {
+	if s.cfg.MaxPendingExpressions <= 0 {
+		return nil
+	}
+
+	pending, err := s.expRepo.CountPending(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to count pending expressions: %w", err)
+	}
+
+	if pending >= int64(s.cfg.MaxPendingExpressions) {
+		return fmt.Errorf("%w: %d expressions are being evaluated", e.ErrTooManyExpressions, pending)
+	}
+
+	return nil
+}
+
+// syntaxError wraps a parser error with the error of the service it stands for.
+func syntaxError(err error) error {
+	if errors.Is(err, parser.ErrTooDeep) {
+		return fmt.Errorf("%w: %w", e.ErrExpressionTooComplex, err)
+	}
+
+	return fmt.Errorf("%w: %w", e.ErrInvalidExpression, err)
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/config"
+	e "github.com/distributed-calc/v1/internal/orchestrator/errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"github.com/distributed-calc/v1/test/mock"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestService_Evaluate_Limits(t *testing.T) {
+	cfg := &config.Config{
+		MaxExpressionLength: 64,
+		MaxNodes:            20,
+		MaxDepth:            5,
+		MaxTasks:            4,
+	}
+
+	cases := []struct {
+		name    string
+		exp     string
+		wantErr error
+	}{
+		{
+			name: "within limits",
+			exp:  "(1 + 2) * (3 + 4)",
+		},
+		{
+			name:    "too long",
+			exp:     strings.Repeat("1 + ", 20) + "1",
+			wantErr: e.ErrExpressionTooLarge,
+		},
+		{
+			name:    "too many nodes",
+			exp:     "1+2+3+4+5+6+7+8+9+10+11",
+			wantErr: e.ErrExpressionTooComplex,
+		},
+		{
+			name:    "nested too deeply",
+			exp:     "((((((1))))))",
+			wantErr: e.ErrExpressionTooComplex,
+		},
+		{
+			name:    "too many tasks",
+			exp:     "(1 + 2) * (3 + 4) * (5 + 6)",
+			wantErr: e.ErrExpressionTooComplex,
+		},
+		{
+			name:    "syntax error",
+			exp:     "1 +",
+			wantErr: e.ErrInvalidExpression,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := mock.NewRepository()
+			s := NewService(cfg, repo, repo, nil, repo, nil, nil)
+
+			_, err := s.Evaluate(context.Background(), &models.CalculateRequest{Expression: tc.exp}, "user")
+			if tc.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestService_Evaluate_DefaultLimits(t *testing.T) {
+	cfg, err := config.NewConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	values := make([]string, 5000)
+	for i := range values {
+		values[i] = strconv.Itoa(i)
+	}
+
+	for _, fn := range []string{"sum", "avg", "stddev"} {
+		t.Run(fn, func(t *testing.T) {
+			repo := mock.NewRepository()
+			s := NewService(cfg, repo, repo, nil, repo, nil, nil)
+
+			exp := fn + "([" + strings.Join(values, ", ") + "])"
+
+			_, err := s.Evaluate(context.Background(), &models.CalculateRequest{Expression: exp}, "user")
+			if err != nil {
+				t.Errorf("expected %s of %d values to be admitted, got %v", fn, len(values), err)
+			}
+		})
+	}
+}
+
+func TestService_Evaluate_PendingLimit(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(&config.Config{MaxPendingExpressions: 2}, repo, repo, nil, repo, nil, nil)
+
+	ctx := context.Background()
+
+	for range 2 {
+		_, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: "1 + 2"}, "user")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	_, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: "1 + 2"}, "user")
+	if !errors.Is(err, e.ErrTooManyExpressions) {
+		t.Fatalf("expected error %v, got %v", e.ErrTooManyExpressions, err)
+	}
+
+	_, err = s.Evaluate(ctx, &models.CalculateRequest{Expression: "1 + 2"}, "other")
+	if err != nil {
+		t.Fatalf("expected other users to be admitted, got %v", err)
+	}
+
+	runTasks(t, s)
+
+	_, err = s.Evaluate(ctx, &models.CalculateRequest{Expression: "1 + 2"}, "user")
+	if err != nil {
+		t.Errorf("expected to be admitted after evaluation, got %v", err)
+	}
+}
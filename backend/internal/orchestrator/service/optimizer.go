@@ -0,0 +1,232 @@
+package service
+
+import (
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// totalOps are operations which cannot fail in exact modes, so their tasks
+// may be dropped when the result is multiplied by zero.
+var totalOps = map[string]bool{
+	"":      true,
+	"+":     true,
+	"-":     true,
+	"*":     true,
+	opNeg:   true,
+	"abs":   true,
+	"min":   true,
+	"max":   true,
+	"floor": true,
+	"ceil":  true,
+	"<":     true,
+	"<=":    true,
+	">":     true,
+	">=":    true,
+	"==":    true,
+	"!=":    true,
+	"and":   true,
+	"or":    true,
+	"not":   true,
+	"&":     true,
+	"|":     true,
+	"xor":   true,
+	"~":     true,
+}
+
+// optimize removes tasks which do not need a round trip to an agent before
+// they are dispatched. Literal leaf tasks are inlined into arguments of their
+// consumers and operations with an identity operand, x+0, x-0, x*1, x/1 and
+// x^1, are replaced by x. x*0 is replaced by 0 only in exact modes, where
+// there are no NaNs and infinities, and only if x cannot fail. Tasks which
+// are not needed anymore are deleted.
+//
+// Tasks must be in dependency order, as built by buildTasks. Tasks with a
+// name, the final task and conditions of 'if' are kept, though their
+// literal results are inlined as well, except into the 'if' itself: the
+// branch is chosen once its condition task is finished.
+func optimize(tasks []*models.Task, exp *models.Expression) []*models.Task {
+	byID := make(map[string]*models.Task, len(tasks))
+	consumers := make(map[string]int, len(tasks))
+	for _, t := range tasks {
+		byID[t.ID] = t
+		for _, arg := range t.Args {
+			if arg.TaskID != nil {
+				consumers[*arg.TaskID]++
+			}
+		}
+	}
+
+	// replaced holds arguments standing for results of tasks, literals of
+	// inlined tasks or operands of simplified ones.
+	replaced := make(map[string]models.Arg)
+	total := make(map[string]bool, len(tasks))
+	removed := make(map[string]bool)
+
+	for _, t := range tasks {
+		for i, arg := range t.Args {
+			if arg.TaskID == nil || t.Op == opIf && i == 0 {
+				continue
+			}
+			if r, ok := replaced[*arg.TaskID]; ok {
+				consumers[*arg.TaskID]--
+				if r.TaskID != nil {
+					consumers[*r.TaskID]++
+				}
+				t.Args[i] = r
+			}
+		}
+
+		total[t.ID] = totalOps[t.Op]
+		for _, arg := range t.Args {
+			if arg.TaskID != nil {
+				total[t.ID] = total[t.ID] && total[*arg.TaskID]
+			}
+		}
+
+		if r, ok := simplify(t, exp.Mode, total); ok {
+			release := func() {
+				for _, arg := range t.Args {
+					if arg.TaskID != nil {
+						consumers[*arg.TaskID]--
+					}
+				}
+			}
+
+			if r.TaskID == nil {
+				release()
+				t.Op, t.Args = "", []models.Arg{r}
+			} else if removable(t) {
+				release()
+				replaced[t.ID] = r
+				removed[t.ID] = true
+				continue
+			} else if x := byID[*r.TaskID]; !t.Condition && consumers[x.ID] == 1 && removable(x) && slices.Equal(x.Guards, t.Guards) {
+				// t is the only consumer of x, so x takes its place. Guards
+				// refer to IDs of conditions, so they keep their tasks.
+				release()
+				x.Name, x.Final = t.Name, t.Final
+				if t.Unit != "" {
+					x.Unit = t.Unit
+				}
+				replaced[t.ID] = r
+				removed[t.ID] = true
+				continue
+			}
+		}
+
+		if t.Op == "" && len(t.Args) == 1 && t.Args[0].TaskID == nil && inlinable(t.Args[0], exp) {
+			replaced[t.ID] = t.Args[0]
+			if removable(t) {
+				removed[t.ID] = true
+				continue
+			}
+		}
+
+		if dependencies(t) == 0 && len(t.Guards) == 0 {
+			t.Status = "ready"
+		}
+	}
+
+	// Operands of x*0 may be left without consumers.
+	live := make(map[string]bool, len(tasks))
+	for i := len(tasks) - 1; i >= 0; i-- {
+		t := tasks[i]
+		if removed[t.ID] || (!live[t.ID] && removable(t)) {
+			continue
+		}
+
+		live[t.ID] = true
+		for _, arg := range t.Args {
+			if arg.TaskID != nil {
+				live[*arg.TaskID] = true
+			}
+		}
+	}
+
+	return slices.DeleteFunc(tasks, func(t *models.Task) bool { return !live[t.ID] })
+}
+
+// removable reports whether the result of t is used only by its consumers.
+func removable(t *models.Task) bool {
+	return t.Name == "" && !t.Final && !t.Condition
+}
+
+// inlinable reports whether a literal may be passed to consumers instead of
+// the result of its leaf task. Agents round leaf results in the decimal
+// mode, so literals with more digits than the scale stay in tasks.
+func inlinable(arg models.Arg, exp *models.Expression) bool {
+	if exp.Mode != ModeDecimal || arg.Number == nil || exp.Precision == nil {
+		return true
+	}
+
+	_, frac, _ := strings.Cut(arg.Number.Decimal, ".")
+	return len(frac) <= int(exp.Precision.Scale)
+}
+
+// simplify reports the argument t may be replaced with if one of its
+// operands is an identity of the operation.
+func simplify(t *models.Task, mode string, total map[string]bool) (models.Arg, bool) {
+	if len(t.Args) != 2 {
+		return models.Arg{}, false
+	}
+
+	x, y := t.Args[0], t.Args[1]
+	switch t.Op {
+	case "+":
+		if isConstant(y, 0) {
+			return x, true
+		}
+		if isConstant(x, 0) {
+			return y, true
+		}
+	case "-":
+		if isConstant(y, 0) {
+			return x, true
+		}
+	case "*":
+		if isConstant(y, 1) {
+			return x, true
+		}
+		if isConstant(x, 1) {
+			return y, true
+		}
+		if mode == ModeDecimal || mode == ModeRational || mode == ModeInteger {
+			if isConstant(y, 0) && (x.TaskID == nil || total[*x.TaskID]) {
+				return y, true
+			}
+			if isConstant(x, 0) && (y.TaskID == nil || total[*y.TaskID]) {
+				return x, true
+			}
+		}
+	case "/", "^":
+		if isConstant(y, 1) {
+			return x, true
+		}
+	}
+
+	return models.Arg{}, false
+}
+
+// isConstant reports whether arg is a literal equal to v.
+func isConstant(arg models.Arg, v int64) bool {
+	if arg.TaskID != nil {
+		return false
+	}
+
+	n := arg.Number
+	switch {
+	case n == nil:
+		return arg.Value == float64(v)
+	case n.Complex != nil:
+		return n.Complex.Re == float64(v) && n.Complex.Im == 0
+	case n.Rational != nil:
+		return n.Rational.Num == big.NewInt(v).String() && n.Rational.Den == "1"
+	case n.Integer != "":
+		return n.Integer == big.NewInt(v).String()
+	}
+
+	x, ok := new(big.Rat).SetString(n.Decimal)
+	return ok && x.Cmp(big.NewRat(v, 1)) == 0
+}
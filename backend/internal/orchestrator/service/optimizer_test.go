@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"github.com/distributed-calc/v1/internal/orchestrator/config"
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"github.com/distributed-calc/v1/internal/orchestrator/parser"
+	"github.com/distributed-calc/v1/test/mock"
+	"math"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	cases := []struct {
+		name  string
+		exp   string
+		mode  string
+		tasks int
+		ready int
+	}{
+		{
+			name:  "literals are inlined",
+			exp:   "2 + 3 * 4",
+			tasks: 2,
+			ready: 1,
+		},
+		{
+			name:  "identities",
+			exp:   "(2 + 3) * 1 + 0 - 0",
+			tasks: 1,
+			ready: 1,
+		},
+		{
+			name:  "multiplication by zero is kept in float mode",
+			exp:   "(2 + 3) * 0",
+			tasks: 2,
+			ready: 1,
+		},
+		{
+			name:  "multiplication by zero in exact mode",
+			exp:   "(2 + 3) * 0",
+			mode:  ModeInteger,
+			tasks: 1,
+			ready: 1,
+		},
+		{
+			name:  "failing operand of zero product is kept",
+			exp:   "(7 // 0) * 0",
+			mode:  ModeInteger,
+			tasks: 2,
+			ready: 1,
+		},
+		{
+			name:  "named literal is kept",
+			exp:   "a = 5; a * 2",
+			tasks: 2,
+			ready: 2,
+		},
+		{
+			name:  "condition is kept",
+			exp:   "if(1 > 0, 1, 2)",
+			tasks: 2,
+			ready: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			script, err := validate(tc.exp, parser.Options{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			tasks := optimize(buildTasks(script, &models.Expression{Id: "opt", Mode: tc.mode}, nil), &models.Expression{Mode: tc.mode})
+			if len(tasks) != tc.tasks {
+				t.Fatalf("expected %d tasks, got %d", tc.tasks, len(tasks))
+			}
+
+			var ready int
+			for _, task := range tasks {
+				if task.Status == "ready" {
+					ready++
+				}
+			}
+			if ready != tc.ready {
+				t.Errorf("expected %d ready tasks, got %d", tc.ready, ready)
+			}
+
+			if !tasks[len(tasks)-1].Final {
+				t.Error("expected the last task to be final")
+			}
+		})
+	}
+}
+
+func TestService_Evaluate_Optimize(t *testing.T) {
+	cases := []struct {
+		name       string
+		exp        string
+		mode       string
+		precision  *models.Precision
+		expected   float64
+		unit       string
+		dispatched int
+	}{
+		{
+			name:       "arithmetic",
+			exp:        "(1 + 2) * (3 + 4) * 1",
+			expected:   21,
+			dispatched: 3,
+		},
+		{
+			name:       "variable",
+			exp:        "x * 1 + 0",
+			expected:   2,
+			dispatched: 1,
+		},
+		{
+			name:       "conversion to base unit",
+			exp:        "1 km to m",
+			expected:   1000,
+			unit:       "m",
+			dispatched: 1,
+		},
+		{
+			name:       "branches",
+			exp:        "if(x > 0, 1, 2 + 3)",
+			expected:   1,
+			dispatched: 2,
+		},
+		{
+			name:       "aggregation",
+			exp:        "sum([1, 2, 3, 4, 5])",
+			expected:   15,
+			dispatched: 4,
+		},
+		{
+			name:       "decimal literals rounded by agents",
+			exp:        "1.234 + 1.234",
+			mode:       ModeDecimal,
+			precision:  &models.Precision{Scale: 2},
+			expected:   2.46,
+			dispatched: 3,
+		},
+		{
+			name:       "rational",
+			exp:        "(1/3 + 0) * 3",
+			mode:       ModeRational,
+			expected:   1,
+			dispatched: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, optimize := range []bool{true, false} {
+				repo := mock.NewRepository()
+				s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+				ctx := context.Background()
+
+				err := s.AddVariable(ctx, "user", &models.Variable{Name: "x", Value: 2})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				req := &models.CalculateRequest{Expression: tc.exp, Mode: tc.mode, Precision: tc.precision, Optimize: &optimize}
+				id, err := s.Evaluate(ctx, req, "user")
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				dispatched := runTasks(t, s)
+
+				exp, err := s.Get(ctx, id, "user")
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if exp.Status != StatusCompleted || math.Abs(exp.Result-tc.expected) > 1e-9 || exp.Unit != tc.unit {
+					t.Errorf("optimize %v: expected %v %s, got %v %s (%s)", optimize, tc.expected, tc.unit, exp.Result, exp.Unit, exp.Status)
+				}
+
+				if optimize && dispatched != tc.dispatched {
+					t.Errorf("expected %d dispatched tasks, got %d", tc.dispatched, dispatched)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	e "github.com/distributed-calc/v1/internal/orchestrator/errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"slices"
+	"time"
+)
+
+// retry returns tasks lost by agents to ready once their backoff passes, or
+// moves them to the dead letters when they have no retries left, which
+// stalls their expressions. Failures reported by agents, like division by
+// zero, are deterministic and never retried, see fail.
+func (s *Service) retry(ctx context.Context, tasks []*models.Task, reason string) (int64, error) {
+	now := time.Now()
+
+	var n int64
+	for _, t := range tasks {
+		if t.Retries >= s.cfg.RetryLimit(t.Op) {
+			err := s.taskRepo.AddDeadLetter(ctx, &models.DeadLetter{
+				ID:       t.ID,
+				ExpID:    t.ExpID,
+				Op:       t.Op,
+				Retries:  t.Retries,
+				Reason:   reason,
+				FailedAt: now,
+				Task:     t,
+			})
+			if err != nil {
+				return n, err
+			}
+
+			n++
+			continue
+		}
+
+		t.Retries++
+		t.RetryAt = now.Add(s.retryDelay(t.Retries))
+
+		err := s.taskRepo.RequeueTask(ctx, t)
+		if err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
+
+// retryDelay is the backoff before the given retry, it doubles on every
+// retry up to the configured maximum.
+func (s *Service) retryDelay(retry int) time.Duration {
+	d := s.cfg.RetryBackoff
+	for i := 1; i < retry && d < s.cfg.RetryBackoffMax; i++ {
+		d *= 2
+	}
+
+	return min(d, s.cfg.RetryBackoffMax)
+}
+
+// GetDeadLetters lists tasks which exhausted their retries, only admins may
+// inspect them.
+func (s *Service) GetDeadLetters(ctx context.Context, userID string) ([]*models.DeadLetter, error) {
+	err := s.checkAdmin(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.taskRepo.GetDeadLetters(ctx)
+}
+
+// RequeueDeadLetter returns the task of the dead letter to ready with its
+// retries reset.
+func (s *Service) RequeueDeadLetter(ctx context.Context, userID, id string) error {
+	err := s.checkAdmin(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.taskRepo.RequeueDeadLetter(ctx, id)
+}
+
+func (s *Service) checkAdmin(ctx context.Context, userID string) error {
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if !slices.Contains(s.cfg.Admins, user.Username) {
+		return fmt.Errorf("%w: %s is not an admin", e.ErrForbidden, user.Username)
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/config"
+	e "github.com/distributed-calc/v1/internal/orchestrator/errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"github.com/distributed-calc/v1/test/mock"
+	"testing"
+	"time"
+)
+
+func TestService_RetryDelay(t *testing.T) {
+	s := NewService(&config.Config{RetryBackoff: time.Second, RetryBackoffMax: 5 * time.Second}, nil, nil, nil, nil, nil, nil)
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, d := range expected {
+		if got := s.retryDelay(i + 1); got != d {
+			t.Errorf("expected delay %v before retry %d, got %v", d, i+1, got)
+		}
+	}
+}
+
+func TestService_ReleaseTasks_Retries(t *testing.T) {
+	cfg := &config.Config{
+		LeaseDuration:   time.Minute,
+		MaxRetries:      2,
+		OpMaxRetries:    map[string]int{"*": 0},
+		RetryBackoff:    time.Millisecond,
+		RetryBackoffMax: time.Millisecond,
+	}
+
+	repo := mock.NewRepository()
+	s := NewService(cfg, repo, repo, repo, repo, nil, nil)
+
+	ctx := context.Background()
+
+	id, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: "(1 + 2) - (3 * 4)"}, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The sum may be retried twice, the product is not retried at all.
+	var sum, product string
+	for attempt := range 3 {
+		for {
+			task, err := s.GetTask(ctx, "agent")
+			if errors.Is(err, e.ErrNoTasks) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			switch task.Op {
+			case "+":
+				sum = task.Id
+			case "*":
+				product = task.Id
+			}
+		}
+
+		_, err = s.ReleaseTasks(ctx, "agent")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if attempt < 2 {
+			task := repo.Task(sum)
+			if task == nil || task.Status != "ready" || task.Retries != attempt+1 || task.RetryAt.IsZero() {
+				t.Fatalf("expected the sum to be retried %d times, got %+v", attempt+1, task)
+			}
+		}
+
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	if repo.Task(sum) != nil || repo.Task(product) != nil {
+		t.Error("expected tasks which exhausted their retries to be removed")
+	}
+
+	// Stalled expressions do not count towards the pending limit.
+	if exp, _ := repo.Get(ctx, id); exp.Status != StatusStalled {
+		t.Errorf("expected status %s, got %s", StatusStalled, exp.Status)
+	}
+	if n, _ := repo.CountPending(ctx, "user"); n != 0 {
+		t.Errorf("expected no pending expressions, got %d", n)
+	}
+
+	admin := &models.User{Id: "admin", Username: "root"}
+	_ = repo.AddUser(ctx, admin)
+	_ = repo.AddUser(ctx, &models.User{Id: "user", Username: "user"})
+
+	_, err = s.GetDeadLetters(ctx, "user")
+	if !errors.Is(err, e.ErrForbidden) {
+		t.Errorf("expected error %v for a user, got %v", e.ErrForbidden, err)
+	}
+
+	cfg.Admins = []string{admin.Username}
+
+	dls, err := s.GetDeadLetters(ctx, admin.Id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	retries := make(map[string]int)
+	for _, dl := range dls {
+		retries[dl.ID] = dl.Retries
+		if dl.Reason != reasonReleased {
+			t.Errorf("expected reason %q, got %q", reasonReleased, dl.Reason)
+		}
+	}
+	if len(dls) != 2 || retries[sum] != 2 || retries[product] != 0 {
+		t.Errorf("expected the sum retried twice and the product in dead letters, got %v", retries)
+	}
+
+	err = s.RequeueDeadLetter(ctx, admin.Id, sum)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	task, err := s.GetTask(ctx, "agent")
+	if err != nil || task.Id != sum {
+		t.Errorf("expected requeued sum to be claimed, got %v, %v", task, err)
+	}
+
+	err = s.RequeueDeadLetter(ctx, admin.Id, sum)
+	if !errors.Is(err, e.ErrNoTasks) {
+		t.Errorf("expected error %v, got %v", e.ErrNoTasks, err)
+	}
+
+	// The expression is pending again once none of its tasks are left.
+	if exp, _ := repo.Get(ctx, id); exp.Status != StatusStalled {
+		t.Errorf("expected status %s with the product left, got %s", StatusStalled, exp.Status)
+	}
+
+	err = s.RequeueDeadLetter(ctx, admin.Id, product)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp, _ := repo.Get(ctx, id); exp.Status != StatusPending {
+		t.Errorf("expected status %s, got %s", StatusPending, exp.Status)
+	}
+}
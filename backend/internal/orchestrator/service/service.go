@@ -7,34 +7,235 @@ import (
 	"github.com/distributed-calc/v1/internal/orchestrator/errors"
 	e "github.com/distributed-calc/v1/internal/orchestrator/errors"
 	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"github.com/distributed-calc/v1/internal/orchestrator/parser"
+	"github.com/distributed-calc/v1/internal/orchestrator/units"
 	"github.com/distributed-calc/v1/pkg/authenticator"
 	"github.com/distributed-calc/v1/pkg/middleware"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"go/ast"
-	"go/parser"
 	"golang.org/x/crypto/bcrypt"
+	"maps"
+	"math"
+	"math/big"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
 )
 
 const (
 	StatusPending   = "pending"
 	StatusCompleted = "completed"
 	StatusFailed    = "failed"
-
-	number      = "NUMBER"
-	operator    = "OPERATOR"
-	parenthesis = "PARENTHESIS"
+	StatusCancelled = "cancelled"
+	// StatusStalled is of expressions with a task in the dead letters, they
+	// do not count towards the pending limit until it is requeued.
+	StatusStalled = "stalled"
+
+	// taskFailure is the status of results of tasks agents failed to compute.
+	taskFailure = "failure"
+
+	opNeg = "neg"
+	opIf  = "if"
+	// opCompensatedSum adds many numbers at once with the Kahan-Neumaier
+	// summation, it replaces '+' in reduction trees of compensated sums.
+	opCompensatedSum = "ksum"
+	compensatedFanIn = 16
+
+	ModeFloat    = "float"
+	ModeDecimal  = "decimal"
+	ModeRational = "rational"
+	ModeComplex  = "complex"
+	ModeInteger  = "integer"
+
+	defaultScale    = 20
+	maxScale        = 1000
+	defaultRounding = "half_even"
+	defaultBase     = 10
 )
 
+// decimalCommaLanguages are languages writing numbers with a decimal comma.
+var decimalCommaLanguages = map[string]bool{
+	"bg": true,
+	"cs": true,
+	"da": true,
+	"de": true,
+	"el": true,
+	"es": true,
+	"et": true,
+	"fi": true,
+	"fr": true,
+	"hr": true,
+	"hu": true,
+	"id": true,
+	"it": true,
+	"lt": true,
+	"lv": true,
+	"nb": true,
+	"nl": true,
+	"pl": true,
+	"pt": true,
+	"ro": true,
+	"ru": true,
+	"sk": true,
+	"sl": true,
+	"sr": true,
+	"sv": true,
+	"tr": true,
+	"uk": true,
+}
+
+var localeRegexp = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{2,8})*$`)
+
+var roundingModes = map[string]bool{
+	"half_even": true,
+	"half_up":   true,
+	"half_down": true,
+	"up":        true,
+	"down":      true,
+	"ceiling":   true,
+	"floor":     true,
+}
+
+// modeFunctions are the functions available in exact modes, the float mode
+// supports all of them.
+var modeFunctions = map[string]map[string]bool{
+	ModeDecimal: {
+		"if":      true,
+		"sum":     true,
+		"product": true,
+		"avg":     true,
+		"stddev":  true,
+		"sqrt":    true,
+		"abs":     true,
+		"floor":   true,
+		"ceil":    true,
+		"min":     true,
+		"max":     true,
+		"round":   true,
+	},
+	ModeRational: {
+		"if":      true,
+		"sum":     true,
+		"product": true,
+		"avg":     true,
+		"abs":     true,
+		"floor":   true,
+		"ceil":    true,
+		"min":     true,
+		"max":     true,
+		"round":   true,
+	},
+	ModeComplex: {
+		"sum":     true,
+		"product": true,
+		"avg":     true,
+		"sqrt":    true,
+		"abs":     true,
+		"ln":      true,
+		"log10":   true,
+		"exp":     true,
+		"sin":     true,
+		"cos":     true,
+		"tan":     true,
+		"re":      true,
+		"im":      true,
+		"conj":    true,
+		"arg":     true,
+	},
+	ModeInteger: {
+		"if":      true,
+		"sum":     true,
+		"product": true,
+		"abs":     true,
+		"min":     true,
+		"max":     true,
+	},
+}
+
+// associativeOperators are the operators whose chains are rebalanced in each
+// mode, '-' and '/' are rewritten as a difference of sums and a quotient of
+// products. Results of the decimal mode are rounded after every operation,
+// so only sums are exact in any order.
+var associativeOperators = map[string]map[string]bool{
+	ModeFloat:    {"+": true, "-": true, "*": true, "/": true},
+	ModeComplex:  {"+": true, "-": true, "*": true, "/": true},
+	ModeRational: {"+": true, "-": true, "*": true, "/": true},
+	ModeInteger:  {"+": true, "-": true, "*": true},
+	ModeDecimal:  {"+": true, "-": true},
+}
+
+// inverses are the inverse operators of associative ones.
+var inverses = map[string]string{
+	"+": "-",
+	"-": "+",
+	"*": "/",
+	"/": "*",
+}
+
+var arithmeticOperators = map[string]bool{
+	"+":   true,
+	"-":   true,
+	"*":   true,
+	"/":   true,
+	"//":  true,
+	"%":   true,
+	"^":   true,
+	"<":   true,
+	"<=":  true,
+	">":   true,
+	">=":  true,
+	"==":  true,
+	"!=":  true,
+	"and": true,
+	"or":  true,
+}
+
+// modeOperators are the binary operators available in each mode.
+var modeOperators = map[string]map[string]bool{
+	ModeFloat:    arithmeticOperators,
+	ModeDecimal:  arithmeticOperators,
+	ModeRational: arithmeticOperators,
+	ModeComplex: {
+		"+": true,
+		"-": true,
+		"*": true,
+		"/": true,
+		"^": true,
+	},
+	ModeInteger: {
+		"+":   true,
+		"-":   true,
+		"*":   true,
+		"//":  true,
+		"%":   true,
+		"^":   true,
+		"&":   true,
+		"|":   true,
+		"xor": true,
+		"<<":  true,
+		">>":  true,
+		"<":   true,
+		"<=":  true,
+		">":   true,
+		">=":  true,
+		"==":  true,
+		"!=":  true,
+		"and": true,
+		"or":  true,
+	},
+}
+
 type ExpRepo interface {
 	Add(ctx context.Context, exp *models.Expression) error
 	Get(ctx context.Context, id string) (*models.Expression, error)
 	GetAll(ctx context.Context, userID, cursor string, limit int64) ([]*models.Expression, error)
+	// Update stores the outcome of a pending or stalled expression,
+	// expressions which have already completed, failed or been cancelled are
+	// left as they are.
 	Update(ctx context.Context, exp *models.Expression) error
+	CountPending(ctx context.Context, userID string) (int64, error)
 }
 
 type UserRepo interface {
@@ -43,9 +244,37 @@ type UserRepo interface {
 	GetUserByID(ctx context.Context, id string) (*models.User, error)
 }
 
+type VarRepo interface {
+	AddVariable(ctx context.Context, v *models.Variable) error
+	GetVariable(ctx context.Context, userID, name string) (*models.Variable, error)
+	GetVariables(ctx context.Context, userID string) ([]*models.Variable, error)
+	UpdateVariable(ctx context.Context, v *models.Variable) error
+	DeleteVariable(ctx context.Context, userID, name string) error
+}
+
 type TaskRepo interface {
 	AddTasks(ctx context.Context, tasks []*models.Task) error
-	GetTask(ctx context.Context) (*models.Task, error)
+	// ClaimTask atomically moves a ready task to in_progress, recording the
+	// claimant and the claim time. A task is never returned twice while its
+	// lease lasts, nor before it is due to be retried.
+	ClaimTask(ctx context.Context, claimant string, lease time.Duration) (*models.Task, error)
+	// ExpiredTasks, ClaimedTasks and RunningTasks list claimed tasks, those
+	// with a lease ended before now, held by the claimant or belonging to the
+	// expression respectively.
+	ExpiredTasks(ctx context.Context, now time.Time) ([]*models.Task, error)
+	ClaimedTasks(ctx context.Context, claimant string) ([]*models.Task, error)
+	RunningTasks(ctx context.Context, expID string) ([]*models.Task, error)
+	// RequeueTask returns the task to ready with its retry counter and time,
+	// unless it was finished meanwhile.
+	RequeueTask(ctx context.Context, task *models.Task) error
+	// AddDeadLetter moves the claimed task of the dead letter aside and marks
+	// its pending expression stalled, unless the task was finished meanwhile.
+	// RequeueDeadLetter moves it back as ready, the expression is pending again
+	// once none of its tasks are left in the dead letters.
+	AddDeadLetter(ctx context.Context, dl *models.DeadLetter) error
+	GetDeadLetters(ctx context.Context) ([]*models.DeadLetter, error)
+	RequeueDeadLetter(ctx context.Context, id string) error
+	GetTask(ctx context.Context, id string) (*models.Task, error)
 	UpdateTask(ctx context.Context, task *models.Task) error
 	DeleteTasks(ctx context.Context, expID string) error
 }
@@ -61,38 +290,35 @@ type Service struct {
 	expRepo  ExpRepo
 	taskRepo TaskRepo
 	userRepo UserRepo
+	varRepo  VarRepo
 	bl       BlackList
 	auth     *authenticator.Authenticator
+	cancels  cancellations
 }
 
-func NewService(cfg *config.Config, expRepo ExpRepo, taskRepo TaskRepo, userRepo UserRepo, auth *authenticator.Authenticator, bl BlackList) *Service {
+func NewService(cfg *config.Config, expRepo ExpRepo, taskRepo TaskRepo, userRepo UserRepo, varRepo VarRepo, auth *authenticator.Authenticator, bl BlackList) *Service {
 	return &Service{
 		cfg:      cfg,
 		expRepo:  expRepo,
 		taskRepo: taskRepo,
 		userRepo: userRepo,
+		varRepo:  varRepo,
 		auth:     auth,
 		bl:       bl,
 	}
 }
 
-func (s *Service) Evaluate(ctx context.Context, expression, userID string) (string, error) {
-	err := validate(expression)
+func (s *Service) Evaluate(ctx context.Context, req *models.CalculateRequest, userID string) (string, error) {
+	exp, tasks, err := s.compile(ctx, req, userID)
 	if err != nil {
 		return "", err
 	}
 
-	expID, _ := uuid.NewV7()
-
-	exp := &models.Expression{
-		Id:     expID.String(),
-		UserID: userID,
-		Status: StatusPending,
-		Result: 0,
+	err = s.admit(ctx, userID)
+	if err != nil {
+		return "", err
 	}
 
-	tasks, err := parseExpression(expression, expID.String())
-
 	err = s.expRepo.Add(ctx, exp)
 	if err != nil {
 		return "", err
@@ -103,7 +329,93 @@ func (s *Service) Evaluate(ctx context.Context, expression, userID string) (stri
 		return "", err
 	}
 
-	return expID.String(), nil
+	return exp.Id, nil
+}
+
+// compile validates the request and builds the expression with its tasks
+// without persisting them.
+func (s *Service) compile(ctx context.Context, req *models.CalculateRequest, userID string) (*models.Expression, []*models.Task, error) {
+	mode, precision, base, err := evaluationMode(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts, err := localeOptions(req.Locale)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts.MaxDepth = s.cfg.MaxDepth
+
+	err = s.checkLength(req.Expression)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	script, err := validate(req.Expression, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.checkNodes(script)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if mode == ModeFloat && isComplex(script) {
+		mode = ModeComplex
+	}
+
+	if req.Compensated && mode != ModeFloat {
+		return nil, nil, fmt.Errorf("%w: compensated summation is supported only in %s mode", e.ErrInvalidMode, ModeFloat)
+	}
+
+	vars, err := s.bindVariables(ctx, script, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = checkMode(script, mode, vars)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dims, err := dimensions(script, vars)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expID, _ := uuid.NewV7()
+
+	exp := &models.Expression{
+		Id:          expID.String(),
+		UserID:      userID,
+		Expression:  req.Expression,
+		Variables:   vars,
+		Mode:        mode,
+		Precision:   precision,
+		Base:        base,
+		Locale:      req.Locale,
+		Compensated: req.Compensated,
+		Rebalance:   req.Rebalance == nil || *req.Rebalance,
+		Status:      StatusPending,
+		Result:      0,
+	}
+
+	tasks := buildTasks(script, exp, dims)
+	exp.Unit = tasks[len(tasks)-1].Unit
+
+	if req.Optimize == nil || *req.Optimize {
+		tasks = optimize(tasks, exp)
+	}
+
+	exp.CriticalPath = criticalPath(tasks)
+
+	err = s.checkTasks(tasks)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return exp, tasks, nil
 }
 
 func (s *Service) Get(ctx context.Context, id, userID string) (*models.Expression, error) {
@@ -112,8 +424,10 @@ func (s *Service) Get(ctx context.Context, id, userID string) (*models.Expressio
 		return nil, fmt.Errorf("failed to get expression %w: ", err)
 	}
 
+	// Expressions of other users are not found, the caller must not learn
+	// that their IDs exist.
 	if exp.UserID != userID {
-		return nil, fmt.Errorf("failed to access expression %s: %w", id, e.ErrUnauthorized)
+		return nil, fmt.Errorf("failed to get expression %s: %w", id, e.ErrExpressionDoesNotExist)
 	}
 
 	return exp, nil
@@ -123,38 +437,81 @@ func (s *Service) GetAll(ctx context.Context, userID, cursor string, limit int64
 	return s.expRepo.GetAll(ctx, userID, cursor, limit)
 }
 
-func (s *Service) GetTask(ctx context.Context) (*models.AgentTask, error) {
-	task, err := s.taskRepo.GetTask(ctx)
+// GetTask claims a ready task for the agent stream identified by claimant.
+func (s *Service) GetTask(ctx context.Context, claimant string) (*models.AgentTask, error) {
+	task, err := s.taskRepo.ClaimTask(ctx, claimant, s.cfg.LeaseDuration)
 	if err != nil {
 		return nil, err
 	}
 
 	at := &models.AgentTask{
-		Id:       task.ID,
-		LeftArg:  task.LeftArg,
-		RightArg: task.RightArg,
-		Op:       task.Op,
-		Final:    task.Final,
+		Id:    task.ID,
+		Args:  make([]float64, 0, len(task.Args)),
+		Op:    task.Op,
+		Final: task.Final,
+	}
+
+	for _, arg := range task.Args {
+		at.Args = append(at.Args, arg.Value)
+		if arg.Number != nil {
+			at.Numbers = append(at.Numbers, *arg.Number)
+		}
 	}
 
-	switch at.Op {
-	case "+":
-		at.OperationTime = s.cfg.AdditionTime.Milliseconds()
+	if task.Precision != nil {
+		p := *task.Precision
+		at.Precision = &p
+	}
+
+	at.OperationTime = s.operationTime(at.Op).Milliseconds()
+
+	return at, nil
+}
+
+// operationTime reports how long agents take to compute op.
+func (s *Service) operationTime(op string) time.Duration {
+	switch op {
+	case "+", opCompensatedSum:
+		return s.cfg.AdditionTime
 	case "-":
-		at.OperationTime = s.cfg.SubtractionTime.Milliseconds()
+		return s.cfg.SubtractionTime
 	case "*":
-		at.OperationTime = s.cfg.MultiplicationTime.Milliseconds()
+		return s.cfg.MultiplicationTime
 	case "/":
-		at.OperationTime = s.cfg.DivisionTime.Milliseconds()
+		return s.cfg.DivisionTime
+	case "^":
+		return s.cfg.PowerTime
+	case "%":
+		return s.cfg.ModuloTime
+	case "//":
+		return s.cfg.IntDivisionTime
+	case opNeg:
+		return s.cfg.NegationTime
+	case "&", "|", "xor", "~", "<<", ">>":
+		return s.cfg.BitwiseTime
+	case "<", "<=", ">", ">=", "==", "!=", "and", "or", "not":
+		return s.cfg.LogicTime
+	default:
+		if parser.IsFunction(op) {
+			return s.cfg.FunctionTime(op)
+		}
 	}
 
-	return at, nil
+	return 0
 }
 
 func (s *Service) FinishTask(ctx context.Context, task *models.TaskResult) error {
+	if task.Status == taskFailure {
+		return s.fail(ctx, task)
+	}
+
+	expID := strings.Split(task.Id, ":")[0]
+
 	err := s.taskRepo.UpdateTask(ctx, &models.Task{
 		ID:     task.Id,
+		ExpID:  expID,
 		Result: task.Result,
+		Number: task.Number,
 		Status: task.Status,
 		Final:  task.Final,
 	})
@@ -166,9 +523,7 @@ func (s *Service) FinishTask(ctx context.Context, task *models.TaskResult) error
 		return nil
 	}
 
-	expID := strings.Split(task.Id, ":")[0]
-
-	err = s.finalize(ctx, expID, task.Result)
+	err = s.finalize(ctx, expID, task)
 	if err != nil {
 		return err
 	}
@@ -176,11 +531,28 @@ func (s *Service) FinishTask(ctx context.Context, task *models.TaskResult) error
 	return nil
 }
 
-func (s *Service) finalize(ctx context.Context, expID string, result float64) error {
+func (s *Service) finalize(ctx context.Context, expID string, task *models.TaskResult) error {
 	exp := &models.Expression{
 		Id:     expID,
 		Status: StatusCompleted,
-		Result: result,
+		Result: task.Result,
+	}
+
+	switch {
+	case task.Number == nil:
+	case task.Number.Rational != nil:
+		exp.Fraction, exp.Decimal = fraction(task.Number.Rational)
+	case task.Number.Complex != nil:
+		c := *task.Number.Complex
+		exp.Complex = &c
+	case task.Number.Integer != "":
+		integer, err := s.integer(ctx, expID, task.Number.Integer)
+		if err != nil {
+			return err
+		}
+		exp.Integer = integer
+	default:
+		exp.Decimal = task.Number.Decimal
 	}
 
 	err := s.expRepo.Update(ctx, exp)
@@ -191,158 +563,1045 @@ func (s *Service) finalize(ctx context.Context, expID string, result float64) er
 	return nil
 }
 
-type token struct {
-	tokenType string
-	value     string
+// integer formats the result of the integer mode in the base requested for
+// the expression.
+func (s *Service) integer(ctx context.Context, expID, val string) (string, error) {
+	exp, err := s.expRepo.Get(ctx, expID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get expression: %w", err)
+	}
+
+	x, ok := new(big.Int).SetString(val, 10)
+	if !ok || exp.Base == 0 {
+		return val, nil
+	}
+
+	return x.Text(exp.Base), nil
+}
+
+// fraction formats the result of the rational mode as a fraction and as its
+// decimal approximation.
+func fraction(r *models.Rational) (string, string) {
+	x, ok := new(big.Rat).SetString(r.Num + "/" + r.Den)
+	if !ok {
+		return r.Num + "/" + r.Den, ""
+	}
+
+	dec := x.FloatString(defaultScale)
+	dec = strings.TrimRight(strings.TrimRight(dec, "0"), ".")
+	if dec == "-0" {
+		dec = "0"
+	}
+
+	return x.RatString(), dec
 }
 
-func isOperator(r rune) bool {
-	return r == '+' || r == '-' || r == '*' || r == '/'
+// bindVariables resolves identifiers of the script which are neither
+// reserved nor assigned by the script against variables of the user. Values
+// are captured at submission, so later changes of variables do not affect
+// the expression.
+func (s *Service) bindVariables(ctx context.Context, script *parser.Script, userID string) (map[string]float64, error) {
+	assigned := make(map[string]bool)
+	for _, stmt := range script.Stmts {
+		if as, ok := stmt.(*parser.AssignStmt); ok {
+			assigned[as.Name.Name] = true
+		}
+	}
+
+	idents := make([]*parser.Ident, 0)
+	parser.Inspect(script, func(n parser.Node) bool {
+		if id, ok := n.(*parser.Ident); ok {
+			if !parser.IsReserved(id.Name) && !assigned[id.Name] {
+				idents = append(idents, id)
+			}
+		}
+		return true
+	})
+
+	if len(idents) == 0 {
+		return nil, nil
+	}
+
+	userVars, err := s.varRepo.GetVariables(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variables: %w", err)
+	}
+
+	values := make(map[string]float64, len(userVars))
+	for _, v := range userVars {
+		values[v.Name] = v.Value
+	}
+
+	vars := make(map[string]float64, len(idents))
+	for _, id := range idents {
+		val, ok := values[id.Name]
+		if !ok {
+			hint := "add it to your variables or assign it in the expression"
+			if name := parser.Suggest(id.Name, slices.Collect(maps.Keys(values))); name != "" {
+				hint = fmt.Sprintf("did you mean %q?", name)
+			}
+
+			return nil, fmt.Errorf("%w: %w", errors.ErrInvalidExpression, &parser.Error{
+				Code:   parser.CodeUndefinedName,
+				Offset: id.NamePos,
+				Length: len(id.Name),
+				Msg:    fmt.Sprintf("unknown variable %q", id.Name),
+				Hint:   hint,
+			})
+		}
+		vars[id.Name] = val
+	}
+
+	return vars, nil
 }
 
-func isParenthesis(r rune) bool {
-	return r == '(' || r == ')'
+// evaluationMode validates the mode of the request. The precision is set
+// only in the decimal mode, it defaults to 20 digits rounded half to even.
+// The base of the result is set only in the integer mode, it defaults to 10.
+func evaluationMode(req *models.CalculateRequest) (string, *models.Precision, int, error) {
+	if req.Mode != ModeDecimal && req.Precision != nil {
+		return "", nil, 0, fmt.Errorf("%w: precision is supported only in %s mode", e.ErrInvalidMode, ModeDecimal)
+	}
+
+	if req.Mode != ModeInteger && req.Base != 0 {
+		return "", nil, 0, fmt.Errorf("%w: base is supported only in %s mode", e.ErrInvalidMode, ModeInteger)
+	}
+
+	switch req.Mode {
+	case "":
+		return ModeFloat, nil, 0, nil
+	case ModeFloat, ModeRational, ModeComplex:
+		return req.Mode, nil, 0, nil
+	case ModeInteger:
+		if req.Base == 0 {
+			return ModeInteger, nil, defaultBase, nil
+		}
+		if req.Base < 2 || req.Base > 36 {
+			return "", nil, 0, fmt.Errorf("%w: base must be from 2 to 36, got %d", e.ErrInvalidMode, req.Base)
+		}
+		return ModeInteger, nil, req.Base, nil
+	case ModeDecimal:
+	default:
+		return "", nil, 0, fmt.Errorf("%w: unknown mode %q", e.ErrInvalidMode, req.Mode)
+	}
+
+	p := &models.Precision{
+		Scale:    defaultScale,
+		Rounding: defaultRounding,
+	}
+
+	if req.Precision != nil {
+		p.Scale = req.Precision.Scale
+		if req.Precision.Rounding != "" {
+			p.Rounding = req.Precision.Rounding
+		}
+	}
+
+	if p.Scale < 0 || p.Scale > maxScale {
+		return "", nil, 0, fmt.Errorf("%w: scale must be from 0 to %d, got %d", e.ErrInvalidMode, maxScale, p.Scale)
+	}
+
+	if !roundingModes[p.Rounding] {
+		return "", nil, 0, fmt.Errorf("%w: unknown rounding %q", e.ErrInvalidMode, p.Rounding)
+	}
+
+	return ModeDecimal, p, 0, nil
 }
 
-func validate(expression string) error {
-	var currentToken token
-	var dotEncountered bool
-	var parenthesisCount int
-	var previousRune rune
+// localeOptions reports how numbers are written in the locale, a language
+// tag like "de" or "fr-FR". Numbers of other languages and of an empty
+// locale are written with a decimal point.
+func localeOptions(locale string) (parser.Options, error) {
+	if locale == "" {
+		return parser.Options{}, nil
+	}
 
-	if len(expression) < 1 {
-		return fmt.Errorf("%w: expression is empty", errors.ErrInvalidExpression)
+	if !localeRegexp.MatchString(locale) {
+		return parser.Options{}, fmt.Errorf("%w: %q is not a language tag", e.ErrInvalidLocale, locale)
 	}
 
-	for i, r := range expression {
-		if unicode.IsSpace(r) {
-			continue
+	lang, _, _ := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-")
+
+	return parser.Options{DecimalComma: decimalCommaLanguages[strings.ToLower(lang)]}, nil
+}
+
+// isComplex reports whether the script uses the imaginary unit or imaginary
+// literals.
+func isComplex(script *parser.Script) bool {
+	var found bool
+	parser.Inspect(script, func(n parser.Node) bool {
+		switch n := n.(type) {
+		case *parser.NumberLit:
+			found = found || n.Imag
+		case *parser.Ident:
+			found = found || n.Name == parser.ImaginaryUnit
+		}
+		return !found
+	})
+
+	return found
+}
+
+// checkMode reports functions and operators of the script which are not
+// supported in the mode. Irrational constants are not supported in the
+// rational mode, complex numbers are supported only in the complex mode and
+// the integer mode accepts only integer operands.
+func checkMode(script *parser.Script, mode string, vars map[string]float64) error {
+	functions, limited := modeFunctions[mode]
+
+	var err error
+	parser.Inspect(script, func(n parser.Node) bool {
+		if err != nil {
+			return false
 		}
 
-		// Prevent '*2+3' or '2+3*'
-		if i == 0 && isOperator(r) {
-			if expression[0] != '-' && expression[0] != '+' {
-				return fmt.Errorf("%w: unexpected operator at the beginning of expression", errors.ErrInvalidExpression)
+		switch n := n.(type) {
+		case *parser.CallExpr:
+			if limited && !functions[n.Func] {
+				err = exprError(parser.CodeUnsupported, n.FuncPos, len(n.Func), "function %q is not supported in %s mode", n.Func, mode)
+			}
+		case *parser.BinaryExpr:
+			if !modeOperators[mode][n.Op] {
+				err = exprError(parser.CodeUnsupported, n.OpPos, len(n.Op), "operator %q is not supported in %s mode", n.Op, mode)
+			}
+		case *parser.UnitExpr:
+			if mode != ModeFloat {
+				err = exprError(parser.CodeUnsupported, n.Pos(), n.End()-n.Pos(), "units are not supported in %s mode", mode)
+			}
+		case *parser.UnaryExpr:
+			if (n.Op == "~" && mode != ModeInteger) || (n.Op == "not" && mode == ModeComplex) {
+				err = exprError(parser.CodeUnsupported, n.OpPos, len(n.Op), "operator %q is not supported in %s mode", n.Op, mode)
+			}
+		case *parser.NumberLit:
+			if n.Imag && mode != ModeComplex {
+				err = exprError(parser.CodeUnsupported, n.Pos(), n.End()-n.Pos(), "complex numbers are not supported in %s mode", mode)
+			}
+			if _, ok := n.Int(); !ok && mode == ModeInteger {
+				err = exprError(parser.CodeUnsupported, n.Pos(), n.End()-n.Pos(), "number %s is not an integer", n.Raw)
+			}
+		case *parser.Ident:
+			if n.Name == parser.ImaginaryUnit && mode != ModeComplex {
+				err = exprError(parser.CodeUnsupported, n.NamePos, len(n.Name), "complex numbers are not supported in %s mode", mode)
+			}
+			if _, ok := parser.Constants[n.Name]; ok && (mode == ModeRational || mode == ModeInteger) {
+				err = exprError(parser.CodeUnsupported, n.NamePos, len(n.Name), "constant %q is irrational and is not supported in %s mode", n.Name, mode)
+			}
+			if val, ok := vars[n.Name]; ok && mode == ModeInteger && val != math.Trunc(val) {
+				err = exprError(parser.CodeUnsupported, n.NamePos, len(n.Name), "variable %q is %v, not an integer", n.Name, val)
 			}
-			currentToken.tokenType = number
-			currentToken.value += string(expression[0])
-			continue
 		}
+		return err == nil
+	})
 
-		if i == len(expression)-1 && isOperator(r) {
-			return fmt.Errorf("%w: unexpected operator at the end of expression", errors.ErrInvalidExpression)
+	return err
+}
+
+func exprError(code parser.Code, offset, length int, format string, args ...any) error {
+	return fmt.Errorf("%w: %w", errors.ErrInvalidExpression, &parser.Error{
+		Code:   code,
+		Offset: offset,
+		Length: length,
+		Msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+func validate(expression string, opts parser.Options) (*parser.Script, error) {
+	script, err := parser.ParseScriptWith(expression, opts)
+	if err != nil {
+		return nil, syntaxError(err)
+	}
+
+	return script, nil
+}
+
+// literal reports the value of a signed number literal or a bound name,
+// possibly wrapped in parentheses, so it can be folded into a single ready task.
+func literal(node parser.Node, vars map[string]float64) (float64, bool) {
+	switch n := node.(type) {
+	case *parser.NumberLit:
+		return n.Value, !n.Imag
+	case *parser.Ident:
+		if val, ok := parser.Constants[n.Name]; ok {
+			return val, true
 		}
+		val, ok := vars[n.Name]
+		return val, ok
+	case *parser.QuantityExpr:
+		return n.X.Value * n.Unit.Value.Factor, true
+	case *parser.ParenExpr:
+		return literal(n.X, vars)
+	case *parser.UnaryExpr:
+		if n.Op != "-" && n.Op != "+" {
+			return 0, false
+		}
+		val, ok := literal(n.X, vars)
+		if n.Op == "-" {
+			val = -val
+		}
+		return val, ok
+	}
 
-		if unicode.IsDigit(r) {
-			currentToken.tokenType = number
-			currentToken.value += string(r)
-		} else if r == '.' {
-			// Prevent '3.14.2'
-			if dotEncountered {
-				return fmt.Errorf("%w: multiple decimal points in the same number", errors.ErrInvalidExpression)
+	return 0, false
+}
+
+// complexLiteral is like literal, but also folds imaginary literals and the
+// imaginary unit.
+func complexLiteral(node parser.Node, vars map[string]float64) (complex128, bool) {
+	switch n := node.(type) {
+	case *parser.NumberLit:
+		if n.Imag {
+			return complex(0, n.Value), true
+		}
+		return complex(n.Value, 0), true
+	case *parser.Ident:
+		if n.Name == parser.ImaginaryUnit {
+			return 1i, true
+		}
+		val, ok := literal(n, vars)
+		return complex(val, 0), ok
+	case *parser.ParenExpr:
+		return complexLiteral(n.X, vars)
+	case *parser.UnaryExpr:
+		val, ok := complexLiteral(n.X, vars)
+		if n.Op == "-" {
+			// Unlike -val, keeps the zero imaginary part positive, so
+			// sqrt(-4) is 2i and not -2i.
+			val = 0 - val
+		}
+		return val, ok
+	}
+
+	return 0, false
+}
+
+// decimalLiteral is like literal, but keeps the exact decimal notation of
+// the value.
+func decimalLiteral(node parser.Node, vars map[string]float64) (string, bool) {
+	switch n := node.(type) {
+	case *parser.NumberLit:
+		if x, ok := n.Int(); ok {
+			return x.String(), true
+		}
+		mantissa, exp, found := strings.Cut(strings.ToLower(n.Raw), "e")
+		if !found {
+			return n.Raw, true
+		}
+		// Scientific notation is written out, like 0.00015 for 1.5e-4.
+		_, frac, _ := strings.Cut(mantissa, ".")
+		shift, _ := strconv.Atoi(exp)
+		r, ok := new(big.Rat).SetString(n.Raw)
+		if !ok {
+			return "", false
+		}
+		return r.FloatString(max(len(frac)-shift, 0)), true
+	case *parser.Ident:
+		val, ok := literal(n, vars)
+		return strconv.FormatFloat(val, 'f', -1, 64), ok
+	case *parser.ParenExpr:
+		return decimalLiteral(n.X, vars)
+	case *parser.UnaryExpr:
+		val, ok := decimalLiteral(n.X, vars)
+		if n.Op == "-" {
+			if neg, found := strings.CutPrefix(val, "-"); found {
+				return neg, ok
 			}
-			currentToken.tokenType = number
-			currentToken.value += string(r)
-			dotEncountered = true
-		} else {
-			if currentToken.value != "" {
-				currentToken = token{}
+			return "-" + val, ok
+		}
+		return val, ok
+	}
+
+	return "", false
+}
+
+// ratLiteral is like decimalLiteral, but returns the exact value.
+func ratLiteral(node parser.Node, vars map[string]float64) (*big.Rat, bool) {
+	dec, ok := decimalLiteral(node, vars)
+	if !ok {
+		return nil, false
+	}
+
+	return new(big.Rat).SetString(dec)
+}
+
+// buildTasks compiles the script into a single task graph. The task of an
+// assignment is computed once and its result is passed to every task using
+// the assigned name. Quantities are converted to SI base units, dims are
+// dimensions of the nodes reported by dimensions.
+//
+// Tasks of branches of 'if' are guarded by the condition task and are not
+// dispatched until the condition chooses them; the repository deletes tasks
+// of the other branch and turns the 'if' task into an identity of the chosen
+// one.
+//
+// Structurally identical subexpressions are compiled into a single task,
+// whose result is passed to all of their consumers, unless they are in
+// different branches of 'if'. Literals are not shared, every one of them is
+// a task of its own until it is inlined by optimize.
+//
+// Aggregation functions are compiled into balanced reduction trees of binary
+// operations, so N arguments are reduced in O(log N) rounds of tasks which
+// agents compute in parallel. Compensated sums use trees of 'ksum' tasks
+// with up to 16 arguments instead. Unless exp.Rebalance is false, chains of
+// associative operators not broken by parentheses are compiled the same
+// way, so a-b+c-d is (a+c)-(b+d).
+func buildTasks(script *parser.Script, exp *models.Expression, dims map[parser.Node]units.Dimension) []*models.Task {
+	tasks := make([]*models.Task, 0)
+	taskID := 1
+	scope := make(map[string]*models.Task)
+	guards := make([]string, 0)
+	ids := hashCons(script)
+	shared := make(map[string]*models.Task)
+
+	newTask := func(op string, args ...models.Arg) *models.Task {
+		t := &models.Task{
+			ID:        fmt.Sprintf("%s:%d", exp.Id, taskID),
+			ExpID:     exp.Id,
+			Op:        op,
+			Args:      args,
+			Precision: exp.Precision,
+		}
+		taskID++
+
+		if len(guards) > 0 {
+			t.Guards = slices.Clone(guards)
+		}
+
+		if dependencies(t) == 0 && len(t.Guards) == 0 {
+			t.Status = "ready"
+		}
+
+		tasks = append(tasks, t)
+		return t
+	}
+
+	leaf := func(node parser.Node) (models.Arg, bool) {
+		if exp.Mode == ModeComplex {
+			c, ok := complexLiteral(node, exp.Variables)
+			return models.Arg{
+				Value:  real(c),
+				Number: &models.Number{Complex: &models.Complex{Re: real(c), Im: imag(c)}},
+			}, ok
+		}
+
+		val, ok := literal(node, exp.Variables)
+		if !ok {
+			return models.Arg{}, false
+		}
+
+		arg := models.Arg{Value: val}
+		switch exp.Mode {
+		case ModeDecimal:
+			dec, ok := decimalLiteral(node, exp.Variables)
+			if !ok {
+				return models.Arg{}, false
 			}
-			if isOperator(r) {
-				if isOperator(previousRune) {
-					return fmt.Errorf("%w: multiple sequent operators", errors.ErrInvalidExpression)
+			arg.Number = &models.Number{Decimal: dec}
+		case ModeRational:
+			r, ok := ratLiteral(node, exp.Variables)
+			if !ok {
+				return models.Arg{}, false
+			}
+			arg.Number = &models.Number{Rational: &models.Rational{Num: r.Num().String(), Den: r.Denom().String()}}
+		case ModeInteger:
+			r, ok := ratLiteral(node, exp.Variables)
+			if !ok {
+				return models.Arg{}, false
+			}
+			arg.Number = &models.Number{Integer: r.Num().String()}
+		}
+
+		return arg, true
+	}
+
+	// count is an argument holding the number of aggregated values.
+	count := func(n int) models.Arg {
+		arg := models.Arg{Value: float64(n)}
+		switch exp.Mode {
+		case ModeDecimal:
+			arg.Number = &models.Number{Decimal: strconv.Itoa(n)}
+		case ModeRational:
+			arg.Number = &models.Number{Rational: &models.Rational{Num: strconv.Itoa(n), Den: "1"}}
+		case ModeComplex:
+			arg.Number = &models.Number{Complex: &models.Complex{Re: float64(n)}}
+		case ModeInteger:
+			arg.Number = &models.Number{Integer: strconv.Itoa(n)}
+		}
+
+		return arg
+	}
+
+	// reduce combines the tasks level by level, every level has half as
+	// many tasks as the previous one.
+	reduce := func(op string, items []*models.Task) *models.Task {
+		fanIn := 2
+		if op == "+" && exp.Compensated {
+			op, fanIn = opCompensatedSum, compensatedFanIn
+		}
+
+		for len(items) > 1 {
+			next := make([]*models.Task, 0, (len(items)+fanIn-1)/fanIn)
+			for i := 0; i < len(items); i += fanIn {
+				group := items[i:min(i+fanIn, len(items))]
+				if len(group) == 1 {
+					next = append(next, group[0])
+					continue
 				}
 
-				currentToken.tokenType = operator
-				currentToken.value = string(r)
+				args := make([]models.Arg, 0, len(group))
+				for _, t := range group {
+					args = append(args, models.Arg{TaskID: &t.ID})
+				}
+				next = append(next, newTask(op, args...))
+			}
+			items = next
+		}
+
+		return items[0]
+	}
+
+	var visit func(node parser.Node) *models.Task
+
+	// aggregate compiles sum, product, avg and stddev of the values. The
+	// standard deviation is the population one, the square root of the
+	// average squared deviation from the mean.
+	aggregate := func(fn string, values []parser.Node) *models.Task {
+		items := make([]*models.Task, 0, len(values))
+		for _, v := range values {
+			items = append(items, visit(v))
+		}
+
+		if fn == "product" {
+			return reduce("*", items)
+		}
+
+		sum := reduce("+", items)
+		if fn == "sum" {
+			return sum
+		}
+
+		mean := newTask("/", models.Arg{TaskID: &sum.ID}, count(len(items)))
+		if fn == "avg" {
+			return mean
+		}
+
+		squares := make([]*models.Task, 0, len(items))
+		for _, t := range items {
+			dev := newTask("-", models.Arg{TaskID: &t.ID}, models.Arg{TaskID: &mean.ID})
+			squares = append(squares, newTask("*", models.Arg{TaskID: &dev.ID}, models.Arg{TaskID: &dev.ID}))
+		}
 
-				currentToken.value = ""
-			} else if isParenthesis(r) {
-				// Prevent '2+()+3'
-				if isParenthesis(r) && isParenthesis(previousRune) && r != previousRune {
-					return fmt.Errorf("%w: empty parentheses", errors.ErrInvalidExpression)
+		sumSquares := reduce("+", squares)
+		variance := newTask("/", models.Arg{TaskID: &sumSquares.ID}, count(len(items)))
+		return newTask("sqrt", models.Arg{TaskID: &variance.ID})
+	}
+
+	build := func(node parser.Node) *models.Task {
+		switch n := node.(type) {
+		case *parser.NumberLit, *parser.QuantityExpr:
+			arg, _ := leaf(n)
+			return newTask("", arg)
+
+		case *parser.Ident:
+			if t, ok := scope[n.Name]; ok {
+				return t
+			}
+
+			arg, ok := leaf(n)
+			if !ok {
+				panic(fmt.Sprintf("unbound identifier %q", n.Name))
+			}
+
+			return newTask("", arg)
+
+		case *parser.BinaryExpr:
+			if exp.Rebalance && associativeOperators[exp.Mode][n.Op] {
+				op := n.Op
+				if op == "-" || op == "/" {
+					op = inverses[op]
+				}
+
+				var pos, neg []*models.Task
+				for _, term := range chain(n, false) {
+					if term.inverse {
+						neg = append(neg, visit(term.node))
+					} else {
+						pos = append(pos, visit(term.node))
+					}
 				}
 
-				currentToken.tokenType = parenthesis
-				currentToken.value = string(r)
+				t := reduce(op, pos)
+				if len(neg) == 0 {
+					return t
+				}
 
-				currentToken.value = ""
+				d := reduce(op, neg)
+				return newTask(inverses[op], models.Arg{TaskID: &t.ID}, models.Arg{TaskID: &d.ID})
+			}
 
-				if r == '(' {
-					parenthesisCount++
-				} else {
-					parenthesisCount--
+			leftTask := visit(n.X)
+			rightTask := visit(n.Y)
+
+			return newTask(n.Op, models.Arg{TaskID: &leftTask.ID}, models.Arg{TaskID: &rightTask.ID})
+
+		case *parser.UnaryExpr:
+			if arg, ok := leaf(n); ok {
+				return newTask("", arg)
+			}
+
+			if n.Op == "+" {
+				return visit(n.X)
+			}
+
+			operand := visit(n.X)
+
+			if n.Op != "-" {
+				return newTask(n.Op, models.Arg{TaskID: &operand.ID})
+			}
+
+			return newTask(opNeg, models.Arg{TaskID: &operand.ID})
+
+		case *parser.CallExpr:
+			if n.Func == opIf {
+				cond := visit(n.Args[0])
+				cond.Condition = true
+
+				args := []models.Arg{{TaskID: &cond.ID}}
+				for i, branch := range n.Args[1:] {
+					guards = append(guards, models.BranchGuard(cond.ID, i == 0))
+					branchTask := visit(branch)
+					guards = guards[:len(guards)-1]
+
+					args = append(args, models.Arg{TaskID: &branchTask.ID})
 				}
-			} else {
-				return fmt.Errorf("%w: invalid character: %c", errors.ErrInvalidExpression, r)
+
+				return newTask(opIf, args...)
 			}
+
+			switch n.Func {
+			case "sum", "product", "avg", "stddev":
+				return aggregate(n.Func, parser.Flatten(n.Args))
+			}
+
+			values := parser.Flatten(n.Args)
+			args := make([]models.Arg, 0, len(values))
+			for _, arg := range values {
+				argTask := visit(arg)
+				args = append(args, models.Arg{TaskID: &argTask.ID})
+			}
+
+			return newTask(n.Func, args...)
+
+		case *parser.ConvertExpr:
+			x := visit(n.X)
+			t := newTask("/", models.Arg{TaskID: &x.ID}, models.Arg{Value: n.Unit.Value.Factor})
+			t.Unit = n.Unit.String()
+			return t
+
+		case *parser.ParenExpr:
+			return visit(n.X)
 		}
 
-		previousRune = r
+		panic(fmt.Sprintf("unexpected node %T", node))
 	}
 
-	if parenthesisCount != 0 {
-		return fmt.Errorf("%w: invalid parenthesis", errors.ErrInvalidExpression)
+	visit = func(node parser.Node) *models.Task {
+		key := fmt.Sprintf("%d|%s", ids[node], strings.Join(guards, ","))
+		if t, ok := shared[key]; ok {
+			return t
+		}
+
+		start := len(tasks)
+		t := build(node)
+		if d := dims[node]; t.Unit == "" && !d.IsZero() {
+			t.Unit = d.String()
+		}
+
+		// Operands set the source of their own tasks first, the rest, like
+		// levels of reduction trees, compute parts of this node.
+		for _, nt := range tasks[start:] {
+			if nt.Source == "" {
+				nt.Source = source(exp.Expression, node)
+			}
+		}
+
+		if t.Op != "" || dependencies(t) > 0 {
+			shared[key] = t
+		}
+
+		return t
 	}
 
-	return nil
+	var final *models.Task
+	for _, stmt := range script.Stmts {
+		as, ok := stmt.(*parser.AssignStmt)
+		if !ok {
+			final = visit(stmt)
+			continue
+		}
+
+		final = visit(as.X)
+		if final.Name != "" {
+			// The value is another name or the same expression, it needs a
+			// task of its own to be saved under this name as well.
+			final = newTask("", models.Arg{TaskID: &final.ID})
+		}
+
+		final.Name = as.Name.Name
+		scope[as.Name.Name] = final
+	}
+
+	final.Final = true
+	return tasks
 }
 
-func parseExpression(exprStr, expID string) ([]*models.Task, error) {
-	expr, err := parser.ParseExpr(exprStr)
-	if err != nil {
-		return nil, err
+// source is the text of the node in the expression.
+func source(expression string, node parser.Node) string {
+	if node.Pos() < 0 || node.End() > len(expression) || node.Pos() > node.End() {
+		return ""
 	}
 
-	tasks := make([]*models.Task, 0)
-	taskID := 1
+	return strings.TrimSpace(expression[node.Pos():node.End()])
+}
+
+// term is an operand of a chain of associative operators, inverse terms are
+// subtracted or divided by.
+type term struct {
+	node    parser.Node
+	inverse bool
+}
+
+// chain flattens the operands of associative operators of the same
+// precedence as the one of n, parentheses end the chain.
+func chain(n *parser.BinaryExpr, inverse bool) []term {
+	var terms []term
+	for i, x := range []parser.Node{n.X, n.Y} {
+		inv := inverse
+		if i == 1 && (n.Op == "-" || n.Op == "/") {
+			inv = !inv
+		}
+
+		if b, ok := x.(*parser.BinaryExpr); ok && (b.Op == n.Op || b.Op == inverses[n.Op]) {
+			terms = append(terms, chain(b, inv)...)
+		} else {
+			terms = append(terms, term{node: x, inverse: inv})
+		}
+	}
+
+	return terms
+}
+
+// criticalPath reports the number of tasks on the longest chain of tasks
+// waiting for each other. Tasks of branches of 'if' wait for the condition.
+func criticalPath(tasks []*models.Task) int {
+	depth := make(map[string]int, len(tasks))
+
+	var longest int
+	for _, t := range tasks {
+		var d int
+		for _, arg := range t.Args {
+			if arg.TaskID != nil {
+				d = max(d, depth[*arg.TaskID])
+			}
+		}
+		for _, guard := range t.Guards {
+			cond := guard[:strings.LastIndex(guard, ":")]
+			d = max(d, depth[cond])
+		}
+
+		depth[t.ID] = d + 1
+		longest = max(longest, d+1)
+	}
+
+	return longest
+}
+
+// hashCons numbers the nodes of the script so that structurally identical
+// subexpressions get the same number. Parentheses share the number of the
+// enclosed expression and lists are flattened into arguments of calls.
+func hashCons(script *parser.Script) map[parser.Node]int {
+	ids := make(map[parser.Node]int)
+	table := make(map[string]int)
+
+	var number func(node parser.Node) int
+	number = func(node parser.Node) int {
+		var key string
+		switch n := node.(type) {
+		case *parser.NumberLit:
+			key = "n" + n.Raw
+		case *parser.Ident:
+			key = "i" + n.Name
+		case *parser.QuantityExpr:
+			key = fmt.Sprintf("q%s %s", n.X.Raw, n.Unit)
+		case *parser.ParenExpr:
+			ids[node] = number(n.X)
+			return ids[node]
+		case *parser.UnaryExpr:
+			key = fmt.Sprintf("u%s %d", n.Op, number(n.X))
+		case *parser.BinaryExpr:
+			key = fmt.Sprintf("b%s %d %d", n.Op, number(n.X), number(n.Y))
+		case *parser.CallExpr:
+			key = "c" + n.Func
+			for _, arg := range parser.Flatten(n.Args) {
+				key += fmt.Sprintf(" %d", number(arg))
+			}
+		case *parser.ConvertExpr:
+			key = fmt.Sprintf("t%s %d", n.Unit, number(n.X))
+		}
+
+		id, ok := table[key]
+		if !ok {
+			id = len(table) + 1
+			table[key] = id
+		}
+
+		ids[node] = id
+		return id
+	}
+
+	for _, stmt := range script.Stmts {
+		if as, ok := stmt.(*parser.AssignStmt); ok {
+			number(as.X)
+			continue
+		}
+		number(stmt)
+	}
+
+	return ids
+}
 
-	var visit func(expr ast.Expr) *models.Task
-	visit = func(expr ast.Expr) *models.Task {
-		var t *models.Task
-		switch e := expr.(type) {
-		case *ast.BasicLit:
-			val, err := strconv.ParseFloat(e.Value, 64)
+// dimensions checks that quantities of the script are added, compared and
+// passed to functions with matching dimensions and reports the dimension of
+// every node. Exponents of quantities must be integer literals. Values are
+// kept in SI base units, so a conversion may only be the last operation of
+// the script.
+func dimensions(script *parser.Script, vars map[string]float64) (map[parser.Node]units.Dimension, error) {
+	dims := make(map[parser.Node]units.Dimension)
+	scope := make(map[string]units.Dimension)
+
+	result := script.Stmts[len(script.Stmts)-1]
+	for {
+		paren, ok := result.(*parser.ParenExpr)
+		if !ok {
+			break
+		}
+		result = paren.X
+	}
+
+	var dim func(node parser.Node) (units.Dimension, error)
+	dim = func(node parser.Node) (units.Dimension, error) {
+		var d units.Dimension
+		switch n := node.(type) {
+		case *parser.Ident:
+			d = scope[n.Name]
+		case *parser.QuantityExpr:
+			d = n.Unit.Value.Dim
+		case *parser.ParenExpr:
+			x, err := dim(n.X)
 			if err != nil {
-				panic(err)
+				return d, err
 			}
-			t = &models.Task{
-				ID:      fmt.Sprintf("%s:%d", expID, taskID),
-				ExpID:   expID,
-				LeftArg: val,
-				Status:  "ready",
+			d = x
+		case *parser.UnaryExpr:
+			x, err := dim(n.X)
+			if err != nil {
+				return d, err
+			}
+			if (n.Op == "~" || n.Op == "not") && !x.IsZero() {
+				return d, exprError(parser.CodeIncompatibleUnits, n.OpPos, len(n.Op), "operator %q requires a dimensionless operand, got %s", n.Op, x)
+			}
+			d = x
+		case *parser.BinaryExpr:
+			x, err := dim(n.X)
+			if err != nil {
+				return d, err
+			}
+			y, err := dim(n.Y)
+			if err != nil {
+				return d, err
 			}
-			taskID++
 
-		case *ast.BinaryExpr:
-			leftTask := visit(e.X)
-			rightTask := visit(e.Y)
+			switch n.Op {
+			case "+", "-", "%", "//", "<", "<=", ">", ">=", "==", "!=":
+				if x != y {
+					return d, exprError(parser.CodeIncompatibleUnits, n.OpPos, len(n.Op), "operator %q requires operands of the same unit, got %s and %s", n.Op, x, y)
+				}
+				if n.Op == "+" || n.Op == "-" || n.Op == "%" {
+					d = x
+				}
+			case "*":
+				d = x.Mul(y)
+			case "/":
+				d = x.Div(y)
+			case "^":
+				if !y.IsZero() {
+					return d, exprError(parser.CodeIncompatibleUnits, n.Y.Pos(), n.Y.End()-n.Y.Pos(), "exponent must be dimensionless, got %s", y)
+				}
+				if x.IsZero() {
+					break
+				}
+				exp, ok := literal(n.Y, vars)
+				if !ok || exp != math.Trunc(exp) {
+					return d, exprError(parser.CodeIncompatibleUnits, n.Y.Pos(), n.Y.End()-n.Y.Pos(), "exponent of %s must be an integer number", x)
+				}
+				d = x.Pow(int(exp))
+			default:
+				if !x.IsZero() || !y.IsZero() {
+					return d, exprError(parser.CodeIncompatibleUnits, n.OpPos, len(n.Op), "operator %q requires dimensionless operands, got %s and %s", n.Op, x, y)
+				}
+			}
+		case *parser.CallExpr:
+			values := parser.Flatten(n.Args)
+			args := make([]units.Dimension, 0, len(values))
+			for _, arg := range values {
+				a, err := dim(arg)
+				if err != nil {
+					return d, err
+				}
+				args = append(args, a)
+			}
 
-			t = &models.Task{
-				ID:      fmt.Sprintf("%s:%d", expID, taskID),
-				ExpID:   expID,
-				Op:      e.Op.String(),
-				LeftID:  &leftTask.ID,
-				RightID: &rightTask.ID,
+			switch n.Func {
+			case opIf:
+				if !args[0].IsZero() {
+					return d, exprError(parser.CodeIncompatibleUnits, n.Args[0].Pos(), n.Args[0].End()-n.Args[0].Pos(), "condition must be dimensionless, got %s", args[0])
+				}
+				if args[1] != args[2] {
+					return d, exprError(parser.CodeIncompatibleUnits, n.Args[2].Pos(), n.Args[2].End()-n.Args[2].Pos(), "branches of %q must have the same unit, got %s and %s", n.Func, args[1], args[2])
+				}
+				d = args[1]
+			case "sqrt":
+				root, ok := args[0].Root(2)
+				if !ok {
+					return d, exprError(parser.CodeIncompatibleUnits, n.Args[0].Pos(), n.Args[0].End()-n.Args[0].Pos(), "square root of %s is not a unit", args[0])
+				}
+				d = root
+			case "product":
+				for _, a := range args {
+					d = d.Mul(a)
+				}
+			case "abs", "floor", "ceil", "round", "re", "conj", "min", "max", "sum", "avg", "stddev":
+				d = args[0]
+				for i, a := range args[1:] {
+					if n.Func == "round" && !a.IsZero() {
+						return d, exprError(parser.CodeIncompatibleUnits, values[1].Pos(), values[1].End()-values[1].Pos(), "number of digits must be dimensionless, got %s", a)
+					}
+					if n.Func != "round" && a != d {
+						arg := values[i+1]
+						return d, exprError(parser.CodeIncompatibleUnits, arg.Pos(), arg.End()-arg.Pos(), "function %q requires arguments of the same unit, got %s and %s", n.Func, d, a)
+					}
+				}
+			default:
+				for i, a := range args {
+					if !a.IsZero() {
+						arg := values[i]
+						return d, exprError(parser.CodeIncompatibleUnits, arg.Pos(), arg.End()-arg.Pos(), "function %q requires dimensionless arguments, got %s", n.Func, a)
+					}
+				}
+			}
+		case *parser.ConvertExpr:
+			if node != result {
+				return d, exprError(parser.CodeInvalidConversion, n.ToPos, len("to"), "conversion must be the last operation of the expression")
 			}
-			taskID++
+			x, err := dim(n.X)
+			if err != nil {
+				return d, err
+			}
+			if x != n.Unit.Value.Dim {
+				return d, exprError(parser.CodeInvalidConversion, n.ToPos, n.End()-n.ToPos, "cannot convert %s to %s", x, n.Unit)
+			}
+			d = x
+		}
 
-		case *ast.ParenExpr:
-			return visit(e.X)
+		dims[node] = d
+		return d, nil
+	}
+
+	for _, stmt := range script.Stmts {
+		if as, ok := stmt.(*parser.AssignStmt); ok {
+			d, err := dim(as.X)
+			if err != nil {
+				return nil, err
+			}
+			scope[as.Name.Name] = d
+			continue
 		}
 
-		if t.LeftID == nil && t.RightID == nil {
-			t.Status = "ready"
+		_, err := dim(stmt)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		tasks = append(tasks, t)
-		return t
+	return dims, nil
+}
+
+// dependencies counts the arguments of t which wait for other tasks.
+func dependencies(t *models.Task) int {
+	var n int
+	for _, arg := range t.Args {
+		if arg.TaskID != nil {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (s *Service) AddVariable(ctx context.Context, userID string, v *models.Variable) error {
+	if !parser.IsIdent(v.Name) || parser.IsReserved(v.Name) {
+		return fmt.Errorf("%w: name %q is not a valid identifier or is reserved", e.ErrInvalidVariable, v.Name)
 	}
 
-	visit(expr)
+	v.UserID = userID
+
+	err := s.varRepo.AddVariable(ctx, v)
+	if err != nil {
+		return fmt.Errorf("failed to add variable: %w", err)
+	}
 
-	tasks[len(tasks)-1].Final = true
-	return tasks, nil
+	return nil
+}
+
+func (s *Service) GetVariable(ctx context.Context, userID, name string) (*models.Variable, error) {
+	v, err := s.varRepo.GetVariable(ctx, userID, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variable: %w", err)
+	}
+
+	return v, nil
+}
+
+func (s *Service) GetVariables(ctx context.Context, userID string) ([]*models.Variable, error) {
+	vars, err := s.varRepo.GetVariables(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variables: %w", err)
+	}
+
+	return vars, nil
+}
+
+func (s *Service) UpdateVariable(ctx context.Context, userID string, v *models.Variable) error {
+	if !parser.IsIdent(v.Name) || parser.IsReserved(v.Name) {
+		return fmt.Errorf("%w: name %q is not a valid identifier or is reserved", e.ErrInvalidVariable, v.Name)
+	}
+
+	v.UserID = userID
+
+	err := s.varRepo.UpdateVariable(ctx, v)
+	if err != nil {
+		return fmt.Errorf("failed to update variable: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Service) DeleteVariable(ctx context.Context, userID, name string) error {
+	err := s.varRepo.DeleteVariable(ctx, userID, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete variable: %w", err)
+	}
+
+	return nil
 }
 
 func (s *Service) Register(ctx context.Context, creds *models.UserCredentials) error {
@@ -2,9 +2,22 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	agentmodels "github.com/distributed-calc/v1/internal/agent/models"
+	agent "github.com/distributed-calc/v1/internal/agent/service"
+	"github.com/distributed-calc/v1/internal/orchestrator/config"
+	e "github.com/distributed-calc/v1/internal/orchestrator/errors"
 	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"github.com/distributed-calc/v1/internal/orchestrator/parser"
 	"github.com/distributed-calc/v1/test/mock"
 	"github.com/google/uuid"
+	"math"
+	"math/cmplx"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -81,14 +94,69 @@ func TestValidate(t *testing.T) {
 		},
 		{
 			name:    "expression with operators mismatch",
-			exp:     "2++3",
+			exp:     "2*/3",
+			wantErr: true,
+		},
+		{
+			name:    "expression with unary operators",
+			exp:     "2*-3+-(4+5)",
+			wantErr: false,
+		},
+		{
+			name:    "expression with power, modulo and integer division",
+			exp:     "2^3%5//2",
+			wantErr: false,
+		},
+		{
+			name:    "expression with triple slash",
+			exp:     "6///2",
+			wantErr: true,
+		},
+		{
+			name:    "expression with function call",
+			exp:     "sqrt(2)*min(1, 2, 3)",
+			wantErr: false,
+		},
+		{
+			name:    "expression with unknown function",
+			exp:     "foo(2)",
+			wantErr: true,
+		},
+		{
+			name:    "expression with wrong number of arguments",
+			exp:     "sqrt(2, 3)",
+			wantErr: true,
+		},
+		{
+			name:    "expression with function without parenthesis",
+			exp:     "sqrt 2",
+			wantErr: true,
+		},
+		{
+			name:    "expression with dangling sign",
+			exp:     "2*-",
+			wantErr: true,
+		},
+		{
+			name:    "expression with missing operator",
+			exp:     "2 2+3",
+			wantErr: true,
+		},
+		{
+			name:    "expression with unmatched closing parenthesis",
+			exp:     "2+3)",
+			wantErr: true,
+		},
+		{
+			name:    "empty expression",
+			exp:     "   ",
 			wantErr: true,
 		},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			err := validate(tc.exp)
+			_, err := validate(tc.exp, parser.Options{})
 			if tc.wantErr == false && err != nil {
 				t.Errorf("expected no error, got %v", err)
 			}
@@ -99,7 +167,45 @@ func TestValidate(t *testing.T) {
 	}
 }
 
-func TestParseExpression(t *testing.T) {
+// evalTasks executes the task graph with the agent calculator, resolving
+// dependencies the way the repository does, and returns the final result.
+func evalTasks(t *testing.T, tasks []*models.Task) float64 {
+	t.Helper()
+
+	calc := agent.NewService()
+
+	results := make(map[string]float64, len(tasks))
+	for _, task := range tasks {
+		at := &agentmodels.AgentTask{
+			Id:    task.ID,
+			Op:    task.Op,
+			Final: task.Final,
+		}
+
+		for _, arg := range task.Args {
+			if arg.TaskID != nil {
+				at.Args = append(at.Args, results[*arg.TaskID])
+			} else {
+				at.Args = append(at.Args, arg.Value)
+			}
+		}
+
+		res, err := calc.Evaluate(context.Background(), at)
+		if err != nil {
+			t.Fatalf("failed to evaluate task %s: %v", task.ID, err)
+		}
+		results[task.ID] = res.Result
+
+		if task.Final {
+			return res.Result
+		}
+	}
+
+	t.Fatal("no final task")
+	return 0
+}
+
+func TestBuildTasks(t *testing.T) {
 	cases := []struct {
 		name       string
 		expression string
@@ -136,11 +242,113 @@ func TestParseExpression(t *testing.T) {
 			expected:   11,
 			wantErr:    false,
 		},
+		{
+			name:       "expression with left associative operators",
+			expression: "8-4-2",
+			expected:   2,
+			wantErr:    false,
+		},
+		{
+			name:       "expression with explicit signs",
+			expression: "-2*(+3-1)",
+			expected:   -4,
+			wantErr:    false,
+		},
+		{
+			name:       "expression with unary minus after operator",
+			expression: "2*-3",
+			expected:   -6,
+			wantErr:    false,
+		},
+		{
+			name:       "expression with negated parenthesis",
+			expression: "-(4+5)*2",
+			expected:   -18,
+			wantErr:    false,
+		},
+		{
+			name:       "expression with repeated signs",
+			expression: "2--+3",
+			expected:   5,
+			wantErr:    false,
+		},
+		{
+			name:       "expression with right associative power",
+			expression: "2^3^2",
+			expected:   512,
+			wantErr:    false,
+		},
+		{
+			name:       "expression with negated power",
+			expression: "-2^2+2^-1",
+			expected:   -3.5,
+			wantErr:    false,
+		},
+		{
+			name:       "expression with modulo of negative number",
+			expression: "-7%3",
+			expected:   2,
+			wantErr:    false,
+		},
+		{
+			name:       "expression with integer division",
+			expression: "7//2*2+7%2",
+			expected:   7,
+			wantErr:    false,
+		},
+		{
+			name:       "expression with functions",
+			expression: "sqrt(16)+abs(-2)*floor(2.7)-ceil(0.2)",
+			expected:   7,
+			wantErr:    false,
+		},
+		{
+			name:       "expression with variadic function",
+			expression: "max(1, min(7, 3+2, 6), -4)^2",
+			expected:   25,
+			wantErr:    false,
+		},
+		{
+			name:       "expression with nested functions",
+			expression: "round(ln(exp(2))*log10(1000), 1)",
+			expected:   6,
+			wantErr:    false,
+		},
+		{
+			name:       "expression with constants",
+			expression: "round(tau/pi*e, 4)",
+			expected:   5.4366,
+			wantErr:    false,
+		},
+		{
+			name:       "single number",
+			expression: "42",
+			expected:   42,
+			wantErr:    false,
+		},
+		{
+			name:       "script",
+			expression: "a = 2+3; b = a*a; b - a/2",
+			expected:   22.5,
+			wantErr:    false,
+		},
+		{
+			name:       "script with aliased name",
+			expression: "a = 4; b = a; sqrt(b)*-a",
+			expected:   -8,
+			wantErr:    false,
+		},
+		{
+			name:       "script ending with assignment",
+			expression: "r = 2; area = pi*r^2",
+			expected:   4 * math.Pi,
+			wantErr:    false,
+		},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			_, err := parseExpression(tc.expression, tc.name)
+			node, err := validate(tc.expression, parser.Options{})
 			if tc.wantErr == false && err != nil {
 				t.Errorf("expected no error, got %v", err)
 			}
@@ -148,109 +356,1698 @@ func TestParseExpression(t *testing.T) {
 			if tc.wantErr == true && err == nil {
 				t.Error("expected error, got none")
 			}
+
+			if err != nil {
+				return
+			}
+
+			res := evalTasks(t, buildTasks(node, &models.Expression{Id: tc.name}, nil))
+			if math.Abs(res-tc.expected) > 1e-9 {
+				t.Errorf("expected %v, got %v", tc.expected, res)
+			}
 		})
 	}
 }
 
-func TestService_Evaluate(t *testing.T) {
-	repo := mock.NewRepository()
-	s := NewService(nil, repo, repo, nil, nil, nil)
+func TestBuildTasks_FoldsSignedLiterals(t *testing.T) {
+	node, err := validate("-(-3)*-2", parser.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tasks := buildTasks(node, &models.Expression{Id: "fold"}, nil)
+	if len(tasks) != 3 {
+		t.Fatalf("expected 3 tasks, got %d", len(tasks))
+	}
+
+	for _, task := range tasks {
+		if task.Op == opNeg {
+			t.Errorf("unexpected %s task %s", opNeg, task.ID)
+		}
+	}
+}
+
+func TestBuildTasks_FunctionArgs(t *testing.T) {
+	node, err := validate("max(1, 2+3, 4)", parser.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tasks := buildTasks(node, &models.Expression{Id: "call"}, nil)
+
+	final := tasks[len(tasks)-1]
+	if final.Op != "max" || !final.Final {
+		t.Fatalf("expected final max task, got %+v", final)
+	}
+
+	if len(final.Args) != 3 || dependencies(final) != 3 {
+		t.Errorf("expected 3 pending arguments, got %+v", final.Args)
+	}
+
+	if final.Status == "ready" {
+		t.Error("task with pending arguments must not be ready")
+	}
+}
+
+func TestBuildTasks_ReductionTree(t *testing.T) {
+	values := make([]string, 0, 1000)
+	for i := 1; i <= 1000; i++ {
+		values = append(values, strconv.Itoa(i))
+	}
+
+	script, err := validate("sum(["+strings.Join(values, ", ")+"])", parser.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
+	tasks := buildTasks(script, &models.Expression{Id: "sum"}, nil)
+	if len(tasks) != 1999 {
+		t.Fatalf("expected 1000 leaf and 999 addition tasks, got %d", len(tasks))
+	}
+
+	depth := make(map[string]int, len(tasks))
+	for _, task := range tasks {
+		for _, arg := range task.Args {
+			if arg.TaskID != nil {
+				depth[task.ID] = max(depth[task.ID], depth[*arg.TaskID]+1)
+			}
+		}
+	}
+
+	final := tasks[len(tasks)-1]
+	if depth[final.ID] != 10 {
+		t.Errorf("expected 10 rounds of additions, got %d", depth[final.ID])
+	}
+
+	if res := evalTasks(t, tasks); res != 500500 {
+		t.Errorf("expected 500500, got %v", res)
+	}
+}
+
+func TestBuildTasks_CommonSubexpressions(t *testing.T) {
 	cases := []struct {
-		name       string
-		expression string
-		expected   float64
-		wantErr    bool
+		name     string
+		exp      string
+		tasks    int
+		expected float64
 	}{
 		{
-			name:       "valid expression",
-			expression: "2+2",
-			wantErr:    false,
+			name:     "identical operands",
+			exp:      "(1+2)*(1+2)",
+			tasks:    4,
+			expected: 9,
 		},
 		{
-			name:       "invalid expression",
-			expression: "2+2/",
-			wantErr:    true,
+			name:     "nested repetitions",
+			exp:      "sqrt((1+2)*(1+2)) + (1+2)*(1+2)",
+			tasks:    6,
+			expected: 12,
+		},
+		{
+			name:     "expression of a name",
+			exp:      "a = 2+3; (2+3)*a",
+			tasks:    4,
+			expected: 25,
+		},
+		{
+			name:     "names of the same expression",
+			exp:      "a = 2+3; b = 2+3; a*b",
+			tasks:    5,
+			expected: 25,
+		},
+		{
+			name:     "branches are not shared",
+			exp:      "if(1 > 0, 2*3, 1) + 2*3",
+			tasks:    12,
+			expected: 12,
+		},
+		{
+			name:     "different operators",
+			exp:      "(1+2)*(1-2)",
+			tasks:    7,
+			expected: -3,
 		},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			_, err := s.Evaluate(context.Background(), tc.expression, "")
-			if tc.wantErr == false && err != nil {
-				t.Errorf("expected no error, got %v", err)
+			script, err := validate(tc.exp, parser.Options{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
 			}
 
-			if tc.wantErr == true && err == nil {
-				t.Error("expected error, got none")
+			tasks := buildTasks(script, &models.Expression{Id: "cse"}, nil)
+			if len(tasks) != tc.tasks {
+				t.Fatalf("expected %d tasks, got %d", tc.tasks, len(tasks))
+			}
+
+			if !strings.Contains(tc.exp, "if") {
+				if res := evalTasks(t, tasks); res != tc.expected {
+					t.Errorf("expected %v, got %v", tc.expected, res)
+				}
 			}
 		})
 	}
 }
 
-func TestService_Get(t *testing.T) {
+func TestService_Evaluate_CommonSubexpressions(t *testing.T) {
 	repo := mock.NewRepository()
-	s := NewService(nil, repo, repo, nil, nil, nil)
+	s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
 
-	found := uuid.NewString()
+	ctx := context.Background()
+
+	err := s.AddVariable(ctx, "user", &models.Variable{Name: "x", Value: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: "(x+1)*(x+1) - sqrt((x+1)*(x+1))"}, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if dispatched := runTasks(t, s); dispatched != 4 {
+		t.Errorf("expected 4 dispatched tasks, got %d", dispatched)
+	}
+
+	exp, err := s.Get(ctx, id, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp.Status != StatusCompleted || exp.Result != 6 {
+		t.Errorf("expected 6, got %v (%s)", exp.Result, exp.Status)
+	}
+}
 
+func TestBuildTasks_Rebalance(t *testing.T) {
 	cases := []struct {
-		name    string
-		id      string
-		wantErr bool
+		name     string
+		exp      string
+		expected float64
+		depth    int
 	}{
 		{
-			name:    "success",
-			id:      found,
-			wantErr: false,
+			name:     "long sum",
+			exp:      "1+2+3+4+5+6+7+8+9+10+11+12+13+14+15+16",
+			expected: 136,
+			depth:    5,
 		},
 		{
-			name:    "expression not found",
-			id:      uuid.NewString(),
-			wantErr: true,
+			name:     "differences",
+			exp:      "10 - 2 + 3 - 4 * 2 - 1",
+			expected: 2,
+			depth:    5,
+		},
+		{
+			name:     "quotients",
+			exp:      "2 * 3 / 4 * 8 / 6",
+			expected: 2,
+			depth:    4,
+		},
+		{
+			name:     "repeated division",
+			exp:      "64 / 2 / 2 / 2 / 2",
+			expected: 4,
+			depth:    4,
+		},
+		{
+			name:     "negative first term",
+			exp:      "-1 - 2 - 3 - 4",
+			expected: -10,
+			depth:    4,
+		},
+		{
+			name:     "parentheses end chains",
+			exp:      "1 - (2 - 3) - (4 + 5 + 6 + 7)",
+			expected: -20,
+			depth:    5,
 		},
-	}
-
-	err := s.expRepo.Add(context.Background(), &models.Expression{
-		Id:     found,
-		Status: "testing",
-		Result: 0,
-	})
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			_, err := s.Get(context.Background(), tc.id, "")
-			if tc.wantErr == false && err != nil {
-				t.Errorf("expected no error, got %v", err)
+			script, err := validate(tc.exp, parser.Options{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
 			}
 
-			if tc.wantErr == true && err == nil {
-				t.Error("expected error, got none")
+			tasks := buildTasks(script, &models.Expression{Id: "balance", Mode: ModeFloat, Rebalance: true}, nil)
+
+			if res := evalTasks(t, tasks); math.Abs(res-tc.expected) > 1e-9 {
+				t.Errorf("expected %v, got %v", tc.expected, res)
+			}
+
+			if depth := criticalPath(tasks); depth != tc.depth {
+				t.Errorf("expected critical path of %d tasks, got %d", tc.depth, depth)
 			}
 		})
 	}
 }
 
-func TestService_GetAll(t *testing.T) {
-	repo := mock.NewRepository()
-	s := NewService(nil, repo, repo, nil, nil, nil)
+func TestService_Evaluate_Rebalance(t *testing.T) {
+	terms := make([]string, 0, 100)
+	for i := 1; i <= 100; i++ {
+		terms = append(terms, strconv.Itoa(i))
+	}
 
-	exp := &models.Expression{
-		Id:     uuid.NewString(),
-		Status: "testing",
-		Result: 0,
+	cases := []struct {
+		name      string
+		exp       string
+		rebalance *bool
+		expected  float64
+		path      int
+	}{
+		{
+			name:     "balanced",
+			exp:      strings.Join(terms, "+"),
+			expected: 5050,
+			path:     7,
+		},
+		{
+			name:      "left to right",
+			exp:       strings.Join(terms, "+"),
+			rebalance: new(bool),
+			expected:  5050,
+			path:      99,
+		},
+		{
+			name:     "balanced float rounding",
+			exp:      "10000000000000000 + 1 + 1 + 1",
+			expected: 10000000000000002,
+			path:     2,
+		},
+		{
+			name:      "left to right float rounding",
+			exp:       "10000000000000000 + 1 + 1 + 1",
+			rebalance: new(bool),
+			expected:  10000000000000000,
+			path:      3,
+		},
 	}
 
-	err := s.expRepo.Add(context.Background(), exp)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := mock.NewRepository()
+			s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+			ctx := context.Background()
+
+			id, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: tc.exp, Rebalance: tc.rebalance}, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			runTasks(t, s)
+
+			exp, err := s.Get(ctx, id, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if exp.Result != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, exp.Result)
+			}
+
+			if exp.CriticalPath != tc.path {
+				t.Errorf("expected critical path of %d tasks, got %d", tc.path, exp.CriticalPath)
+			}
+		})
 	}
+}
 
-	_, err = s.GetAll(context.Background(), "", "", 10)
+func TestBuildTasks_FanOut(t *testing.T) {
+	script, err := validate("a = 2+3; b = a*a; b - a/2", parser.Options{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+
+	tasks := buildTasks(script, &models.Expression{Id: "fan"}, nil)
+	if len(tasks) != 7 {
+		t.Fatalf("expected 7 tasks, got %d", len(tasks))
+	}
+
+	names := make(map[string]*models.Task)
+	for _, task := range tasks {
+		if task.Name != "" {
+			names[task.Name] = task
+		}
+	}
+
+	a, b := names["a"], names["b"]
+	if a == nil || a.Op != "+" || b == nil || b.Op != "*" {
+		t.Fatalf("expected named tasks a and b, got %v", names)
+	}
+
+	var consumers int
+	for _, task := range tasks {
+		for _, arg := range task.Args {
+			if arg.TaskID != nil && *arg.TaskID == a.ID {
+				consumers++
+			}
+		}
+	}
+
+	if consumers != 3 {
+		t.Errorf("expected result of a to be passed to 3 arguments, got %d", consumers)
+	}
+}
+
+// runTasks executes ready tasks of the service with the agent calculator
+// until none are left.
+func runTasks(t *testing.T, s *Service) int {
+	t.Helper()
+
+	ctx := context.Background()
+	calc := agent.NewService()
+
+	for dispatched := 0; ; dispatched++ {
+		task, err := s.GetTask(ctx, "agent")
+		if errors.Is(err, e.ErrNoTasks) {
+			return dispatched
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		at := &agentmodels.AgentTask{Id: task.Id, Args: task.Args, Op: task.Op, Final: task.Final}
+		for _, n := range task.Numbers {
+			num := agentmodels.Number{Decimal: n.Decimal, Integer: n.Integer}
+			if n.Rational != nil {
+				num.Rational = &agentmodels.Rational{Num: n.Rational.Num, Den: n.Rational.Den}
+			}
+			if n.Complex != nil {
+				num.Complex = &agentmodels.Complex{Re: n.Complex.Re, Im: n.Complex.Im}
+			}
+			at.Numbers = append(at.Numbers, num)
+		}
+		if task.Precision != nil {
+			at.Precision = &agentmodels.Precision{Scale: task.Precision.Scale, Rounding: task.Precision.Rounding}
+		}
+
+		res, err := calc.Evaluate(context.Background(), at)
+		if err != nil {
+			t.Fatalf("failed to evaluate task %s: %v", task.Id, err)
+		}
+
+		tr := &models.TaskResult{Id: res.Id, Result: res.Result, Status: res.Status, Final: res.Final}
+		if res.Number != nil {
+			tr.Number = &models.Number{Decimal: res.Number.Decimal, Integer: res.Number.Integer}
+			if res.Number.Rational != nil {
+				tr.Number.Rational = &models.Rational{Num: res.Number.Rational.Num, Den: res.Number.Rational.Den}
+			}
+			if res.Number.Complex != nil {
+				tr.Number.Complex = &models.Complex{Re: res.Number.Complex.Re, Im: res.Number.Complex.Im}
+			}
+		}
+
+		err = s.FinishTask(ctx, tr)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestService_Evaluate_Script(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+	ctx := context.Background()
+
+	id, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: "a = 2+3; b = a*a; b - a/2"}, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	runTasks(t, s)
+
+	exp, err := s.Get(ctx, id, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp.Status != StatusCompleted || exp.Result != 22.5 {
+		t.Errorf("expected completed expression with result 22.5, got %s %v", exp.Status, exp.Result)
+	}
+
+	if len(exp.Values) != 2 || exp.Values["a"] != 5 || exp.Values["b"] != 25 {
+		t.Errorf("expected values map[a:5 b:25], got %v", exp.Values)
+	}
+}
+
+func TestService_Evaluate_Decimal(t *testing.T) {
+	cases := []struct {
+		name     string
+		req      *models.CalculateRequest
+		expected string
+		wantErr  error
+	}{
+		{
+			name:     "exact sum",
+			req:      &models.CalculateRequest{Expression: "0.1+0.2", Mode: ModeDecimal},
+			expected: "0.3",
+		},
+		{
+			name: "scale and rounding",
+			req: &models.CalculateRequest{
+				Expression: "-(10/3) + rate",
+				Mode:       ModeDecimal,
+				Precision:  &models.Precision{Scale: 4, Rounding: "floor"},
+			},
+			expected: "-3.3233",
+		},
+		{
+			name:     "script",
+			req:      &models.CalculateRequest{Expression: "a = 1.005; round(a*a, 2)", Mode: ModeDecimal},
+			expected: "1.01",
+		},
+		{
+			name:    "unknown mode",
+			req:     &models.CalculateRequest{Expression: "1", Mode: "octonion"},
+			wantErr: e.ErrInvalidMode,
+		},
+		{
+			name:    "unknown rounding",
+			req:     &models.CalculateRequest{Expression: "1", Mode: ModeDecimal, Precision: &models.Precision{Rounding: "banker"}},
+			wantErr: e.ErrInvalidMode,
+		},
+		{
+			name:    "scale out of range",
+			req:     &models.CalculateRequest{Expression: "1", Mode: ModeDecimal, Precision: &models.Precision{Scale: -1}},
+			wantErr: e.ErrInvalidMode,
+		},
+		{
+			name:    "precision in float mode",
+			req:     &models.CalculateRequest{Expression: "1", Precision: &models.Precision{Scale: 2}},
+			wantErr: e.ErrInvalidMode,
+		},
+		{
+			name:    "unsupported function",
+			req:     &models.CalculateRequest{Expression: "sqrt(2) + sin(1)", Mode: ModeDecimal},
+			wantErr: e.ErrInvalidExpression,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := mock.NewRepository()
+			s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+			ctx := context.Background()
+
+			err := s.AddVariable(ctx, "user", &models.Variable{Name: "rate", Value: 0.01})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			id, err := s.Evaluate(ctx, tc.req, "user")
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			runTasks(t, s)
+
+			exp, err := s.Get(ctx, id, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if exp.Status != StatusCompleted || exp.Decimal != tc.expected {
+				t.Errorf("expected completed expression with decimal %s, got %s %q", tc.expected, exp.Status, exp.Decimal)
+			}
+		})
+	}
+}
+
+func TestService_Evaluate_Rational(t *testing.T) {
+	cases := []struct {
+		name     string
+		exp      string
+		fraction string
+		decimal  string
+		wantErr  error
+	}{
+		{
+			name:     "fraction",
+			exp:      "1/3 + 2",
+			fraction: "7/3",
+			decimal:  "2.33333333333333333333",
+		},
+		{
+			name:     "decimal literals",
+			exp:      "0.1 + 0.2 - rate",
+			fraction: "3/20",
+			decimal:  "0.15",
+		},
+		{
+			name:     "integer power",
+			exp:      "a = 2/3; a^-3 // 1",
+			fraction: "3",
+			decimal:  "3",
+		},
+		{
+			name:    "irrational constant",
+			exp:     "pi/2",
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:    "irrational function",
+			exp:     "sqrt(4)",
+			wantErr: e.ErrInvalidExpression,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := mock.NewRepository()
+			s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+			ctx := context.Background()
+
+			err := s.AddVariable(ctx, "user", &models.Variable{Name: "rate", Value: 0.15})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			id, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: tc.exp, Mode: ModeRational}, "user")
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			runTasks(t, s)
+
+			exp, err := s.Get(ctx, id, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if exp.Fraction != tc.fraction || exp.Decimal != tc.decimal {
+				t.Errorf("expected %s (%s), got %s (%s)", tc.fraction, tc.decimal, exp.Fraction, exp.Decimal)
+			}
+		})
+	}
+}
+
+func TestService_Evaluate_Complex(t *testing.T) {
+	cases := []struct {
+		name     string
+		req      *models.CalculateRequest
+		expected complex128
+		wantErr  error
+	}{
+		{
+			name:     "complex literal",
+			req:      &models.CalculateRequest{Expression: "(3+4i)*-i"},
+			expected: 4 - 3i,
+		},
+		{
+			name:     "square root of negative number",
+			req:      &models.CalculateRequest{Expression: "sqrt(-4)", Mode: ModeComplex},
+			expected: 2i,
+		},
+		{
+			name:     "functions",
+			req:      &models.CalculateRequest{Expression: "z = 3+4i; abs(z) + im(conj(z))*i"},
+			expected: 5 - 4i,
+		},
+		{
+			name:    "unsupported function",
+			req:     &models.CalculateRequest{Expression: "floor(2i)"},
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:    "unsupported operator",
+			req:     &models.CalculateRequest{Expression: "i % 2"},
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:    "complex number in exact mode",
+			req:     &models.CalculateRequest{Expression: "1 + 2i", Mode: ModeDecimal},
+			wantErr: e.ErrInvalidExpression,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := mock.NewRepository()
+			s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+			ctx := context.Background()
+
+			id, err := s.Evaluate(ctx, tc.req, "user")
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			runTasks(t, s)
+
+			exp, err := s.Get(ctx, id, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if exp.Mode != ModeComplex || exp.Complex == nil {
+				t.Fatalf("expected complex result, got %s %v", exp.Mode, exp.Complex)
+			}
+
+			got := complex(exp.Complex.Re, exp.Complex.Im)
+			if cmplx.Abs(got-tc.expected) > 1e-9 || exp.Result != exp.Complex.Re {
+				t.Errorf("expected %v, got %v (result %v)", tc.expected, got, exp.Result)
+			}
+		})
+	}
+}
+
+func TestService_Evaluate(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+	cases := []struct {
+		name       string
+		expression string
+		expected   float64
+		wantErr    bool
+	}{
+		{
+			name:       "valid expression",
+			expression: "2+2",
+			wantErr:    false,
+		},
+		{
+			name:       "invalid expression",
+			expression: "2+2/",
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := s.Evaluate(context.Background(), &models.CalculateRequest{Expression: tc.expression}, "")
+			if tc.wantErr == false && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+
+			if tc.wantErr == true && err == nil {
+				t.Error("expected error, got none")
+			}
+		})
+	}
+}
+
+func TestService_GetTask_Concurrent(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+	ctx := context.Background()
+
+	terms := make([]string, 0, 100)
+	for i := range 100 {
+		terms = append(terms, fmt.Sprintf("(%d + 1)", i))
+	}
+
+	optimize := false
+	_, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: strings.Join(terms, " * "), Optimize: &optimize}, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var (
+		mu      sync.Mutex
+		claimed = make(map[string]string)
+		wg      sync.WaitGroup
+	)
+
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			claimant := fmt.Sprintf("agent-%d", i)
+			for {
+				task, err := s.GetTask(ctx, claimant)
+				if errors.Is(err, e.ErrNoTasks) {
+					return
+				}
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+
+				mu.Lock()
+				if other, ok := claimed[task.Id]; ok {
+					t.Errorf("task %s claimed by %s and %s", task.Id, other, claimant)
+				}
+				claimed[task.Id] = claimant
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// Leaves of both operands of every sum are ready.
+	if len(claimed) != 200 {
+		t.Errorf("expected 200 claimed tasks, got %d", len(claimed))
+	}
+
+	// Finishing a task must not make claimed tasks ready again.
+	var finished string
+	for id := range claimed {
+		finished = id
+		break
+	}
+
+	err = s.FinishTask(ctx, &models.TaskResult{Id: finished, Result: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for id, claimant := range claimed {
+		if id == finished {
+			continue
+		}
+
+		task := repo.Task(id)
+		if task.Status != "in_progress" || task.ClaimedBy != claimant {
+			t.Errorf("expected task %s in progress by %s, got %s by %s", id, claimant, task.Status, task.ClaimedBy)
+		}
+	}
+}
+
+func TestService_Evaluate_Variables(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+	ctx := context.Background()
+
+	err := s.AddVariable(ctx, "user", &models.Variable{Name: "rate", Value: 0.07})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = s.AddVariable(ctx, "other", &models.Variable{Name: "months", Value: 12})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: "1000*(1+rate)^2-pi"}, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exp, err := s.Get(ctx, id, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(exp.Variables) != 1 || exp.Variables["rate"] != 0.07 {
+		t.Errorf("expected bound variables map[rate:0.07], got %v", exp.Variables)
+	}
+
+	if exp.Expression != "1000*(1+rate)^2-pi" {
+		t.Errorf("expected expression to be stored, got %q", exp.Expression)
+	}
+
+	_, err = s.Evaluate(ctx, &models.CalculateRequest{Expression: "months*rate"}, "user")
+	if !errors.Is(err, e.ErrInvalidExpression) {
+		t.Errorf("expected %v for variable of another user, got %v", e.ErrInvalidExpression, err)
+	}
+
+	_, err = s.Evaluate(ctx, &models.CalculateRequest{Expression: "2 * rat"}, "user")
+
+	var perr *parser.Error
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected *parser.Error, got %v", err)
+	}
+
+	if perr.Code != parser.CodeUndefinedName || perr.Offset != 4 || perr.Length != 3 || perr.Hint != `did you mean "rate"?` {
+		t.Errorf("expected misspelled variable at offset 4 with a suggestion, got %s at %d+%d with hint %q", perr.Code, perr.Offset, perr.Length, perr.Hint)
+	}
+}
+
+func TestService_AddVariable(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(nil, repo, repo, nil, repo, nil, nil)
+
+	cases := []struct {
+		name    string
+		v       *models.Variable
+		wantErr error
+	}{
+		{
+			name: "valid name",
+			v:    &models.Variable{Name: "rate", Value: 0.07},
+		},
+		{
+			name:    "duplicate name",
+			v:       &models.Variable{Name: "rate", Value: 0.08},
+			wantErr: e.ErrVariableAlreadyExists,
+		},
+		{
+			name:    "constant name",
+			v:       &models.Variable{Name: "pi", Value: 3},
+			wantErr: e.ErrInvalidVariable,
+		},
+		{
+			name:    "function name",
+			v:       &models.Variable{Name: "sqrt", Value: 3},
+			wantErr: e.ErrInvalidVariable,
+		},
+		{
+			name:    "invalid identifier",
+			v:       &models.Variable{Name: "2x", Value: 3},
+			wantErr: e.ErrInvalidVariable,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := s.AddVariable(context.Background(), "user", tc.v)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestService_UpdateVariable(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(nil, repo, repo, nil, repo, nil, nil)
+
+	err := s.AddVariable(context.Background(), "user", &models.Variable{Name: "rate", Value: 0.07})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		v       *models.Variable
+		wantErr error
+	}{
+		{
+			name: "valid name",
+			v:    &models.Variable{Name: "rate", Value: 0.08},
+		},
+		{
+			name:    "constant name",
+			v:       &models.Variable{Name: "pi", Value: 3},
+			wantErr: e.ErrInvalidVariable,
+		},
+		{
+			name:    "function name",
+			v:       &models.Variable{Name: "sqrt", Value: 3},
+			wantErr: e.ErrInvalidVariable,
+		},
+		{
+			name:    "invalid identifier",
+			v:       &models.Variable{Name: "2x", Value: 3},
+			wantErr: e.ErrInvalidVariable,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := s.UpdateVariable(context.Background(), "user", tc.v)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestService_Get(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(nil, repo, repo, nil, repo, nil, nil)
+
+	found := uuid.NewString()
+
+	cases := []struct {
+		name    string
+		id      string
+		userID  string
+		wantErr bool
+	}{
+		{
+			name:    "success",
+			id:      found,
+			userID:  "user",
+			wantErr: false,
+		},
+		{
+			name:    "expression not found",
+			id:      uuid.NewString(),
+			userID:  "user",
+			wantErr: true,
+		},
+		{
+			name:    "expression of another user",
+			id:      found,
+			userID:  "other",
+			wantErr: true,
+		},
+	}
+
+	err := s.expRepo.Add(context.Background(), &models.Expression{
+		Id:     found,
+		UserID: "user",
+		Status: "testing",
+		Result: 0,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := s.Get(context.Background(), tc.id, tc.userID)
+			if tc.wantErr == false && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+
+			if tc.wantErr == true && err == nil {
+				t.Error("expected error, got none")
+			}
+		})
+	}
+}
+
+func TestService_GetAll(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(nil, repo, repo, nil, repo, nil, nil)
+
+	exp := &models.Expression{
+		Id:     uuid.NewString(),
+		Status: "testing",
+		Result: 0,
+	}
+
+	err := s.expRepo.Add(context.Background(), exp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = s.GetAll(context.Background(), "", "", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestService_Evaluate_Integer(t *testing.T) {
+	cases := []struct {
+		name     string
+		base     int
+		exp      string
+		expected string
+		wantErr  error
+	}{
+		{
+			name:     "beyond float precision",
+			exp:      "2^64 + 1",
+			expected: "18446744073709551617",
+		},
+		{
+			name:     "bitwise operators",
+			exp:      "mask = 0xff; (0b1010 << 4 | 3) & ~mask xor 1 << 9",
+			expected: "512",
+		},
+		{
+			name:     "result in base 16",
+			base:     16,
+			exp:      "-(0xff + n)",
+			expected: "-10b",
+		},
+		{
+			name:     "result in base 2",
+			base:     2,
+			exp:      "abs(-5) // 2 + 7 % 3",
+			expected: "11",
+		},
+		{
+			name:    "fractional literal",
+			exp:     "1.5 + 1",
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:    "fractional variable",
+			exp:     "1 + rate",
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:    "true division",
+			exp:     "7 / 2",
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:    "constant",
+			exp:     "e + 1",
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:    "unsupported base",
+			base:    37,
+			exp:     "1",
+			wantErr: e.ErrInvalidMode,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := mock.NewRepository()
+			s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+			ctx := context.Background()
+
+			for name, val := range map[string]float64{"n": 12, "rate": 0.15} {
+				err := s.AddVariable(ctx, "user", &models.Variable{Name: name, Value: val})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			id, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: tc.exp, Mode: ModeInteger, Base: tc.base}, "user")
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			runTasks(t, s)
+
+			exp, err := s.Get(ctx, id, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if exp.Integer != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, exp.Integer)
+			}
+		})
+	}
+}
+
+func TestService_Evaluate_BitwiseInFloatMode(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+	for _, exp := range []string{"1 & 2", "~1", "1 << 2"} {
+		_, err := s.Evaluate(context.Background(), &models.CalculateRequest{Expression: exp}, "user")
+		if !errors.Is(err, e.ErrInvalidExpression) {
+			t.Errorf("%s: expected %v, got %v", exp, e.ErrInvalidExpression, err)
+		}
+	}
+}
+
+func TestService_Evaluate_Units(t *testing.T) {
+	cases := []struct {
+		name     string
+		exp      string
+		expected float64
+		unit     string
+		wantErr  error
+	}{
+		{
+			name:     "speeds",
+			exp:      "3 m * 2 s^-1 + 36 km/h",
+			expected: 16,
+			unit:     "m/s",
+		},
+		{
+			name:     "conversion",
+			exp:      "(1 mi + 1 ft) to km",
+			expected: 1.6096488,
+			unit:     "km",
+		},
+		{
+			name:     "derived unit",
+			exp:      "f = 2 kg * 9 m/s^2; f * 10 m to kJ",
+			expected: 0.18,
+			unit:     "kJ",
+		},
+		{
+			name:     "square root of area",
+			exp:      "sqrt(4 m^2) + 1 cm",
+			expected: 2.01,
+			unit:     "m",
+		},
+		{
+			name:     "ratio is dimensionless",
+			exp:      "1 h / 30 min",
+			expected: 2,
+		},
+		{
+			name:    "incompatible addition",
+			exp:     "1 m + 1 s",
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:    "incompatible conversion",
+			exp:     "1 m to s",
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:    "conversion inside expression",
+			exp:     "(1 km to m) * 2",
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:    "function of quantity",
+			exp:     "sin(1 m)",
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:    "fractional power of quantity",
+			exp:     "(1 m)^0.5",
+			wantErr: e.ErrInvalidExpression,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := mock.NewRepository()
+			s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+			ctx := context.Background()
+
+			id, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: tc.exp}, "user")
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			runTasks(t, s)
+
+			exp, err := s.Get(ctx, id, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if math.Abs(exp.Result-tc.expected) > 1e-9 || exp.Unit != tc.unit {
+				t.Errorf("expected %v %s, got %v %s", tc.expected, tc.unit, exp.Result, exp.Unit)
+			}
+		})
+	}
+}
+
+func TestService_Evaluate_UnitsInExactMode(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+	_, err := s.Evaluate(context.Background(), &models.CalculateRequest{Expression: "1 m + 2 m", Mode: ModeDecimal}, "user")
+	if !errors.Is(err, e.ErrInvalidExpression) {
+		t.Errorf("expected %v, got %v", e.ErrInvalidExpression, err)
+	}
+}
+
+func TestBuildTasks_Conditional(t *testing.T) {
+	script, err := validate("if(x > 0, 1/x, -1)", parser.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tasks := buildTasks(script, &models.Expression{Id: "if", Variables: map[string]float64{"x": 2}}, nil)
+	if len(tasks) != 8 {
+		t.Fatalf("expected 8 tasks, got %d", len(tasks))
+	}
+
+	cond, ifTask := tasks[2], tasks[7]
+	if cond.Op != ">" || !cond.Condition || ifTask.Op != opIf || !ifTask.Final {
+		t.Fatalf("expected condition and conditional tasks, got %+v and %+v", cond, ifTask)
+	}
+
+	for _, task := range tasks[3:7] {
+		if task.Status == "ready" {
+			t.Errorf("expected task %s of a branch not to be ready", task.ID)
+		}
+	}
+
+	then, els := tasks[5], tasks[6]
+	if !slices.Equal(then.Guards, []string{models.BranchGuard(cond.ID, true)}) {
+		t.Errorf("expected 'then' branch to be guarded, got %v", then.Guards)
+	}
+
+	if !slices.Equal(els.Guards, []string{models.BranchGuard(cond.ID, false)}) {
+		t.Errorf("expected 'else' branch to be guarded, got %v", els.Guards)
+	}
+
+	if *ifTask.Args[0].TaskID != cond.ID || *ifTask.Args[1].TaskID != then.ID || *ifTask.Args[2].TaskID != els.ID {
+		t.Errorf("unexpected arguments of conditional %+v", ifTask.Args)
+	}
+}
+
+func TestService_Evaluate_Conditional(t *testing.T) {
+	cases := []struct {
+		name       string
+		exp        string
+		expected   float64
+		dispatched int
+		wantErr    error
+	}{
+		{
+			name:       "then branch",
+			exp:        "if(x > 0, 1/x, 1/0)",
+			expected:   0.5,
+			dispatched: 7,
+		},
+		{
+			name:       "else branch",
+			exp:        "if(x == 2 and not x > 0, 1/0, x^2)",
+			expected:   4,
+			dispatched: 12,
+		},
+		{
+			name:       "nested conditionals",
+			exp:        "c = x >= 3; if(c, if(x > 10, 1, 2), if(x != 2, 3, 4) * 10)",
+			expected:   40,
+			dispatched: 11,
+		},
+		{
+			name:       "comparison results",
+			exp:        "(1 < 2) + (2 <= 1) + (3 == 3) + (1 or 0)",
+			expected:   3,
+			dispatched: 15,
+		},
+		{
+			name:       "units",
+			exp:        "if(2 km > 1 mi, 1 m, 2 mm) to cm",
+			expected:   100,
+			dispatched: 6,
+		},
+		{
+			name:    "chained comparison",
+			exp:     "1 < x < 3",
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:    "branches of different units",
+			exp:     "if(x > 1, 1 m, 1 s)",
+			wantErr: e.ErrInvalidExpression,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := mock.NewRepository()
+			s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+			ctx := context.Background()
+
+			err := s.AddVariable(ctx, "user", &models.Variable{Name: "x", Value: 2})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Counts are of unoptimized graphs, where every literal is a task.
+			optimize := false
+			id, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: tc.exp, Optimize: &optimize}, "user")
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			dispatched := runTasks(t, s)
+
+			exp, err := s.Get(ctx, id, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if exp.Status != StatusCompleted || math.Abs(exp.Result-tc.expected) > 1e-9 {
+				t.Errorf("expected %v, got %v (%s)", tc.expected, exp.Result, exp.Status)
+			}
+
+			if dispatched != tc.dispatched {
+				t.Errorf("expected %d dispatched tasks, got %d", tc.dispatched, dispatched)
+			}
+		})
+	}
+}
+
+func TestService_Evaluate_ConditionalLiteral(t *testing.T) {
+	cases := []struct {
+		exp      string
+		expected float64
+	}{
+		{exp: "if(1, if(0, 1, 2), 3)", expected: 2},
+		{exp: "if(0, 3, if(0, 1, 2))", expected: 2},
+		{exp: "if(1, 1 + if(0, 1, 2), 3)", expected: 3},
+		{exp: "a = if(1, 2, 3); a + 1", expected: 3},
+		{exp: "c = 0; if(c, 1, 2)", expected: 2},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.exp, func(t *testing.T) {
+			repo := mock.NewRepository()
+			s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+			ctx := context.Background()
+
+			id, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: tc.exp}, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			runTasks(t, s)
+
+			exp, err := s.Get(ctx, id, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if exp.Status != StatusCompleted || exp.Result != tc.expected {
+				t.Errorf("expected %v, got %v (%s %s)", tc.expected, exp.Result, exp.Status, exp.Error)
+			}
+		})
+	}
+}
+
+func TestService_Evaluate_ConditionalExact(t *testing.T) {
+	repo := mock.NewRepository()
+	s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+	ctx := context.Background()
+
+	id, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: "if(1/3 < 0.333, 1, 1/7)", Mode: ModeRational}, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	runTasks(t, s)
+
+	exp, err := s.Get(ctx, id, "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp.Fraction != "1/7" {
+		t.Errorf("expected 1/7, got %s", exp.Fraction)
+	}
+}
+
+func TestService_Evaluate_Aggregates(t *testing.T) {
+	cases := []struct {
+		name        string
+		exp         string
+		mode        string
+		compensated bool
+		expected    float64
+		exact       string
+		unit        string
+		wantErr     error
+	}{
+		{
+			name:     "sum of arguments and a list",
+			exp:      "sum(1, 2, 3, [4, 5])",
+			expected: 15,
+		},
+		{
+			name:     "product",
+			exp:      "product([1, 2, 3, 4])",
+			expected: 24,
+		},
+		{
+			name:     "average",
+			exp:      "avg([2, 4, 9])",
+			expected: 5,
+		},
+		{
+			name:     "standard deviation",
+			exp:      "stddev([2, 4, 4, 4, 5, 5, 7, 9])",
+			expected: 2,
+		},
+		{
+			name:     "single value",
+			exp:      "x = 7; stddev(x) + avg(x)",
+			expected: 7,
+		},
+		{
+			name:     "uncompensated sum loses small addends",
+			exp:      "sum([10000000000000000, 1, -10000000000000000])",
+			expected: 0,
+		},
+		{
+			name:        "compensated sum",
+			exp:         "sum([10000000000000000, 1, -10000000000000000])",
+			compensated: true,
+			expected:    1,
+		},
+		{
+			name:     "sum of quantities",
+			exp:      "sum([1 m, 20 cm], 3 mm)",
+			expected: 1.203,
+			unit:     "m",
+		},
+		{
+			name:     "product of quantities",
+			exp:      "product(2 m, 3 m)",
+			expected: 6,
+			unit:     "m^2",
+		},
+		{
+			name:    "average of different units",
+			exp:     "avg(1 m, 1 s)",
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:  "decimal average",
+			exp:   "avg(0.1, 0.2)",
+			mode:  ModeDecimal,
+			exact: "0.15",
+		},
+		{
+			name:  "rational average",
+			exp:   "avg(1, 2, 1/3)",
+			mode:  ModeRational,
+			exact: "10/9",
+		},
+		{
+			name:  "integer sum and product",
+			exp:   "sum([1, 2, 3]) * product(2^40, 2^40)",
+			mode:  ModeInteger,
+			exact: "7253554917687775048237056",
+		},
+		{
+			name:    "standard deviation in rational mode",
+			exp:     "stddev(1, 2)",
+			mode:    ModeRational,
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:        "compensated sum in decimal mode",
+			exp:         "sum(1, 2)",
+			mode:        ModeDecimal,
+			compensated: true,
+			wantErr:     e.ErrInvalidMode,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := mock.NewRepository()
+			s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+			ctx := context.Background()
+
+			req := &models.CalculateRequest{Expression: tc.exp, Mode: tc.mode, Compensated: tc.compensated}
+			id, err := s.Evaluate(ctx, req, "user")
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			runTasks(t, s)
+
+			exp, err := s.Get(ctx, id, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			switch tc.mode {
+			case ModeDecimal:
+				if exp.Decimal != tc.exact {
+					t.Errorf("expected %s, got %s", tc.exact, exp.Decimal)
+				}
+			case ModeRational:
+				if exp.Fraction != tc.exact {
+					t.Errorf("expected %s, got %s", tc.exact, exp.Fraction)
+				}
+			case ModeInteger:
+				if exp.Integer != tc.exact {
+					t.Errorf("expected %s, got %s", tc.exact, exp.Integer)
+				}
+			default:
+				if math.Abs(exp.Result-tc.expected) > 1e-9 || exp.Unit != tc.unit {
+					t.Errorf("expected %v %s, got %v %s", tc.expected, tc.unit, exp.Result, exp.Unit)
+				}
+			}
+		})
+	}
+}
+
+func TestService_Evaluate_NumberSyntax(t *testing.T) {
+	cases := []struct {
+		name     string
+		exp      string
+		mode     string
+		locale   string
+		expected float64
+		exact    string
+		wantErr  error
+	}{
+		{
+			name:     "scientific notation",
+			exp:      "6.02e23 / 2E22",
+			expected: 30.1,
+		},
+		{
+			name:     "digit separators",
+			exp:      "1_000 + 1\u2009000",
+			expected: 2000,
+		},
+		{
+			name:     "decimal comma",
+			exp:      "max(3,14, 2) * 2",
+			locale:   "de",
+			expected: 6.28,
+		},
+		{
+			name:     "decimal comma with region",
+			exp:      "1\u202f000,5 + 0,5",
+			locale:   "fr_FR",
+			expected: 1001,
+		},
+		{
+			name:     "decimal point",
+			exp:      "max(1,5)",
+			locale:   "en-US",
+			expected: 5,
+		},
+		{
+			name:    "decimal point in decimal comma locale",
+			exp:     "1.5",
+			locale:  "ru",
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:    "invalid locale",
+			exp:     "1",
+			locale:  "not a locale",
+			wantErr: e.ErrInvalidLocale,
+		},
+		{
+			name:  "decimal scientific notation",
+			exp:   "1.5e-4 + 1",
+			mode:  ModeDecimal,
+			exact: "1.00015",
+		},
+		{
+			name:   "decimal comma in decimal mode",
+			exp:    "0,1 + 0,2",
+			mode:   ModeDecimal,
+			locale: "it",
+			exact:  "0.3",
+		},
+		{
+			name:  "integer scientific notation",
+			exp:   "1e21 + 1",
+			mode:  ModeInteger,
+			exact: "1000000000000000000001",
+		},
+		{
+			name:    "fractional scientific notation in integer mode",
+			exp:     "1e-1",
+			mode:    ModeInteger,
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:    "exponent out of range in decimal mode",
+			exp:     "1e-9999999",
+			mode:    ModeDecimal,
+			wantErr: e.ErrInvalidExpression,
+		},
+		{
+			name:    "exponent out of range in rational mode",
+			exp:     "1e-99999 + 1",
+			mode:    ModeRational,
+			wantErr: e.ErrInvalidExpression,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := mock.NewRepository()
+			s := NewService(&config.Config{}, repo, repo, nil, repo, nil, nil)
+
+			ctx := context.Background()
+
+			id, err := s.Evaluate(ctx, &models.CalculateRequest{Expression: tc.exp, Mode: tc.mode, Locale: tc.locale}, "user")
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			runTasks(t, s)
+
+			exp, err := s.Get(ctx, id, "user")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			switch tc.mode {
+			case ModeDecimal:
+				if exp.Decimal != tc.exact {
+					t.Errorf("expected %s, got %s", tc.exact, exp.Decimal)
+				}
+			case ModeInteger:
+				if exp.Integer != tc.exact {
+					t.Errorf("expected %s, got %s", tc.exact, exp.Integer)
+				}
+			default:
+				if math.Abs(exp.Result-tc.expected) > 1e-9 {
+					t.Errorf("expected %v, got %v", tc.expected, exp.Result)
+				}
+			}
+		})
+	}
 }
@@ -7,23 +7,38 @@ import (
 	"fmt"
 	"github.com/distributed-calc/v1/internal/orchestrator/models"
 	pb "github.com/distributed-calc/v1/pkg/proto/orchestrator"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"io"
 	"net"
 	"time"
 )
 
+// agentIDKey is the metadata key agents send their ID with.
+const agentIDKey = "agent-id"
+
+// releaseTimeout bounds returning tasks of a closed stream, whose context is
+// already cancelled.
+const releaseTimeout = 5 * time.Second
+
 type Service interface {
-	GetTask(ctx context.Context) (*models.AgentTask, error)
+	GetTask(ctx context.Context, claimant string) (*models.AgentTask, error)
 	FinishTask(ctx context.Context, result *models.TaskResult) error
+	RequeueExpired(ctx context.Context) (int64, error)
+	ReleaseTasks(ctx context.Context, claimant string) (int64, error)
+	Cancellations(claimant string) (<-chan string, func())
 }
 
 type Config struct {
 	Host            string
 	GRPCPort        int
 	SendTaskBackoff time.Duration
+	// LeaseCheckInterval is how often expired leases are requeued, zero
+	// disables the reaper.
+	LeaseCheckInterval time.Duration
 }
 
 type Server struct {
@@ -32,6 +47,7 @@ type Server struct {
 	server  *grpc.Server
 	log     *zap.Logger
 	service Service
+	done    chan struct{}
 }
 
 func NewServer(cfg *Config, server *grpc.Server, log *zap.Logger, service Service) *Server {
@@ -40,6 +56,7 @@ func NewServer(cfg *Config, server *grpc.Server, log *zap.Logger, service Servic
 		server:  server,
 		log:     log,
 		service: service,
+		done:    make(chan struct{}),
 	}
 
 	pb.RegisterOrchestratorServer(server, app)
@@ -49,13 +66,18 @@ func NewServer(cfg *Config, server *grpc.Server, log *zap.Logger, service Servic
 
 func (s *Server) ProcessTasks(stream grpc.BidiStreamingServer[pb.TaskResult, pb.Task]) error {
 	ctx := stream.Context()
+	claimant := streamClaimant(ctx)
+	defer s.releaseTasks(claimant)
+
+	cancelled, unsubscribe := s.service.Cancellations(claimant)
+	defer unsubscribe()
 
 	eg, ctx := errgroup.WithContext(ctx)
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	eg.Go(func() error {
-		return s.sendTasks(ctx, stream)
+		return s.sendTasks(ctx, stream, claimant, cancelled)
 	})
 
 	eg.Go(func() error {
@@ -71,7 +93,66 @@ func (s *Server) ProcessTasks(stream grpc.BidiStreamingServer[pb.TaskResult, pb.
 	return nil
 }
 
-func (s *Server) sendTasks(ctx context.Context, stream grpc.BidiStreamingServer[pb.TaskResult, pb.Task]) error {
+// streamClaimant identifies the stream tasks are claimed by. Agents name
+// themselves with the agent-id metadata, and may open several streams.
+func streamClaimant(ctx context.Context) string {
+	agentID := "unknown"
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(agentIDKey); len(ids) > 0 && ids[0] != "" {
+			agentID = ids[0]
+		}
+	}
+
+	return agentID + "/" + uuid.NewString()
+}
+
+// releaseTasks returns tasks the closed stream did not finish to ready, so
+// they do not wait for their leases to expire.
+func (s *Server) releaseTasks(claimant string) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	n, err := s.service.ReleaseTasks(ctx, claimant)
+	if err != nil {
+		s.log.Error("failed to release tasks", zap.String("claimant", claimant), zap.Error(err))
+		return
+	}
+
+	if n > 0 {
+		s.log.Info("took back unfinished tasks", zap.String("claimant", claimant), zap.Int64("tasks", n))
+	}
+}
+
+// reap periodically requeues tasks with expired leases until the server is
+// shut down.
+func (s *Server) reap() {
+	ticker := time.NewTicker(s.cfg.LeaseCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), s.cfg.LeaseCheckInterval)
+			n, err := s.service.RequeueExpired(ctx)
+			cancel()
+
+			if err != nil {
+				s.log.Error("failed to requeue expired tasks", zap.Error(err))
+				continue
+			}
+
+			if n > 0 {
+				s.log.Warn("took back tasks with expired leases", zap.Int64("tasks", n))
+			}
+		}
+	}
+}
+
+// sendTasks sends tasks claimed for the stream, and tells the agent to abort
+// those of them which were cancelled.
+func (s *Server) sendTasks(ctx context.Context, stream grpc.BidiStreamingServer[pb.TaskResult, pb.Task], claimant string, cancelled <-chan string) error {
 	ticker := time.NewTicker(s.cfg.SendTaskBackoff)
 	defer ticker.Stop()
 
@@ -79,8 +160,14 @@ func (s *Server) sendTasks(ctx context.Context, stream grpc.BidiStreamingServer[
 		select {
 		case <-ctx.Done():
 			return nil
+		case id := <-cancelled:
+			err := stream.Send(&pb.Task{Id: id, Cancel: true})
+			if err != nil {
+				s.log.Error("failed to send cancellation", zap.Error(err))
+				return fmt.Errorf("failed to send cancellation: %w", err)
+			}
 		case <-ticker.C:
-			task, err := s.service.GetTask(ctx)
+			task, err := s.service.GetTask(ctx, claimant)
 			if errors.Is(err, sql.ErrNoRows) {
 				continue
 			}
@@ -89,14 +176,26 @@ func (s *Server) sendTasks(ctx context.Context, stream grpc.BidiStreamingServer[
 				return fmt.Errorf("failed to get task: %w", err)
 			}
 
-			err = stream.Send(&pb.Task{
+			msg := &pb.Task{
 				Id:            task.Id,
-				LeftArg:       task.LeftArg,
-				RightArg:      task.RightArg,
+				Args:          task.Args,
 				Op:            task.Op,
 				OperationTime: task.OperationTime,
 				Final:         task.Final,
-			})
+			}
+
+			for _, n := range task.Numbers {
+				msg.Numbers = append(msg.Numbers, numberToProto(&n))
+			}
+
+			if task.Precision != nil {
+				msg.Precision = &pb.Precision{
+					Scale:    task.Precision.Scale,
+					Rounding: task.Precision.Rounding,
+				}
+			}
+
+			err = stream.Send(msg)
 			if err != nil {
 				s.log.Error("failed to send task", zap.Error(err))
 				return fmt.Errorf("failed to send task: %w", err)
@@ -122,20 +221,56 @@ func (s *Server) getTaskResults(ctx context.Context, stream grpc.BidiStreamingSe
 				return fmt.Errorf("failed to receive task result: %w", err)
 			}
 
-			err = s.service.FinishTask(ctx, &models.TaskResult{
+			res := &models.TaskResult{
 				Id:     msg.GetId(),
 				Result: msg.GetResult(),
 				Status: msg.GetStatus(),
 				Final:  msg.GetFinal(),
-			})
+				Error:  msg.GetError(),
+			}
+
+			if n := msg.GetNumber(); n != nil {
+				res.Number = numberFromProto(n)
+			}
+
+			// Results of other tasks on the stream are still valid, the task
+			// is computed again once its lease expires.
+			err = s.service.FinishTask(ctx, res)
 			if err != nil {
-				s.log.Error("failed to finish task", zap.Error(err))
-				return fmt.Errorf("failed to finish task: %w", err)
+				s.log.Error("failed to finish task", zap.String("task_id", res.Id), zap.Error(err))
 			}
 		}
 	}
 }
 
+func numberFromProto(n *pb.Number) *models.Number {
+	switch k := n.GetKind().(type) {
+	case *pb.Number_Decimal:
+		return &models.Number{Decimal: k.Decimal}
+	case *pb.Number_Rational:
+		return &models.Number{Rational: &models.Rational{Num: k.Rational.GetNum(), Den: k.Rational.GetDen()}}
+	case *pb.Number_Complex:
+		return &models.Number{Complex: &models.Complex{Re: k.Complex.GetRe(), Im: k.Complex.GetIm()}}
+	case *pb.Number_Integer:
+		return &models.Number{Integer: k.Integer}
+	}
+
+	return nil
+}
+
+func numberToProto(n *models.Number) *pb.Number {
+	switch {
+	case n.Rational != nil:
+		return &pb.Number{Kind: &pb.Number_Rational{Rational: &pb.Rational{Num: n.Rational.Num, Den: n.Rational.Den}}}
+	case n.Complex != nil:
+		return &pb.Number{Kind: &pb.Number_Complex{Complex: &pb.Complex{Re: n.Complex.Re, Im: n.Complex.Im}}}
+	case n.Integer != "":
+		return &pb.Number{Kind: &pb.Number_Integer{Integer: n.Integer}}
+	default:
+		return &pb.Number{Kind: &pb.Number_Decimal{Decimal: n.Decimal}}
+	}
+}
+
 func (s *Server) Run() {
 	go func() {
 		addr := fmt.Sprintf("%s:%d", s.cfg.Host, s.cfg.GRPCPort)
@@ -149,8 +284,13 @@ func (s *Server) Run() {
 			s.log.Fatal("failed to listen", zap.Error(err))
 		}
 	}()
+
+	if s.cfg.LeaseCheckInterval > 0 {
+		go s.reap()
+	}
 }
 
 func (s *Server) Shutdown() {
+	close(s.done)
 	s.server.GracefulStop()
 }
@@ -1,12 +1,16 @@
 package grpc
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
 	pb "github.com/distributed-calc/v1/pkg/proto/orchestrator"
 	"github.com/distributed-calc/v1/test/mock"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"io"
+	"strings"
 	"testing"
 	"time"
 )
@@ -63,3 +67,184 @@ func TestServer_ProcessTasks(t *testing.T) {
 		t.Fatalf("error processing tasks: %v", err)
 	}
 }
+
+// leaseService records calls returning tasks to ready.
+type leaseService struct {
+	mock.ServiceMock
+	released chan string
+	requeued chan struct{}
+}
+
+func (s *leaseService) ReleaseTasks(_ context.Context, claimant string) (int64, error) {
+	s.released <- claimant
+	return 1, nil
+}
+
+func (s *leaseService) RequeueExpired(_ context.Context) (int64, error) {
+	s.requeued <- struct{}{}
+	return 1, nil
+}
+
+func TestServer_ProcessTasks_ReleasesTasks(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+
+	stream := mock.NewMockBidiServerStream[pb.TaskResult, pb.Task]()
+	stream.SetRecvErr(io.EOF)
+	close(stream.RecvCh)
+
+	service := &leaseService{released: make(chan string, 1)}
+
+	app := NewServer(&Config{
+		SendTaskBackoff: time.Hour,
+	}, grpc.NewServer(), log, service)
+
+	err := app.ProcessTasks(stream)
+	if err != nil {
+		t.Fatalf("error processing tasks: %v", err)
+	}
+
+	select {
+	case claimant := <-service.released:
+		if !strings.HasPrefix(claimant, "unknown/") {
+			t.Errorf("expected tasks of an unnamed agent to be released, got %q", claimant)
+		}
+	default:
+		t.Error("expected tasks of the closed stream to be released")
+	}
+}
+
+func TestServer_Reap(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+
+	service := &leaseService{requeued: make(chan struct{})}
+
+	app := NewServer(&Config{
+		LeaseCheckInterval: 10 * time.Millisecond,
+	}, grpc.NewServer(), log, service)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.reap()
+	}()
+
+	for range 2 {
+		select {
+		case <-service.requeued:
+		case <-time.After(time.Second):
+			t.Fatal("expected expired leases to be requeued periodically")
+		}
+	}
+
+	close(app.done)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("expected the reaper to stop")
+	}
+}
+
+// sentStream records messages sent to the agent without copying them.
+type sentStream struct {
+	*mock.BidiServerStream[pb.TaskResult, pb.Task]
+	sent chan *pb.Task
+}
+
+func (s *sentStream) Send(msg *pb.Task) error {
+	s.sent <- msg
+	return nil
+}
+
+func TestServer_SendTasks_Cancellations(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+
+	stream := &sentStream{
+		BidiServerStream: mock.NewMockBidiServerStream[pb.TaskResult, pb.Task](),
+		sent:             make(chan *pb.Task),
+	}
+
+	app := NewServer(&Config{
+		SendTaskBackoff: time.Hour,
+	}, grpc.NewServer(), log, &mock.ServiceMock{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cancelled := make(chan string, 1)
+	cancelled <- "exp:1"
+
+	done := make(chan error)
+	go func() {
+		done <- app.sendTasks(ctx, stream, "agent", cancelled)
+	}()
+
+	select {
+	case msg := <-stream.sent:
+		if !msg.GetCancel() || msg.GetId() != "exp:1" {
+			t.Errorf("expected cancellation of task exp:1, got id %q and cancel %v", msg.GetId(), msg.GetCancel())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the cancellation to be sent to the agent")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// failingService fails to finish tasks and records their IDs.
+type failingService struct {
+	mock.ServiceMock
+	finished []string
+}
+
+func (s *failingService) FinishTask(_ context.Context, res *models.TaskResult) error {
+	s.finished = append(s.finished, res.Id)
+	return errors.New("write conflict")
+}
+
+// resultStream receives the results, then io.EOF.
+type resultStream struct {
+	*mock.BidiServerStream[pb.TaskResult, pb.Task]
+	results []*pb.TaskResult
+}
+
+func (s *resultStream) Recv() (*pb.TaskResult, error) {
+	if len(s.results) == 0 {
+		return nil, io.EOF
+	}
+
+	res := s.results[0]
+	s.results = s.results[1:]
+
+	return res, nil
+}
+
+func TestServer_GetTaskResults_FinishTaskError(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+
+	stream := &resultStream{
+		BidiServerStream: mock.NewMockBidiServerStream[pb.TaskResult, pb.Task](),
+		results: []*pb.TaskResult{
+			{Id: "exp:0", Result: 1, Status: "completed"},
+			{Id: "exp:1", Result: 1, Status: "completed"},
+		},
+	}
+
+	service := &failingService{}
+
+	app := NewServer(&Config{
+		SendTaskBackoff: time.Hour,
+	}, grpc.NewServer(), log, service)
+
+	err := app.getTaskResults(context.Background(), stream)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(service.finished) != 2 {
+		t.Errorf("expected the stream to outlive failures to finish tasks, got results %v", service.finished)
+	}
+}
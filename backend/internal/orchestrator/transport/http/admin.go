@@ -0,0 +1,94 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	e "github.com/distributed-calc/v1/internal/orchestrator/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+const deadLettersPath = "/api/v1/admin/dead-letters"
+
+// handleDeadLetters lists dead letters on GET /api/v1/admin/dead-letters and
+// requeues one on POST /api/v1/admin/dead-letters/{id}/requeue.
+func (t *Server) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < len("Bearer ") {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	accessToken := strings.TrimPrefix(authorization, "Bearer ")
+
+	userID, err := t.s.GetUserID(ctx, accessToken)
+	if err != nil {
+		t.log.Error("failed to get user id", zap.Error(err))
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if path == deadLettersPath {
+		if r.Method != http.MethodGet {
+			http.Error(w, methodNotAllowed, http.StatusMethodNotAllowed)
+			return
+		}
+
+		dls, err := t.s.GetDeadLetters(ctx, userID)
+		if err != nil {
+			t.log.Error(err.Error())
+			adminError(w, err)
+			return
+		}
+
+		data, err := json.Marshal(map[string]any{
+			"dead_letters": dls,
+		})
+		if err != nil {
+			t.log.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+		return
+	}
+
+	id, ok := strings.CutSuffix(strings.TrimPrefix(path, deadLettersPath+"/"), "/requeue")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, methodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	err = t.s.RequeueDeadLetter(ctx, userID, id)
+	if err != nil {
+		t.log.Error(err.Error(), zap.String("task_id", id))
+		adminError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func adminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, e.ErrForbidden):
+		http.Error(w, e.ErrForbidden.Error(), http.StatusForbidden)
+	case errors.Is(err, e.ErrNoTasks), errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "dead letter not found", http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,207 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+func (t *Server) handleExplain(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, methodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "dot" && format != "mermaid" {
+		http.Error(w, fmt.Sprintf("unknown format %q, expected json, dot or mermaid", format), http.StatusBadRequest)
+		return
+	}
+
+	var exp *models.CalculateRequest
+	err := json.NewDecoder(r.Body).Decode(&exp)
+	if err != nil {
+		t.log.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < len("Bearer ") {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	accessToken := strings.TrimPrefix(authorization, "Bearer ")
+
+	userID, err := t.s.GetUserID(ctx, accessToken)
+	if err != nil {
+		t.log.Error("failed to get user id", zap.Error(err))
+		return
+	}
+
+	explanation, err := t.s.Explain(ctx, exp, userID)
+	if err != nil {
+		t.log.Error(err.Error())
+		compileError(w, err)
+		return
+	}
+
+	switch format {
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		_, _ = w.Write([]byte(dot(explanation)))
+	case "mermaid":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(mermaid(explanation)))
+	default:
+		data, err := json.Marshal(explanation)
+		if err != nil {
+			t.log.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	}
+}
+
+// edge is a dependency of a task drawn in a rendering of the graph. Branch is
+// set on edges from conditions to tasks of their branches.
+type edge struct {
+	from, to string
+	branch   string
+}
+
+// edges lists dependencies of tasks of the graph once each, results flow
+// from operands to their consumers.
+func edges(explanation *models.Explanation) []edge {
+	var res []edge
+	for _, t := range explanation.Tasks {
+		seen := make(map[string]bool)
+
+		for _, guard := range t.Guards {
+			i := strings.LastIndex(guard, ":")
+			seen[guard[:i]] = true
+			res = append(res, edge{from: guard[:i], to: t.ID, branch: guard[i+1:]})
+		}
+
+		for _, dep := range t.Dependencies {
+			if !seen[dep] {
+				seen[dep] = true
+				res = append(res, edge{from: dep, to: t.ID})
+			}
+		}
+	}
+
+	return res
+}
+
+// label describes a task like 't2: a = * (t0, 3), 1ms'.
+func label(t models.ExplainedTask) string {
+	var b strings.Builder
+	b.WriteString(t.ID + ": ")
+	if t.Name != "" {
+		b.WriteString(t.Name + " = ")
+	}
+
+	if t.Op == "" {
+		b.WriteString(strings.Join(t.Args, ", "))
+	} else {
+		fmt.Fprintf(&b, "%s (%s)", t.Op, strings.Join(t.Args, ", "))
+	}
+
+	if t.Unit != "" {
+		b.WriteString(" " + t.Unit)
+	}
+
+	fmt.Fprintf(&b, ", %dms", t.Duration)
+	return b.String()
+}
+
+// dot renders the graph in the Graphviz DOT language. Tasks of the critical
+// path are drawn in bold, edges of branches are dashed.
+func dot(explanation *models.Explanation) string {
+	var b strings.Builder
+	b.WriteString("digraph expression {\n")
+	b.WriteString("\tnode [shape=box];\n")
+
+	critical := make(map[string]bool)
+	for _, t := range explanation.Tasks {
+		attrs := fmt.Sprintf("label=%q", label(t))
+		if t.Final {
+			attrs += ", peripheries=2"
+		}
+		if t.Critical {
+			critical[t.ID] = true
+			attrs += ", style=bold, color=red"
+		}
+		fmt.Fprintf(&b, "\t%s [%s];\n", t.ID, attrs)
+	}
+
+	for _, e := range edges(explanation) {
+		var attrs []string
+		if e.branch != "" {
+			attrs = append(attrs, "style=dashed", fmt.Sprintf("label=%q", e.branch))
+		}
+		if critical[e.from] && critical[e.to] {
+			attrs = append(attrs, "color=red")
+		}
+
+		if len(attrs) == 0 {
+			fmt.Fprintf(&b, "\t%s -> %s;\n", e.from, e.to)
+		} else {
+			fmt.Fprintf(&b, "\t%s -> %s [%s];\n", e.from, e.to, strings.Join(attrs, ", "))
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// mermaidEscaper replaces characters which end or break labels of Mermaid
+// nodes with entity codes.
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;")
+
+// mermaid renders the graph as a Mermaid flowchart. Tasks of the critical
+// path are in the 'critical' class, edges of branches are dotted.
+func mermaid(explanation *models.Explanation) string {
+	var b strings.Builder
+	b.WriteString("flowchart BT\n")
+
+	var critical []string
+	for _, t := range explanation.Tasks {
+		open, closing := "[", "]"
+		if t.Final {
+			open, closing = "[[", "]]"
+		}
+		fmt.Fprintf(&b, "\t%s%s\"%s\"%s\n", t.ID, open, mermaidEscaper.Replace(label(t)), closing)
+
+		if t.Critical {
+			critical = append(critical, t.ID)
+		}
+	}
+
+	for _, e := range edges(explanation) {
+		if e.branch != "" {
+			fmt.Fprintf(&b, "\t%s -.->|%s| %s\n", e.from, e.branch, e.to)
+		} else {
+			fmt.Fprintf(&b, "\t%s --> %s\n", e.from, e.to)
+		}
+	}
+
+	if len(critical) > 0 {
+		b.WriteString("\tclassDef critical stroke:#d00,stroke-width:2px\n")
+		fmt.Fprintf(&b, "\tclass %s critical\n", strings.Join(critical, ","))
+	}
+
+	return b.String()
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	e "github.com/distributed-calc/v1/internal/orchestrator/errors"
 	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"github.com/distributed-calc/v1/internal/orchestrator/parser"
 	"github.com/distributed-calc/v1/pkg/middleware"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -24,19 +25,30 @@ const (
 )
 
 type Service interface {
-	Evaluate(ctx context.Context, expression, userID string) (string, error)
+	Evaluate(ctx context.Context, req *models.CalculateRequest, userID string) (string, error)
+	Explain(ctx context.Context, req *models.CalculateRequest, userID string) (*models.Explanation, error)
 	Get(ctx context.Context, id, userID string) (*models.Expression, error)
 	GetAll(ctx context.Context, userID, cursor string, limit int64) ([]*models.Expression, error)
+	Cancel(ctx context.Context, id, userID string) error
 
-	GetTask(ctx context.Context) (*models.AgentTask, error)
+	GetTask(ctx context.Context, claimant string) (*models.AgentTask, error)
 	FinishTask(ctx context.Context, result *models.TaskResult) error
 
+	GetDeadLetters(ctx context.Context, userID string) ([]*models.DeadLetter, error)
+	RequeueDeadLetter(ctx context.Context, userID, id string) error
+
 	Register(ctx context.Context, creds *models.UserCredentials) error
 	Login(ctx context.Context, creds *models.UserCredentials) (*models.JWTTokens, error)
 	GetUserID(_ context.Context, token string) (string, error)
 
 	GetUser(ctx context.Context, id string) (*models.UserView, error)
 
+	AddVariable(ctx context.Context, userID string, v *models.Variable) error
+	GetVariable(ctx context.Context, userID, name string) (*models.Variable, error)
+	GetVariables(ctx context.Context, userID string) ([]*models.Variable, error)
+	UpdateVariable(ctx context.Context, userID string, v *models.Variable) error
+	DeleteVariable(ctx context.Context, userID, name string) error
+
 	middleware.Auth
 }
 
@@ -69,6 +81,12 @@ func NewServer(cfg *Config, s Service, log *zap.Logger) *Server {
 			middleware.MwLogger(log,
 				middleware.MwRecover(log,
 					middleware.MwAuth(log, s, http.HandlerFunc(t.handleCalculate)))))
+	t.mux.
+		Handle(
+			"/api/v1/explain",
+			middleware.MwLogger(log,
+				middleware.MwRecover(log,
+					middleware.MwAuth(log, s, http.HandlerFunc(t.handleExplain)))))
 	t.mux.
 		Handle(
 			"/api/v1/expressions",
@@ -82,6 +100,32 @@ func NewServer(cfg *Config, s Service, log *zap.Logger) *Server {
 				middleware.MwRecover(log,
 					middleware.MwAuth(log, s, http.HandlerFunc(t.handleExpression)))))
 
+	t.mux.
+		Handle(
+			"/api/v1/variables",
+			middleware.MwLogger(log,
+				middleware.MwRecover(log,
+					middleware.MwAuth(log, s, http.HandlerFunc(t.handleVariables)))))
+	t.mux.
+		Handle(
+			"/api/v1/variables/",
+			middleware.MwLogger(log,
+				middleware.MwRecover(log,
+					middleware.MwAuth(log, s, http.HandlerFunc(t.handleVariable)))))
+
+	t.mux.
+		Handle(
+			deadLettersPath,
+			middleware.MwLogger(log,
+				middleware.MwRecover(log,
+					middleware.MwAuth(log, s, http.HandlerFunc(t.handleDeadLetters)))))
+	t.mux.
+		Handle(
+			deadLettersPath+"/",
+			middleware.MwLogger(log,
+				middleware.MwRecover(log,
+					middleware.MwAuth(log, s, http.HandlerFunc(t.handleDeadLetters)))))
+
 	t.mux.
 		Handle(
 			"/api/v1/register",
@@ -138,17 +182,10 @@ func (t *Server) handleCalculate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	expID, err := t.s.Evaluate(ctx, exp.Expression, userID)
+	expID, err := t.s.Evaluate(ctx, exp, userID)
 	if err != nil {
 		t.log.Error(err.Error())
-
-		switch {
-		case errors.Is(err, e.ErrInvalidExpression):
-			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
-		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-
+		compileError(w, err)
 		return
 	}
 
@@ -165,6 +202,53 @@ func (t *Server) handleCalculate(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(data)
 }
 
+// compileError responds with an ExpressionError describing an error of
+// compiling an expression. Syntax errors and errors of checking the expression
+// against its mode keep their position, so clients may point at the
+// offending part of the input.
+func compileError(w http.ResponseWriter, err error) {
+	res := models.ExpressionError{Message: err.Error()}
+
+	var status int
+	switch {
+	case errors.Is(err, e.ErrInvalidMode):
+		status, res.Code = http.StatusBadRequest, "invalid_mode"
+	case errors.Is(err, e.ErrInvalidLocale):
+		status, res.Code = http.StatusBadRequest, "invalid_locale"
+	case errors.Is(err, e.ErrExpressionTooLarge):
+		status, res.Code = http.StatusRequestEntityTooLarge, "too_large"
+	case errors.Is(err, e.ErrExpressionTooComplex):
+		status, res.Code = http.StatusUnprocessableEntity, "too_complex"
+	case errors.Is(err, e.ErrInvalidExpression):
+		status, res.Code = http.StatusUnprocessableEntity, "invalid_expression"
+	case errors.Is(err, e.ErrTooManyExpressions):
+		status, res.Code = http.StatusTooManyRequests, "too_many_expressions"
+	default:
+		status, res.Code = http.StatusInternalServerError, "internal"
+	}
+
+	var perr *parser.Error
+	if errors.As(err, &perr) {
+		if perr.Code != "" {
+			res.Code = string(perr.Code)
+		}
+		res.Message = perr.Msg
+		res.Offset = &perr.Offset
+		res.Length = &perr.Length
+		res.Hint = perr.Hint
+	}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
 func (t *Server) handleExpressions(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
 	defer cancel()
@@ -202,7 +286,7 @@ func (t *Server) handleExpressions(w http.ResponseWriter, r *http.Request) {
 		t.log.Error(err.Error())
 
 		switch {
-		case errors.Is(err, sql.ErrNoRows):
+		case errors.Is(err, sql.ErrNoRows), errors.Is(err, e.ErrNoExpressions):
 			http.Error(w, "no expressions with requested parameters found", http.StatusNotFound)
 		default:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -232,7 +316,7 @@ func (t *Server) handleExpression(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
 	defer cancel()
 
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
 		http.Error(w, methodNotAllowed, http.StatusMethodNotAllowed)
 		return
 	}
@@ -261,13 +345,21 @@ func (t *Server) handleExpression(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	exp, err := t.s.Get(ctx, id.String(), userID)
+	var exp *models.Expression
+	switch r.Method {
+	case http.MethodGet:
+		exp, err = t.s.Get(ctx, id.String(), userID)
+	case http.MethodDelete:
+		err = t.s.Cancel(ctx, id.String(), userID)
+	}
 	if err != nil {
 		t.log.Error(err.Error(), zap.String("exp_id", id.String()))
 
 		switch {
-		case errors.Is(err, e.ErrExpressionDoesNotExist):
+		case errors.Is(err, e.ErrExpressionDoesNotExist), errors.Is(err, sql.ErrNoRows):
 			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, e.ErrConflict):
+			http.Error(w, err.Error(), http.StatusConflict)
 		default:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -275,6 +367,11 @@ func (t *Server) handleExpression(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if exp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
 	data, err := json.Marshal(map[string]any{
 		"expression": exp,
 	})
@@ -282,6 +379,154 @@ func (t *Server) handleExpression(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(data)
 }
 
+func (t *Server) handleVariables(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, methodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < len("Bearer ") {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	accessToken := strings.TrimPrefix(authorization, "Bearer ")
+
+	userID, err := t.s.GetUserID(ctx, accessToken)
+	if err != nil {
+		t.log.Error("failed to get user id", zap.Error(err))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		vars, err := t.s.GetVariables(ctx, userID)
+		if err != nil {
+			t.log.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(map[string]any{
+			"variables": vars,
+		})
+		if err != nil {
+			t.log.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		_, _ = w.Write(data)
+		return
+	}
+
+	defer r.Body.Close()
+
+	var v models.Variable
+	err = json.NewDecoder(r.Body).Decode(&v)
+	if err != nil {
+		t.log.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = t.s.AddVariable(ctx, userID, &v)
+	if err != nil {
+		t.log.Error(err.Error())
+
+		switch {
+		case errors.Is(err, e.ErrInvalidVariable):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, e.ErrVariableAlreadyExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(&v)
+}
+
+func (t *Server) handleVariable(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, methodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/variables/")
+	if name == "" || strings.Contains(name, "/") {
+		http.Error(w, "invalid variable name", http.StatusBadRequest)
+		return
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < len("Bearer ") {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	accessToken := strings.TrimPrefix(authorization, "Bearer ")
+
+	userID, err := t.s.GetUserID(ctx, accessToken)
+	if err != nil {
+		t.log.Error("failed to get user id", zap.Error(err))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var v *models.Variable
+	switch r.Method {
+	case http.MethodGet:
+		v, err = t.s.GetVariable(ctx, userID, name)
+	case http.MethodPut:
+		defer r.Body.Close()
+
+		v = &models.Variable{}
+		err = json.NewDecoder(r.Body).Decode(v)
+		if err != nil {
+			t.log.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		v.Name = name
+
+		err = t.s.UpdateVariable(ctx, userID, v)
+	case http.MethodDelete:
+		err = t.s.DeleteVariable(ctx, userID, name)
+	}
+	if err != nil {
+		t.log.Error(err.Error(), zap.String("variable", name))
+
+		switch {
+		case errors.Is(err, e.ErrVariableDoesNotExist):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, e.ErrInvalidVariable):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if v == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
 func (t *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
 	defer cancel()
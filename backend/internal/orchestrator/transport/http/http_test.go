@@ -3,11 +3,15 @@ package http
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"github.com/distributed-calc/v1/internal/orchestrator/errors"
+	"github.com/distributed-calc/v1/internal/orchestrator/models"
+	"github.com/distributed-calc/v1/internal/orchestrator/parser"
 	"github.com/distributed-calc/v1/test/mock"
 	"go.uber.org/zap"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -92,6 +96,13 @@ func TestTransportHttp_handleCalculate(t *testing.T) {
 			err:            errors.ErrInvalidExpression,
 			expectedStatus: http.StatusUnprocessableEntity,
 		},
+		{
+			name:           "invalid mode",
+			expression:     `{"expression": "2+2", "mode": "decimal", "precision": {"scale": -1}}`,
+			method:         "POST",
+			err:            errors.ErrInvalidMode,
+			expectedStatus: http.StatusBadRequest,
+		},
 		{
 			name:           "method not allowed",
 			expression:     "2+2",
@@ -106,6 +117,7 @@ func TestTransportHttp_handleCalculate(t *testing.T) {
 			s.Err = tc.err
 
 			req := httptest.NewRequest(tc.method, "/api/v1/calculate", bytes.NewReader([]byte(tc.expression)))
+			req.Header.Set("Authorization", "Bearer test")
 			r := httptest.NewRecorder()
 
 			th.handleCalculate(r, req)
@@ -117,6 +129,273 @@ func TestTransportHttp_handleCalculate(t *testing.T) {
 	}
 }
 
+func TestCompileError(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		status   int
+		expected string
+	}{
+		{
+			name: "syntax error",
+			err: fmt.Errorf("%w: %w", errors.ErrInvalidExpression, &parser.Error{
+				Code:   parser.CodeUnknownFunction,
+				Offset: 4,
+				Length: 3,
+				Msg:    `unknown function "sqr"`,
+				Hint:   `did you mean "sqrt"?`,
+			}),
+			status:   http.StatusUnprocessableEntity,
+			expected: `{"code":"unknown_function","message":"unknown function \"sqr\"","offset":4,"length":3,"hint":"did you mean \"sqrt\"?"}`,
+		},
+		{
+			name:     "error at the start",
+			err:      fmt.Errorf("%w: %w", errors.ErrInvalidExpression, &parser.Error{Code: parser.CodeEmptyExpression, Msg: "expression is empty"}),
+			status:   http.StatusUnprocessableEntity,
+			expected: `{"code":"empty_expression","message":"expression is empty","offset":0,"length":0}`,
+		},
+		{
+			name:     "too large",
+			err:      fmt.Errorf("%w: 100 bytes", errors.ErrExpressionTooLarge),
+			status:   http.StatusRequestEntityTooLarge,
+			expected: `{"code":"too_large","message":"expression is too large: 100 bytes"}`,
+		},
+		{
+			name:     "too many expressions",
+			err:      errors.ErrTooManyExpressions,
+			status:   http.StatusTooManyRequests,
+			expected: `{"code":"too_many_expressions","message":"too many pending expressions"}`,
+		},
+		{
+			name:     "invalid mode",
+			err:      errors.ErrInvalidMode,
+			status:   http.StatusBadRequest,
+			expected: `{"code":"invalid_mode","message":"invalid evaluation mode"}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRecorder()
+
+			compileError(r, tc.err)
+
+			if r.Code != tc.status {
+				t.Errorf("expected status code %d, got %d", tc.status, r.Code)
+			}
+
+			if r.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("expected json, got %s", r.Header().Get("Content-Type"))
+			}
+
+			if r.Body.String() != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, r.Body.String())
+			}
+		})
+	}
+}
+
+func TestTransportHttp_handleExplain(t *testing.T) {
+	defer func() {
+		s.Err = nil
+	}()
+
+	cases := []struct {
+		name           string
+		query          string
+		method         string
+		err            error
+		expectedStatus int
+		contentType    string
+		contains       string
+	}{
+		{
+			name:           "json",
+			method:         "POST",
+			expectedStatus: http.StatusOK,
+			contentType:    "application/json",
+			contains:       `"critical_path":1`,
+		},
+		{
+			name:           "dot",
+			query:          "?format=dot",
+			method:         "POST",
+			expectedStatus: http.StatusOK,
+			contentType:    "text/vnd.graphviz; charset=utf-8",
+			contains:       `t0 [label="t0: + (2, 2), 1ms", peripheries=2, style=bold, color=red];`,
+		},
+		{
+			name:           "mermaid",
+			query:          "?format=mermaid",
+			method:         "POST",
+			expectedStatus: http.StatusOK,
+			contentType:    "text/plain; charset=utf-8",
+			contains:       `t0[["t0: + (2, 2), 1ms"]]`,
+		},
+		{
+			name:           "unknown format",
+			query:          "?format=svg",
+			method:         "POST",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unprocessable entity",
+			method:         "POST",
+			err:            errors.ErrInvalidExpression,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "method not allowed",
+			method:         "GET",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s.Err = tc.err
+
+			req := httptest.NewRequest(tc.method, "/api/v1/explain"+tc.query, bytes.NewReader([]byte(`{"expression": "2+2"}`)))
+			req.Header.Set("Authorization", "Bearer test")
+			r := httptest.NewRecorder()
+
+			th.handleExplain(r, req)
+
+			if r.Code != tc.expectedStatus {
+				t.Fatalf("expected status code %d, got %d", tc.expectedStatus, r.Code)
+			}
+
+			if tc.contentType != "" && r.Header().Get("Content-Type") != tc.contentType {
+				t.Errorf("expected content type %s, got %s", tc.contentType, r.Header().Get("Content-Type"))
+			}
+
+			if !strings.Contains(r.Body.String(), tc.contains) {
+				t.Errorf("expected body to contain %s, got %s", tc.contains, r.Body.String())
+			}
+		})
+	}
+}
+
+func TestTransportHttp_handleDeadLetters(t *testing.T) {
+	defer func() {
+		s.Err = nil
+	}()
+
+	cases := []struct {
+		name           string
+		method         string
+		path           string
+		err            error
+		expectedStatus int
+		contains       string
+	}{
+		{
+			name:           "list",
+			method:         "GET",
+			path:           "/api/v1/admin/dead-letters",
+			expectedStatus: http.StatusOK,
+			contains:       `"reason":"lease expired"`,
+		},
+		{
+			name:           "requeue",
+			method:         "POST",
+			path:           "/api/v1/admin/dead-letters/exp:1/requeue",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "not an admin",
+			method:         "GET",
+			path:           "/api/v1/admin/dead-letters",
+			err:            fmt.Errorf("%w: user is not an admin", errors.ErrForbidden),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "unknown dead letter",
+			method:         "POST",
+			path:           "/api/v1/admin/dead-letters/exp:2/requeue",
+			err:            errors.ErrNoTasks,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "unknown path",
+			method:         "POST",
+			path:           "/api/v1/admin/dead-letters/exp:1",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "method not allowed",
+			method:         "DELETE",
+			path:           "/api/v1/admin/dead-letters",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s.Err = tc.err
+
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			req.Header.Set("Authorization", "Bearer test")
+			r := httptest.NewRecorder()
+
+			th.handleDeadLetters(r, req)
+
+			if r.Code != tc.expectedStatus {
+				t.Fatalf("expected status code %d, got %d", tc.expectedStatus, r.Code)
+			}
+
+			if !strings.Contains(r.Body.String(), tc.contains) {
+				t.Errorf("expected body to contain %s, got %s", tc.contains, r.Body.String())
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	explanation := &models.Explanation{
+		Tasks: []models.ExplainedTask{
+			{ID: "t0", Op: ">", Args: []string{"2", "1"}, Dependencies: []string{}, Condition: true, Ready: true, Critical: true},
+			{ID: "t1", Op: "*", Args: []string{"t0", "t0"}, Dependencies: []string{"t0"}, Guards: []string{"t0:then"}, Duration: 1, Critical: true},
+			{ID: "t2", Op: "-", Args: []string{"3"}, Dependencies: []string{"t0"}, Guards: []string{"t0:else"}},
+			{ID: "t3", Op: "if", Args: []string{"t0", "t1", "t2"}, Dependencies: []string{"t0", "t1", "t2"}, Final: true, Critical: true},
+		},
+	}
+
+	expected := `digraph expression {
+	node [shape=box];
+	t0 [label="t0: > (2, 1), 0ms", style=bold, color=red];
+	t1 [label="t1: * (t0, t0), 1ms", style=bold, color=red];
+	t2 [label="t2: - (3), 0ms"];
+	t3 [label="t3: if (t0, t1, t2), 0ms", peripheries=2, style=bold, color=red];
+	t0 -> t1 [style=dashed, label="then", color=red];
+	t0 -> t2 [style=dashed, label="else"];
+	t0 -> t3 [color=red];
+	t1 -> t3 [color=red];
+	t2 -> t3;
+}
+`
+	if got := dot(explanation); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+
+	expected = `flowchart BT
+	t0["t0: #gt; (2, 1), 0ms"]
+	t1["t1: * (t0, t0), 1ms"]
+	t2["t2: - (3), 0ms"]
+	t3[["t3: if (t0, t1, t2), 0ms"]]
+	t0 -.->|then| t1
+	t0 -.->|else| t2
+	t0 --> t3
+	t1 --> t3
+	t2 --> t3
+	classDef critical stroke:#d00,stroke-width:2px
+	class t0,t1,t3 critical
+`
+	if got := mermaid(explanation); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
 func TestTransportHttp_handleExpressions(t *testing.T) {
 	defer func() {
 		s.Err = nil
@@ -153,6 +432,7 @@ func TestTransportHttp_handleExpressions(t *testing.T) {
 			s.Err = tc.err
 
 			req := httptest.NewRequest(tc.method, "/api/v1/expressions", nil)
+			req.Header.Set("Authorization", "Bearer test")
 			r := httptest.NewRecorder()
 
 			th.handleExpressions(r, req)
@@ -187,6 +467,24 @@ func TestTransportHttp_handleExpression(t *testing.T) {
 			err:            errors.ErrExpressionDoesNotExist,
 			expectedStatus: http.StatusNotFound,
 		},
+		{
+			name:           "cancel",
+			method:         "DELETE",
+			err:            nil,
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "cancel not found",
+			method:         "DELETE",
+			err:            errors.ErrExpressionDoesNotExist,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "cancel finished expression",
+			method:         "DELETE",
+			err:            errors.ErrConflict,
+			expectedStatus: http.StatusConflict,
+		},
 		{
 			name:           "method not allowed",
 			method:         "POST",
@@ -200,6 +498,7 @@ func TestTransportHttp_handleExpression(t *testing.T) {
 			s.Err = tc.err
 
 			req := httptest.NewRequest(tc.method, "/api/v1/expressions/d8241c51-8782-42fb-9cb7-61ca519064d9", nil)
+			req.Header.Set("Authorization", "Bearer test")
 			r := httptest.NewRecorder()
 
 			th.handleExpression(r, req)
@@ -210,3 +509,157 @@ func TestTransportHttp_handleExpression(t *testing.T) {
 		})
 	}
 }
+
+func TestTransportHttp_handleVariables(t *testing.T) {
+	defer func() {
+		s.Err = nil
+	}()
+
+	cases := []struct {
+		name           string
+		method         string
+		body           string
+		err            error
+		expectedStatus int
+	}{
+		{
+			name:           "list",
+			method:         "GET",
+			err:            nil,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "create",
+			method:         "POST",
+			body:           `{"name": "rate", "value": 0.07}`,
+			err:            nil,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "bad request",
+			method:         "POST",
+			body:           "rate = 0.07",
+			err:            nil,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid name",
+			method:         "POST",
+			body:           `{"name": "pi", "value": 3}`,
+			err:            errors.ErrInvalidVariable,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "conflict",
+			method:         "POST",
+			body:           `{"name": "rate", "value": 0.07}`,
+			err:            errors.ErrVariableAlreadyExists,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "method not allowed",
+			method:         "DELETE",
+			err:            nil,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s.Err = tc.err
+
+			req := httptest.NewRequest(tc.method, "/api/v1/variables", bytes.NewReader([]byte(tc.body)))
+			req.Header.Set("Authorization", "Bearer test")
+			r := httptest.NewRecorder()
+
+			th.handleVariables(r, req)
+
+			if r.Code != tc.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tc.expectedStatus, r.Code)
+			}
+		})
+	}
+}
+
+func TestTransportHttp_handleVariable(t *testing.T) {
+	defer func() {
+		s.Err = nil
+	}()
+
+	cases := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		err            error
+		expectedStatus int
+	}{
+		{
+			name:           "get",
+			method:         "GET",
+			path:           "/api/v1/variables/rate",
+			err:            nil,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "update",
+			method:         "PUT",
+			path:           "/api/v1/variables/rate",
+			body:           `{"value": 0.08}`,
+			err:            nil,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "delete",
+			method:         "DELETE",
+			path:           "/api/v1/variables/rate",
+			err:            nil,
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "not found",
+			method:         "GET",
+			path:           "/api/v1/variables/rate",
+			err:            errors.ErrVariableDoesNotExist,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "update invalid name",
+			method:         "PUT",
+			path:           "/api/v1/variables/pi",
+			body:           `{"value": 3}`,
+			err:            errors.ErrInvalidVariable,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "missing name",
+			method:         "GET",
+			path:           "/api/v1/variables/",
+			err:            nil,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "method not allowed",
+			method:         "POST",
+			path:           "/api/v1/variables/rate",
+			err:            nil,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s.Err = tc.err
+
+			req := httptest.NewRequest(tc.method, tc.path, bytes.NewReader([]byte(tc.body)))
+			req.Header.Set("Authorization", "Bearer test")
+			r := httptest.NewRecorder()
+
+			th.handleVariable(r, req)
+
+			if r.Code != tc.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tc.expectedStatus, r.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package units
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Dimension holds exponents of the SI base quantities: length, mass, time,
+// electric current, temperature, amount of substance and luminous intensity.
+type Dimension [7]int
+
+// symbols are the SI base units of the quantities of Dimension.
+var symbols = [len(Dimension{})]string{"m", "kg", "s", "A", "K", "mol", "cd"}
+
+var (
+	Length      = Dimension{1, 0, 0, 0, 0, 0, 0}
+	Mass        = Dimension{0, 1, 0, 0, 0, 0, 0}
+	Time        = Dimension{0, 0, 1, 0, 0, 0, 0}
+	Current     = Dimension{0, 0, 0, 1, 0, 0, 0}
+	Temperature = Dimension{0, 0, 0, 0, 1, 0, 0}
+	Amount      = Dimension{0, 0, 0, 0, 0, 1, 0}
+	Luminosity  = Dimension{0, 0, 0, 0, 0, 0, 1}
+)
+
+func (d Dimension) IsZero() bool {
+	return d == Dimension{}
+}
+
+func (d Dimension) Mul(o Dimension) Dimension {
+	for i := range d {
+		d[i] += o[i]
+	}
+
+	return d
+}
+
+func (d Dimension) Div(o Dimension) Dimension {
+	for i := range d {
+		d[i] -= o[i]
+	}
+
+	return d
+}
+
+func (d Dimension) Pow(n int) Dimension {
+	for i := range d {
+		d[i] *= n
+	}
+
+	return d
+}
+
+// Root reports the n-th root of d, which exists only if every exponent is
+// divisible by n.
+func (d Dimension) Root(n int) (Dimension, bool) {
+	for i := range d {
+		if d[i]%n != 0 {
+			return Dimension{}, false
+		}
+		d[i] /= n
+	}
+
+	return d, true
+}
+
+// String formats d with SI base units, like 'm*kg/s^2'. A dimensionless
+// value is formatted as '1'.
+func (d Dimension) String() string {
+	var num, den []string
+	for i, exp := range d {
+		switch {
+		case exp == 1 || exp == -1:
+			if exp > 0 {
+				num = append(num, symbols[i])
+			} else {
+				den = append(den, symbols[i])
+			}
+		case exp > 0:
+			num = append(num, fmt.Sprintf("%s^%d", symbols[i], exp))
+		case exp < 0:
+			den = append(den, fmt.Sprintf("%s^%d", symbols[i], -exp))
+		}
+	}
+
+	s := strings.Join(num, "*")
+	if s == "" {
+		s = "1"
+	}
+
+	if len(den) > 0 {
+		s += "/" + strings.Join(den, "*")
+	}
+
+	return s
+}
+
+// Unit is a unit of measurement, a value in it is Factor times the value in
+// SI base units of Dim.
+type Unit struct {
+	Factor float64
+	Dim    Dimension
+}
+
+func (u Unit) Mul(o Unit) Unit {
+	return Unit{Factor: u.Factor * o.Factor, Dim: u.Dim.Mul(o.Dim)}
+}
+
+func (u Unit) Pow(n int) Unit {
+	return Unit{Factor: math.Pow(u.Factor, float64(n)), Dim: u.Dim.Pow(n)}
+}
+
+// One is the unit of dimensionless values.
+var One = Unit{Factor: 1}
+
+type namedUnit struct {
+	Unit
+	// Prefixable units accept SI prefixes, like 'km' or 'ms'.
+	Prefixable bool
+}
+
+var named = map[string]namedUnit{
+	"m":   {Unit{1, Length}, true},
+	"g":   {Unit{1e-3, Mass}, true},
+	"s":   {Unit{1, Time}, true},
+	"A":   {Unit{1, Current}, true},
+	"K":   {Unit{1, Temperature}, true},
+	"mol": {Unit{1, Amount}, true},
+	"cd":  {Unit{1, Luminosity}, true},
+	"Hz":  {Unit{1, Time.Pow(-1)}, true},
+	"N":   {Unit{1, Mass.Mul(Length).Div(Time.Pow(2))}, true},
+	"Pa":  {Unit{1, Mass.Div(Length).Div(Time.Pow(2))}, true},
+	"J":   {Unit{1, Mass.Mul(Length.Pow(2)).Div(Time.Pow(2))}, true},
+	"W":   {Unit{1, Mass.Mul(Length.Pow(2)).Div(Time.Pow(3))}, true},
+	"C":   {Unit{1, Current.Mul(Time)}, true},
+	"V":   {Unit{1, Mass.Mul(Length.Pow(2)).Div(Time.Pow(3)).Div(Current)}, true},
+	"L":   {Unit{1e-3, Length.Pow(3)}, true},
+	"min": {Unit{60, Time}, false},
+	"h":   {Unit{3600, Time}, false},
+	"d":   {Unit{86400, Time}, false},
+	"in":  {Unit{0.0254, Length}, false},
+	"ft":  {Unit{0.3048, Length}, false},
+	"yd":  {Unit{0.9144, Length}, false},
+	"mi":  {Unit{1609.344, Length}, false},
+	"lb":  {Unit{0.45359237, Mass}, false},
+	"t":   {Unit{1000, Mass}, false},
+}
+
+// prefixes are SI prefixes, 'u' stands for micro.
+var prefixes = map[string]float64{
+	"Y":  1e24,
+	"Z":  1e21,
+	"E":  1e18,
+	"P":  1e15,
+	"T":  1e12,
+	"G":  1e9,
+	"M":  1e6,
+	"k":  1e3,
+	"h":  1e2,
+	"da": 1e1,
+	"d":  1e-1,
+	"c":  1e-2,
+	"m":  1e-3,
+	"u":  1e-6,
+	"n":  1e-9,
+	"p":  1e-12,
+	"f":  1e-15,
+	"a":  1e-18,
+	"z":  1e-21,
+	"y":  1e-24,
+}
+
+// Lookup finds a unit by its symbol, possibly with an SI prefix. Symbols of
+// units take precedence over prefixed ones, so 'min' is a minute and 'cd'
+// is a candela.
+func Lookup(symbol string) (Unit, bool) {
+	if u, ok := named[symbol]; ok {
+		return u.Unit, true
+	}
+
+	for prefix, factor := range prefixes {
+		base, ok := strings.CutPrefix(symbol, prefix)
+		if !ok {
+			continue
+		}
+
+		if u, ok := named[base]; ok && u.Prefixable {
+			return Unit{Factor: factor * u.Factor, Dim: u.Dim}, true
+		}
+	}
+
+	return Unit{}, false
+}
+
+func IsUnit(symbol string) bool {
+	_, ok := Lookup(symbol)
+	return ok
+}
@@ -0,0 +1,73 @@
+package units
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	cases := []struct {
+		symbol string
+		factor float64
+		dim    Dimension
+		ok     bool
+	}{
+		{symbol: "m", factor: 1, dim: Length, ok: true},
+		{symbol: "km", factor: 1000, dim: Length, ok: true},
+		{symbol: "kg", factor: 1, dim: Mass, ok: true},
+		{symbol: "dam", factor: 10, dim: Length, ok: true},
+		{symbol: "us", factor: 1e-6, dim: Time, ok: true},
+		{symbol: "min", factor: 60, dim: Time, ok: true},
+		{symbol: "cd", factor: 1, dim: Luminosity, ok: true},
+		{symbol: "mL", factor: 1e-6, dim: Length.Pow(3), ok: true},
+		{symbol: "kmi", ok: false},
+		{symbol: "parsec", ok: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.symbol, func(t *testing.T) {
+			u, ok := Lookup(tc.symbol)
+			if ok != tc.ok {
+				t.Fatalf("expected found %v, got %v", tc.ok, ok)
+			}
+
+			if !ok {
+				return
+			}
+
+			if math.Abs(u.Factor-tc.factor) > 1e-12*tc.factor || u.Dim != tc.dim {
+				t.Errorf("expected %v %s, got %v %s", tc.factor, tc.dim, u.Factor, u.Dim)
+			}
+		})
+	}
+}
+
+func TestDimension_String(t *testing.T) {
+	cases := []struct {
+		dim      Dimension
+		expected string
+	}{
+		{dim: Dimension{}, expected: "1"},
+		{dim: Length.Div(Time), expected: "m/s"},
+		{dim: Time.Pow(-1), expected: "1/s"},
+		{dim: Mass.Mul(Length.Pow(2)).Div(Time.Pow(2)), expected: "m^2*kg/s^2"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.expected, func(t *testing.T) {
+			if s := tc.dim.String(); s != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, s)
+			}
+		})
+	}
+}
+
+func TestDimension_Root(t *testing.T) {
+	if d, ok := Length.Pow(2).Root(2); !ok || d != Length {
+		t.Errorf("expected %s, got %s (%v)", Length, d, ok)
+	}
+
+	if _, ok := Length.Root(2); ok {
+		t.Error("expected no square root of length")
+	}
+}
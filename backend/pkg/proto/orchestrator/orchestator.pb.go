@@ -21,21 +21,299 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// Number is a value of an exact evaluation mode. Tasks of the float mode
+// use plain doubles instead.
+type Number struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Kind:
+	//
+	//	*Number_Decimal
+	//	*Number_Rational
+	//	*Number_Complex
+	//	*Number_Integer
+	Kind          isNumber_Kind `protobuf_oneof:"kind"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Number) Reset() {
+	*x = Number{}
+	mi := &file_orchestator_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Number) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Number) ProtoMessage() {}
+
+func (x *Number) ProtoReflect() protoreflect.Message {
+	mi := &file_orchestator_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Number.ProtoReflect.Descriptor instead.
+func (*Number) Descriptor() ([]byte, []int) {
+	return file_orchestator_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Number) GetKind() isNumber_Kind {
+	if x != nil {
+		return x.Kind
+	}
+	return nil
+}
+
+func (x *Number) GetDecimal() string {
+	if x != nil {
+		if x, ok := x.Kind.(*Number_Decimal); ok {
+			return x.Decimal
+		}
+	}
+	return ""
+}
+
+func (x *Number) GetRational() *Rational {
+	if x != nil {
+		if x, ok := x.Kind.(*Number_Rational); ok {
+			return x.Rational
+		}
+	}
+	return nil
+}
+
+func (x *Number) GetComplex() *Complex {
+	if x != nil {
+		if x, ok := x.Kind.(*Number_Complex); ok {
+			return x.Complex
+		}
+	}
+	return nil
+}
+
+func (x *Number) GetInteger() string {
+	if x != nil {
+		if x, ok := x.Kind.(*Number_Integer); ok {
+			return x.Integer
+		}
+	}
+	return ""
+}
+
+type isNumber_Kind interface {
+	isNumber_Kind()
+}
+
+type Number_Decimal struct {
+	Decimal string `protobuf:"bytes,1,opt,name=decimal,proto3,oneof"`
+}
+
+type Number_Rational struct {
+	Rational *Rational `protobuf:"bytes,2,opt,name=rational,proto3,oneof"`
+}
+
+type Number_Complex struct {
+	Complex *Complex `protobuf:"bytes,3,opt,name=complex,proto3,oneof"`
+}
+
+type Number_Integer struct {
+	// integer is an arbitrary-precision integer in base 10.
+	Integer string `protobuf:"bytes,4,opt,name=integer,proto3,oneof"`
+}
+
+func (*Number_Decimal) isNumber_Kind() {}
+
+func (*Number_Rational) isNumber_Kind() {}
+
+func (*Number_Complex) isNumber_Kind() {}
+
+func (*Number_Integer) isNumber_Kind() {}
+
+// Rational is a reduced fraction, its denominator is positive.
+type Rational struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Num           string                 `protobuf:"bytes,1,opt,name=num,proto3" json:"num,omitempty"`
+	Den           string                 `protobuf:"bytes,2,opt,name=den,proto3" json:"den,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Rational) Reset() {
+	*x = Rational{}
+	mi := &file_orchestator_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Rational) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Rational) ProtoMessage() {}
+
+func (x *Rational) ProtoReflect() protoreflect.Message {
+	mi := &file_orchestator_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Rational.ProtoReflect.Descriptor instead.
+func (*Rational) Descriptor() ([]byte, []int) {
+	return file_orchestator_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Rational) GetNum() string {
+	if x != nil {
+		return x.Num
+	}
+	return ""
+}
+
+func (x *Rational) GetDen() string {
+	if x != nil {
+		return x.Den
+	}
+	return ""
+}
+
+type Complex struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Re            float64                `protobuf:"fixed64,1,opt,name=re,proto3" json:"re,omitempty"`
+	Im            float64                `protobuf:"fixed64,2,opt,name=im,proto3" json:"im,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Complex) Reset() {
+	*x = Complex{}
+	mi := &file_orchestator_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Complex) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Complex) ProtoMessage() {}
+
+func (x *Complex) ProtoReflect() protoreflect.Message {
+	mi := &file_orchestator_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Complex.ProtoReflect.Descriptor instead.
+func (*Complex) Descriptor() ([]byte, []int) {
+	return file_orchestator_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Complex) GetRe() float64 {
+	if x != nil {
+		return x.Re
+	}
+	return 0
+}
+
+func (x *Complex) GetIm() float64 {
+	if x != nil {
+		return x.Im
+	}
+	return 0
+}
+
+type Precision struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Scale         int32                  `protobuf:"varint,1,opt,name=scale,proto3" json:"scale,omitempty"`
+	Rounding      string                 `protobuf:"bytes,2,opt,name=rounding,proto3" json:"rounding,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Precision) Reset() {
+	*x = Precision{}
+	mi := &file_orchestator_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Precision) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Precision) ProtoMessage() {}
+
+func (x *Precision) ProtoReflect() protoreflect.Message {
+	mi := &file_orchestator_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Precision.ProtoReflect.Descriptor instead.
+func (*Precision) Descriptor() ([]byte, []int) {
+	return file_orchestator_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Precision) GetScale() int32 {
+	if x != nil {
+		return x.Scale
+	}
+	return 0
+}
+
+func (x *Precision) GetRounding() string {
+	if x != nil {
+		return x.Rounding
+	}
+	return ""
+}
+
 type Task struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	LeftArg       float64                `protobuf:"fixed64,2,opt,name=left_arg,json=leftArg,proto3" json:"left_arg,omitempty"`
-	RightArg      float64                `protobuf:"fixed64,3,opt,name=right_arg,json=rightArg,proto3" json:"right_arg,omitempty"`
 	Op            string                 `protobuf:"bytes,4,opt,name=op,proto3" json:"op,omitempty"`
 	OperationTime int64                  `protobuf:"varint,5,opt,name=operation_time,json=operationTime,proto3" json:"operation_time,omitempty"`
 	Final         bool                   `protobuf:"varint,6,opt,name=final,proto3" json:"final,omitempty"`
+	Args          []float64              `protobuf:"fixed64,7,rep,packed,name=args,proto3" json:"args,omitempty"`
+	Numbers       []*Number              `protobuf:"bytes,8,rep,name=numbers,proto3" json:"numbers,omitempty"`
+	Precision     *Precision             `protobuf:"bytes,9,opt,name=precision,proto3" json:"precision,omitempty"`
+	// cancel tells the agent to abort the task with the id instead of
+	// computing a new one, the other fields are unset.
+	Cancel        bool `protobuf:"varint,10,opt,name=cancel,proto3" json:"cancel,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Task) Reset() {
 	*x = Task{}
-	mi := &file_orchestator_proto_msgTypes[0]
+	mi := &file_orchestator_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -47,7 +325,7 @@ func (x *Task) String() string {
 func (*Task) ProtoMessage() {}
 
 func (x *Task) ProtoReflect() protoreflect.Message {
-	mi := &file_orchestator_proto_msgTypes[0]
+	mi := &file_orchestator_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -60,7 +338,7 @@ func (x *Task) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Task.ProtoReflect.Descriptor instead.
 func (*Task) Descriptor() ([]byte, []int) {
-	return file_orchestator_proto_rawDescGZIP(), []int{0}
+	return file_orchestator_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *Task) GetId() string {
@@ -70,54 +348,71 @@ func (x *Task) GetId() string {
 	return ""
 }
 
-func (x *Task) GetLeftArg() float64 {
+func (x *Task) GetOp() string {
 	if x != nil {
-		return x.LeftArg
+		return x.Op
 	}
-	return 0
+	return ""
 }
 
-func (x *Task) GetRightArg() float64 {
+func (x *Task) GetOperationTime() int64 {
 	if x != nil {
-		return x.RightArg
+		return x.OperationTime
 	}
 	return 0
 }
 
-func (x *Task) GetOp() string {
+func (x *Task) GetFinal() bool {
 	if x != nil {
-		return x.Op
+		return x.Final
 	}
-	return ""
+	return false
 }
 
-func (x *Task) GetOperationTime() int64 {
+func (x *Task) GetArgs() []float64 {
 	if x != nil {
-		return x.OperationTime
+		return x.Args
 	}
-	return 0
+	return nil
 }
 
-func (x *Task) GetFinal() bool {
+func (x *Task) GetNumbers() []*Number {
 	if x != nil {
-		return x.Final
+		return x.Numbers
+	}
+	return nil
+}
+
+func (x *Task) GetPrecision() *Precision {
+	if x != nil {
+		return x.Precision
+	}
+	return nil
+}
+
+func (x *Task) GetCancel() bool {
+	if x != nil {
+		return x.Cancel
 	}
 	return false
 }
 
 type TaskResult struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Result        float64                `protobuf:"fixed64,2,opt,name=result,proto3" json:"result,omitempty"`
-	Status        string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
-	Final         bool                   `protobuf:"varint,4,opt,name=final,proto3" json:"final,omitempty"`
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Id     string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Result float64                `protobuf:"fixed64,2,opt,name=result,proto3" json:"result,omitempty"`
+	Status string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	Final  bool                   `protobuf:"varint,4,opt,name=final,proto3" json:"final,omitempty"`
+	Number *Number                `protobuf:"bytes,5,opt,name=number,proto3" json:"number,omitempty"`
+	// error describes why the task failed, it is set with the failure status.
+	Error         string `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *TaskResult) Reset() {
 	*x = TaskResult{}
-	mi := &file_orchestator_proto_msgTypes[1]
+	mi := &file_orchestator_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -129,7 +424,7 @@ func (x *TaskResult) String() string {
 func (*TaskResult) ProtoMessage() {}
 
 func (x *TaskResult) ProtoReflect() protoreflect.Message {
-	mi := &file_orchestator_proto_msgTypes[1]
+	mi := &file_orchestator_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -142,7 +437,7 @@ func (x *TaskResult) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use TaskResult.ProtoReflect.Descriptor instead.
 func (*TaskResult) Descriptor() ([]byte, []int) {
-	return file_orchestator_proto_rawDescGZIP(), []int{1}
+	return file_orchestator_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *TaskResult) GetId() string {
@@ -173,24 +468,59 @@ func (x *TaskResult) GetFinal() bool {
 	return false
 }
 
+func (x *TaskResult) GetNumber() *Number {
+	if x != nil {
+		return x.Number
+	}
+	return nil
+}
+
+func (x *TaskResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
 var File_orchestator_proto protoreflect.FileDescriptor
 
 const file_orchestator_proto_rawDesc = "" +
 	"\n" +
-	"\x11orchestator.proto\"\x9b\x01\n" +
+	"\x11orchestator.proto\"\x97\x01\n" +
+	"\x06Number\x12\x1a\n" +
+	"\adecimal\x18\x01 \x01(\tH\x00R\adecimal\x12'\n" +
+	"\brational\x18\x02 \x01(\v2\t.RationalH\x00R\brational\x12$\n" +
+	"\acomplex\x18\x03 \x01(\v2\b.ComplexH\x00R\acomplex\x12\x1a\n" +
+	"\ainteger\x18\x04 \x01(\tH\x00R\aintegerB\x06\n" +
+	"\x04kind\".\n" +
+	"\bRational\x12\x10\n" +
+	"\x03num\x18\x01 \x01(\tR\x03num\x12\x10\n" +
+	"\x03den\x18\x02 \x01(\tR\x03den\")\n" +
+	"\aComplex\x12\x0e\n" +
+	"\x02re\x18\x01 \x01(\x01R\x02re\x12\x0e\n" +
+	"\x02im\x18\x02 \x01(\x01R\x02im\"=\n" +
+	"\tPrecision\x12\x14\n" +
+	"\x05scale\x18\x01 \x01(\x05R\x05scale\x12\x1a\n" +
+	"\brounding\x18\x02 \x01(\tR\brounding\"\xfd\x01\n" +
 	"\x04Task\x12\x0e\n" +
-	"\x02id\x18\x01 \x01(\tR\x02id\x12\x19\n" +
-	"\bleft_arg\x18\x02 \x01(\x01R\aleftArg\x12\x1b\n" +
-	"\tright_arg\x18\x03 \x01(\x01R\brightArg\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x0e\n" +
 	"\x02op\x18\x04 \x01(\tR\x02op\x12%\n" +
 	"\x0eoperation_time\x18\x05 \x01(\x03R\roperationTime\x12\x14\n" +
-	"\x05final\x18\x06 \x01(\bR\x05final\"b\n" +
+	"\x05final\x18\x06 \x01(\bR\x05final\x12\x12\n" +
+	"\x04args\x18\a \x03(\x01R\x04args\x12!\n" +
+	"\anumbers\x18\b \x03(\v2\a.NumberR\anumbers\x12(\n" +
+	"\tprecision\x18\t \x01(\v2\n" +
+	".PrecisionR\tprecision\x12\x16\n" +
+	"\x06cancel\x18\n" +
+	" \x01(\bR\x06cancelJ\x04\b\x02\x10\x03J\x04\b\x03\x10\x04R\bleft_argR\tright_arg\"\x99\x01\n" +
 	"\n" +
 	"TaskResult\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
 	"\x06result\x18\x02 \x01(\x01R\x06result\x12\x16\n" +
 	"\x06status\x18\x03 \x01(\tR\x06status\x12\x14\n" +
-	"\x05final\x18\x04 \x01(\bR\x05final26\n" +
+	"\x05final\x18\x04 \x01(\bR\x05final\x12\x1f\n" +
+	"\x06number\x18\x05 \x01(\v2\a.NumberR\x06number\x12\x14\n" +
+	"\x05error\x18\x06 \x01(\tR\x05error26\n" +
 	"\fOrchestrator\x12&\n" +
 	"\fProcessTasks\x12\v.TaskResult\x1a\x05.Task(\x010\x01B Z\x1ebackend/pkg/proto/orchestratorb\x06proto3"
 
@@ -206,19 +536,28 @@ func file_orchestator_proto_rawDescGZIP() []byte {
 	return file_orchestator_proto_rawDescData
 }
 
-var file_orchestator_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_orchestator_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
 var file_orchestator_proto_goTypes = []any{
-	(*Task)(nil),       // 0: Task
-	(*TaskResult)(nil), // 1: TaskResult
+	(*Number)(nil),     // 0: Number
+	(*Rational)(nil),   // 1: Rational
+	(*Complex)(nil),    // 2: Complex
+	(*Precision)(nil),  // 3: Precision
+	(*Task)(nil),       // 4: Task
+	(*TaskResult)(nil), // 5: TaskResult
 }
 var file_orchestator_proto_depIdxs = []int32{
-	1, // 0: Orchestrator.ProcessTasks:input_type -> TaskResult
-	0, // 1: Orchestrator.ProcessTasks:output_type -> Task
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	1, // 0: Number.rational:type_name -> Rational
+	2, // 1: Number.complex:type_name -> Complex
+	0, // 2: Task.numbers:type_name -> Number
+	3, // 3: Task.precision:type_name -> Precision
+	0, // 4: TaskResult.number:type_name -> Number
+	5, // 5: Orchestrator.ProcessTasks:input_type -> TaskResult
+	4, // 6: Orchestrator.ProcessTasks:output_type -> Task
+	6, // [6:7] is the sub-list for method output_type
+	5, // [5:6] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_orchestator_proto_init() }
@@ -226,13 +565,19 @@ func file_orchestator_proto_init() {
 	if File_orchestator_proto != nil {
 		return
 	}
+	file_orchestator_proto_msgTypes[0].OneofWrappers = []any{
+		(*Number_Decimal)(nil),
+		(*Number_Rational)(nil),
+		(*Number_Complex)(nil),
+		(*Number_Integer)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_orchestator_proto_rawDesc), len(file_orchestator_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   6,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
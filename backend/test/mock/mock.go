@@ -10,7 +10,9 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 	"io"
+	"slices"
 	"sync"
+	"time"
 )
 
 type OrchestratorMock struct {
@@ -23,10 +25,9 @@ func (o *OrchestratorMock) GetTask(_ context.Context) (*ma.AgentTask, error) {
 	}
 
 	return &ma.AgentTask{
-		Id:       fmt.Sprint(10),
-		Op:       "+",
-		LeftArg:  2.0,
-		RightArg: 3.0,
+		Id:   fmt.Sprint(10),
+		Op:   "+",
+		Args: []float64{2.0, 3.0},
 	}, nil
 }
 
@@ -42,7 +43,7 @@ type CalculatorMock struct {
 	Err error
 }
 
-func (c *CalculatorMock) Evaluate(task *ma.AgentTask) (*ma.TaskResult, error) {
+func (c *CalculatorMock) Evaluate(_ context.Context, task *ma.AgentTask) (*ma.TaskResult, error) {
 	fmt.Println("evaluate called: ", task)
 
 	if c.Err != nil {
@@ -71,8 +72,54 @@ func (s ServiceMock) Login(_ context.Context, _ *mo.UserCredentials) (*mo.JWTTok
 }
 
 func (s ServiceMock) GetUserID(_ context.Context, _ string) (string, error) {
-	//TODO implement me
-	panic("implement me")
+	return "user", nil
+}
+
+func (s ServiceMock) GetUser(_ context.Context, id string) (*mo.UserView, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	return &mo.UserView{
+		Id:       id,
+		Username: "user",
+	}, nil
+}
+
+func (s ServiceMock) AddVariable(_ context.Context, _ string, _ *mo.Variable) error {
+	return s.Err
+}
+
+func (s ServiceMock) GetVariable(_ context.Context, _, name string) (*mo.Variable, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	return &mo.Variable{
+		Name:  name,
+		Value: 1,
+	}, nil
+}
+
+func (s ServiceMock) GetVariables(_ context.Context, _ string) ([]*mo.Variable, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	return []*mo.Variable{
+		{
+			Name:  "rate",
+			Value: 0.07,
+		},
+	}, nil
+}
+
+func (s ServiceMock) UpdateVariable(_ context.Context, _ string, _ *mo.Variable) error {
+	return s.Err
+}
+
+func (s ServiceMock) DeleteVariable(_ context.Context, _, _ string) error {
+	return s.Err
 }
 
 func (s ServiceMock) VerifyJWT(_ context.Context, _ string) error {
@@ -85,7 +132,7 @@ func (s ServiceMock) RefreshTokens(_ context.Context, _ string) (string, string,
 	panic("implement me")
 }
 
-func (s ServiceMock) Evaluate(_ context.Context, _, _ string) (string, error) {
+func (s ServiceMock) Evaluate(_ context.Context, _ *mo.CalculateRequest, _ string) (string, error) {
 	if s.Err != nil {
 		return "", s.Err
 	}
@@ -95,6 +142,50 @@ func (s ServiceMock) Evaluate(_ context.Context, _, _ string) (string, error) {
 	return id.String(), nil
 }
 
+func (s ServiceMock) GetDeadLetters(_ context.Context, _ string) ([]*mo.DeadLetter, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	return []*mo.DeadLetter{
+		{
+			ID:      "exp:1",
+			ExpID:   "exp",
+			Op:      "+",
+			Retries: 3,
+			Reason:  "lease expired",
+		},
+	}, nil
+}
+
+func (s ServiceMock) RequeueDeadLetter(_ context.Context, _, _ string) error {
+	return s.Err
+}
+
+func (s ServiceMock) Cancel(_ context.Context, _, _ string) error {
+	return s.Err
+}
+
+func (s ServiceMock) Cancellations(_ string) (<-chan string, func()) {
+	return nil, func() {}
+}
+
+func (s ServiceMock) Explain(_ context.Context, req *mo.CalculateRequest, _ string) (*mo.Explanation, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	return &mo.Explanation{
+		Expression: req.Expression,
+		Mode:       "float",
+		Tasks: []mo.ExplainedTask{
+			{ID: "t0", Op: "+", Args: []string{"2", "2"}, Dependencies: []string{}, Final: true, Ready: true, Duration: 1, Critical: true},
+		},
+		CriticalPath: 1,
+		Duration:     1,
+	}, nil
+}
+
 func (s ServiceMock) Get(_ context.Context, _, _ string) (*mo.Expression, error) {
 	if s.Err != nil {
 		return nil, s.Err
@@ -121,15 +212,14 @@ func (s ServiceMock) GetAll(_ context.Context, _, _ string, _ int64) ([]*mo.Expr
 	}, nil
 }
 
-func (s ServiceMock) GetTask(_ context.Context) (*mo.AgentTask, error) {
+func (s ServiceMock) GetTask(_ context.Context, _ string) (*mo.AgentTask, error) {
 	if s.Err != nil {
 		return nil, s.Err
 	}
 
 	return &mo.AgentTask{
 		Id:            fmt.Sprint(10),
-		LeftArg:       10,
-		RightArg:      10,
+		Args:          []float64{10, 10},
 		Op:            "+",
 		OperationTime: 0,
 		Final:         true,
@@ -144,6 +234,14 @@ func (s ServiceMock) FinishTask(_ context.Context, _ *mo.TaskResult) error {
 	return nil
 }
 
+func (s ServiceMock) RequeueExpired(_ context.Context) (int64, error) {
+	return 0, s.Err
+}
+
+func (s ServiceMock) ReleaseTasks(_ context.Context, _ string) (int64, error) {
+	return 0, s.Err
+}
+
 func (s ServiceMock) Finalize(_ context.Context, _ string, _ float64) error {
 	if s.Err != nil {
 		return s.Err
@@ -272,17 +370,23 @@ type Repository struct {
 	expMu sync.RWMutex
 
 	taskM  map[string]*mo.Task
+	deadM  map[string]*mo.DeadLetter
 	taskMu sync.RWMutex
 
 	usersM  map[string]*mo.User
 	usersMu sync.RWMutex
+
+	varsM  map[string]map[string]*mo.Variable
+	varsMu sync.RWMutex
 }
 
 func NewRepository() *Repository {
 	return &Repository{
 		expM:   make(map[string]*mo.Expression),
 		taskM:  make(map[string]*mo.Task),
+		deadM:  make(map[string]*mo.DeadLetter),
 		usersM: make(map[string]*mo.User),
+		varsM:  make(map[string]map[string]*mo.Variable),
 	}
 }
 
@@ -321,6 +425,20 @@ func (rm *Repository) GetAll(_ context.Context, _, _ string, _ int64) ([]*mo.Exp
 	return expressions, nil
 }
 
+func (rm *Repository) CountPending(_ context.Context, userID string) (int64, error) {
+	rm.expMu.RLock()
+	defer rm.expMu.RUnlock()
+
+	var n int64
+	for _, exp := range rm.expM {
+		if exp.UserID == userID && exp.Status == "pending" {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
 func (rm *Repository) AddTasks(_ context.Context, tasks []*mo.Task) error {
 	rm.taskMu.Lock()
 	defer rm.taskMu.Unlock()
@@ -330,44 +448,224 @@ func (rm *Repository) AddTasks(_ context.Context, tasks []*mo.Task) error {
 	return nil
 }
 
-func (rm *Repository) GetTask(_ context.Context) (*mo.Task, error) {
-	rm.taskMu.RLock()
-	defer rm.taskMu.RUnlock()
+func (rm *Repository) ClaimTask(_ context.Context, claimant string, lease time.Duration) (*mo.Task, error) {
+	rm.taskMu.Lock()
+	defer rm.taskMu.Unlock()
+	now := time.Now()
 	for _, task := range rm.taskM {
-		if task.Status == "ready" {
+		if task.Status == "ready" && !task.RetryAt.After(now) {
+			task.Status = "in_progress"
+			task.ClaimedBy = claimant
+			task.ClaimedAt = now
+			task.LeaseUntil = task.ClaimedAt.Add(lease)
 			return task, nil
 		}
 	}
 	return nil, fmt.Errorf("%w: no ready task found", errors.ErrNoTasks)
 }
 
+func (rm *Repository) ExpiredTasks(_ context.Context, now time.Time) ([]*mo.Task, error) {
+	return rm.claimed(func(t *mo.Task) bool { return t.LeaseUntil.Before(now) }), nil
+}
+
+func (rm *Repository) ClaimedTasks(_ context.Context, claimant string) ([]*mo.Task, error) {
+	return rm.claimed(func(t *mo.Task) bool { return t.ClaimedBy == claimant }), nil
+}
+
+func (rm *Repository) RunningTasks(_ context.Context, expID string) ([]*mo.Task, error) {
+	return rm.claimed(func(t *mo.Task) bool { return t.ExpID == expID }), nil
+}
+
+// claimed lists copies of claimed tasks matching f.
+func (rm *Repository) claimed(f func(t *mo.Task) bool) []*mo.Task {
+	rm.taskMu.RLock()
+	defer rm.taskMu.RUnlock()
+
+	var tasks []*mo.Task
+	for _, t := range rm.taskM {
+		if t.Status == "in_progress" && f(t) {
+			c := *t
+			tasks = append(tasks, &c)
+		}
+	}
+
+	return tasks
+}
+
+func (rm *Repository) RequeueTask(_ context.Context, task *mo.Task) error {
+	rm.taskMu.Lock()
+	defer rm.taskMu.Unlock()
+
+	t, ok := rm.taskM[task.ID]
+	if !ok || t.Status != "in_progress" || t.ClaimedBy != task.ClaimedBy {
+		return nil
+	}
+
+	t.Status = "ready"
+	t.ClaimedBy = ""
+	t.ClaimedAt = time.Time{}
+	t.LeaseUntil = time.Time{}
+	t.Retries = task.Retries
+	t.RetryAt = task.RetryAt
+
+	return nil
+}
+
+func (rm *Repository) AddDeadLetter(_ context.Context, dl *mo.DeadLetter) error {
+	rm.taskMu.Lock()
+	defer rm.taskMu.Unlock()
+
+	t, ok := rm.taskM[dl.ID]
+	if !ok || t.Status != "in_progress" || t.ClaimedBy != dl.Task.ClaimedBy {
+		return nil
+	}
+
+	delete(rm.taskM, dl.ID)
+	rm.deadM[dl.ID] = dl
+
+	rm.expMu.Lock()
+	if exp, ok := rm.expM[dl.ExpID]; ok && exp.Status == "pending" {
+		exp.Status = "stalled"
+	}
+	rm.expMu.Unlock()
+
+	return nil
+}
+
+func (rm *Repository) GetDeadLetters(_ context.Context) ([]*mo.DeadLetter, error) {
+	rm.taskMu.RLock()
+	defer rm.taskMu.RUnlock()
+
+	dls := make([]*mo.DeadLetter, 0, len(rm.deadM))
+	for _, dl := range rm.deadM {
+		dls = append(dls, dl)
+	}
+
+	return dls, nil
+}
+
+func (rm *Repository) RequeueDeadLetter(_ context.Context, id string) error {
+	rm.taskMu.Lock()
+	defer rm.taskMu.Unlock()
+
+	dl, ok := rm.deadM[id]
+	if !ok {
+		return fmt.Errorf("%w: dead letter %s not found", errors.ErrNoTasks, id)
+	}
+
+	task := *dl.Task
+	task.Status = "ready"
+	task.ClaimedBy = ""
+	task.ClaimedAt = time.Time{}
+	task.LeaseUntil = time.Time{}
+	task.Retries = 0
+	task.RetryAt = time.Time{}
+
+	delete(rm.deadM, id)
+	rm.taskM[id] = &task
+
+	for _, left := range rm.deadM {
+		if left.ExpID == dl.ExpID {
+			return nil
+		}
+	}
+
+	rm.expMu.Lock()
+	if exp, ok := rm.expM[dl.ExpID]; ok && exp.Status == "stalled" {
+		exp.Status = "pending"
+	}
+	rm.expMu.Unlock()
+
+	return nil
+}
+
+func (rm *Repository) GetTask(_ context.Context, id string) (*mo.Task, error) {
+	rm.taskMu.RLock()
+	defer rm.taskMu.RUnlock()
+
+	task, ok := rm.taskM[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: task %s not found", errors.ErrNoTasks, id)
+	}
+
+	return task, nil
+}
+
+// Task returns the stored task with the given ID, nil if there is none.
+func (rm *Repository) Task(id string) *mo.Task {
+	rm.taskMu.RLock()
+	defer rm.taskMu.RUnlock()
+
+	return rm.taskM[id]
+}
+
 func (rm *Repository) UpdateTask(_ context.Context, task *mo.Task) error {
 	rm.taskMu.Lock()
 	defer rm.taskMu.Unlock()
-	rm.taskM[task.ID] = task
-
-	for id, t := range rm.taskM {
-		if t.LeftID != nil && *t.LeftID == task.ID {
-			t.LeftArg = task.Result
-			t.LeftID = nil
-			if t.LeftID == nil && t.RightID == nil {
-				t.Status = "ready"
+
+	done, ok := rm.taskM[task.ID]
+	if ok && done.Name != "" {
+		rm.expMu.Lock()
+		if exp, ok := rm.expM[done.ExpID]; ok {
+			if exp.Values == nil {
+				exp.Values = make(map[string]float64)
 			}
-			rm.taskM[id] = t
+			exp.Values[done.Name] = task.Result
 		}
-		if t.RightID != nil && *t.RightID == task.ID {
-			t.RightArg = task.Result
-			t.RightID = nil
-			if t.LeftID == nil && t.RightID == nil {
-				t.Status = "ready"
+		rm.expMu.Unlock()
+	}
+	delete(rm.taskM, task.ID)
+
+	if ok && done.Condition {
+		rm.chooseBranch(task.ID, task.IsTrue())
+	}
+
+	for _, t := range rm.taskM {
+		if t.ExpID != task.ExpID {
+			continue
+		}
+
+		waiting := false
+		for i := range t.Args {
+			if t.Args[i].TaskID != nil && *t.Args[i].TaskID == task.ID {
+				t.Args[i].Value = task.Result
+				t.Args[i].Number = task.Number
+				t.Args[i].TaskID = nil
 			}
-			rm.taskM[id] = t
+			waiting = waiting || t.Args[i].TaskID != nil
+		}
+
+		if !waiting && len(t.Guards) == 0 && t.Status != "in_progress" {
+			t.Status = "ready"
 		}
 	}
 
 	return nil
 }
 
+func (rm *Repository) chooseBranch(condID string, branch bool) {
+	discarded := mo.BranchGuard(condID, !branch)
+	chosen := mo.BranchGuard(condID, branch)
+
+	for _, t := range rm.taskM {
+		if slices.Contains(t.Guards, discarded) {
+			delete(rm.taskM, t.ID)
+			continue
+		}
+
+		t.Guards = slices.DeleteFunc(t.Guards, func(g string) bool { return g == chosen })
+
+		if t.Op == "if" && t.Args[0].TaskID != nil && *t.Args[0].TaskID == condID {
+			t.Op = ""
+			if branch {
+				t.Args = t.Args[1:2]
+			} else {
+				t.Args = t.Args[2:3]
+			}
+		}
+	}
+}
+
 func (rm *Repository) DeleteTasks(_ context.Context, expID string) error {
 	rm.taskMu.Lock()
 	defer rm.taskMu.Unlock()
@@ -378,6 +676,12 @@ func (rm *Repository) DeleteTasks(_ context.Context, expID string) error {
 		}
 	}
 
+	for _, dl := range rm.deadM {
+		if dl.ExpID == expID {
+			delete(rm.deadM, dl.ID)
+		}
+	}
+
 	return nil
 }
 
@@ -385,6 +689,20 @@ func (rm *Repository) Update(_ context.Context, exp *mo.Expression) error {
 	rm.expMu.Lock()
 	defer rm.expMu.Unlock()
 
+	if old, ok := rm.expM[exp.Id]; ok {
+		if old.Status != "pending" && old.Status != "stalled" {
+			return nil
+		}
+		old.Result = exp.Result
+		old.Decimal = exp.Decimal
+		old.Fraction = exp.Fraction
+		old.Complex = exp.Complex
+		old.Integer = exp.Integer
+		old.Status = exp.Status
+		old.Error = exp.Error
+		old.FailedExpression = exp.FailedExpression
+		return nil
+	}
 	rm.expM[exp.Id] = exp
 
 	return nil
@@ -410,5 +728,78 @@ func (rm *Repository) GetUser(_ context.Context, login string) (*mo.User, error)
 }
 
 func (rm *Repository) GetUserByID(_ context.Context, userID string) (*mo.User, error) {
-	return &mo.User{}, nil
+	rm.usersMu.RLock()
+	defer rm.usersMu.RUnlock()
+
+	for _, user := range rm.usersM {
+		if user.Id == userID {
+			return user, nil
+		}
+	}
+
+	return nil, errors.ErrUnauthorized
+}
+
+func (rm *Repository) AddVariable(_ context.Context, v *mo.Variable) error {
+	rm.varsMu.Lock()
+	defer rm.varsMu.Unlock()
+
+	if _, ok := rm.varsM[v.UserID][v.Name]; ok {
+		return errors.ErrVariableAlreadyExists
+	}
+
+	if rm.varsM[v.UserID] == nil {
+		rm.varsM[v.UserID] = make(map[string]*mo.Variable)
+	}
+	rm.varsM[v.UserID][v.Name] = v
+
+	return nil
+}
+
+func (rm *Repository) GetVariable(_ context.Context, userID, name string) (*mo.Variable, error) {
+	rm.varsMu.RLock()
+	defer rm.varsMu.RUnlock()
+
+	v, ok := rm.varsM[userID][name]
+	if !ok {
+		return nil, errors.ErrVariableDoesNotExist
+	}
+
+	return v, nil
+}
+
+func (rm *Repository) GetVariables(_ context.Context, userID string) ([]*mo.Variable, error) {
+	rm.varsMu.RLock()
+	defer rm.varsMu.RUnlock()
+
+	vars := make([]*mo.Variable, 0, len(rm.varsM[userID]))
+	for _, v := range rm.varsM[userID] {
+		vars = append(vars, v)
+	}
+
+	return vars, nil
+}
+
+func (rm *Repository) UpdateVariable(_ context.Context, v *mo.Variable) error {
+	rm.varsMu.Lock()
+	defer rm.varsMu.Unlock()
+
+	if _, ok := rm.varsM[v.UserID][v.Name]; !ok {
+		return errors.ErrVariableDoesNotExist
+	}
+	rm.varsM[v.UserID][v.Name] = v
+
+	return nil
+}
+
+func (rm *Repository) DeleteVariable(_ context.Context, userID, name string) error {
+	rm.varsMu.Lock()
+	defer rm.varsMu.Unlock()
+
+	if _, ok := rm.varsM[userID][name]; !ok {
+		return errors.ErrVariableDoesNotExist
+	}
+	delete(rm.varsM[userID], name)
+
+	return nil
 }
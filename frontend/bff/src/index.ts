@@ -108,6 +108,11 @@ const calculator = new Elysia()
             })
 
             if (!res.ok) {
+                // Errors of expressions are JSON with the position of the offending part
+                if (res.headers.get("Content-Type")?.startsWith("application/json")) {
+                    return error(res.status, await res.json())
+                }
+
                 error(res.status)
                 return
             }